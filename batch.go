@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korylprince/drive-archive/drive"
+)
+
+// readUsers reads the emails in the first column of the CSV file at path. Blank lines, lines starting with #,
+// and a header row without an email are skipped
+func readUsers(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open users file: %w", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+
+	var users []string
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return users, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read users file: %w", err)
+		}
+		user := strings.TrimSpace(row[0])
+		if user == "" {
+			continue
+		}
+		if !strings.Contains(user, "@") {
+			if len(users) == 0 {
+				// header
+				continue
+			}
+			line, _ := r.FieldPos(0)
+			return nil, fmt.Errorf("invalid email on line %d: %s", line, user)
+		}
+		users = append(users, user)
+	}
+}
+
+// userDir returns the name of the subdirectory user is archived to
+func userDir(user string) string {
+	return drive.ValidPathChars.ReplaceAllString(strings.ToLower(user), "")
+}
+
+// user statuses in the batch report
+const (
+	userArchived = "archived"
+	userErrors   = "archived with errors"
+	// userIncomplete users were drained before all of their files were downloaded
+	userIncomplete = "incomplete"
+	userSkipped    = "skipped"
+	userFailed     = "failed"
+	userNotStarted = "not started"
+)
+
+// userResult is the result of archiving a user in a batch
+type userResult struct {
+	User     string
+	Status   string
+	Attempts int
+	Duration time.Duration
+	Err      error
+}
+
+// batchError is returned by runBatch when users fail
+type batchError struct {
+	Failed int
+	Total  int
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("%d of %d users failed", e.Failed, e.Total)
+}
+
+// partial returns true if some users were archived
+func (e *batchError) partial() bool {
+	return e.Failed < e.Total
+}
+
+// writeBatchReport writes the status of each user to the CSV file at path
+func writeBatchReport(path string, results []*userResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create report: %w", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"user", "status", "attempts", "duration", "error"})
+	for _, r := range results {
+		msg := ""
+		if r.Err != nil {
+			msg = drive.Redact(r.Err.Error())
+		}
+		w.Write([]string{r.User, r.Status, strconv.Itoa(r.Attempts), r.Duration.Round(time.Second).String(), msg})
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return fmt.Errorf("could not write report: %w", err)
+	}
+	return f.Close()
+}
+
+// runBatch archives each user to a subdirectory of cfg.Out, archiving up to parallel users at once.
+// A failed user doesn't stop the batch. Failed users are retried once after every user has been tried, and users
+// without Drive access are skipped. The status of each user is written to batch_report.csv in cfg.Out, and a
+// *batchError is returned if any users failed or were drained before they finished. Users archived with failed files and
+// drained users aren't retried, and a *failedFilesError totaling their failed files is returned if no users failed
+func runBatch(ctx context.Context, cfg *config, users []string, parallel int) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]*userResult, len(users))
+	for i, user := range users {
+		results[i] = &userResult{User: user, Status: userNotStarted}
+	}
+
+	archive := func(pending []*userResult) {
+		wg := new(sync.WaitGroup)
+		sem := make(chan struct{}, parallel)
+		for i, r := range pending {
+			sem <- struct{}{}
+			if ctx.Err() != nil {
+				break
+			}
+			if cfg.Control != nil && cfg.Control.Draining() {
+				fmt.Println("drained: stopped before all users were archived")
+				break
+			}
+
+			c := *cfg
+			c.User = r.User
+			c.Out = filepath.Join(cfg.Out, userDir(r.User))
+			if cfg.Report != "" {
+				c.Report = filepath.Join(c.Out, filepath.Base(cfg.Report))
+			}
+			if cfg.B2 != "" {
+				c.B2 = path.Join(cfg.B2, userDir(r.User))
+			}
+			fmt.Printf("archiving user %s (%d of %d) to %s\n", r.User, i+1, len(pending), c.Out)
+
+			wg.Add(1)
+			go func(r *userResult) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				start := time.Now()
+				err := os.MkdirAll(c.Out, 0755)
+				if err == nil {
+					err = run(ctx, &c)
+				}
+				r.Attempts++
+				r.Duration += time.Since(start)
+				r.Err = err
+				switch {
+				case err == nil:
+					r.Status = userArchived
+				case errors.Is(err, drive.ErrDrained):
+					// drained users are resumed by the next run, not by retrying the user
+					r.Status = userIncomplete
+					fmt.Printf("stopped archiving %s: %v\n", r.User, err)
+				case errors.As(err, new(*failedFilesError)):
+					// failed files are retried by the next run, not by retrying the user
+					r.Status = userErrors
+					fmt.Printf("archived %s with errors: %v\n", r.User, err)
+				case errors.Is(err, drive.ErrNoDriveAccess):
+					r.Status = userSkipped
+					fmt.Printf("skipping user %s: %v\n", r.User, err)
+					// only removes the user's directory if nothing was written to it
+					os.Remove(c.Out)
+				default:
+					r.Status = userFailed
+					fmt.Printf("could not archive %s: %v\n", r.User, drive.Redact(err.Error()))
+				}
+			}(r)
+		}
+		wg.Wait()
+	}
+
+	archive(results)
+
+	var failed []*userResult
+	for _, r := range results {
+		if r.Status == userFailed {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) > 0 && ctx.Err() == nil && (cfg.Control == nil || !cfg.Control.Draining()) {
+		fmt.Printf("retrying %d failed users\n", len(failed))
+		archive(failed)
+	}
+
+	counts := make(map[string]int)
+	var skipped []string
+	for _, r := range results {
+		counts[r.Status]++
+		if r.Status == userSkipped {
+			skipped = append(skipped, r.User)
+		}
+	}
+	summary := fmt.Sprintf("%d users archived (%d with failed files), %d incomplete, %d failed, %d skipped, %d not started",
+		counts[userArchived]+counts[userErrors], counts[userErrors], counts[userIncomplete], counts[userFailed], counts[userSkipped],
+		counts[userNotStarted])
+	fmt.Println(summary)
+	if len(skipped) > 0 {
+		sort.Strings(skipped)
+		fmt.Printf("skipped %d users without Drive access: %s\n", len(skipped), strings.Join(skipped, ", "))
+	}
+	cfg.Notifier.post(summary)
+
+	if !cfg.DryRun {
+		if err := writeBatchReport(filepath.Join(cfg.Out, "batch_report.csv"), results); err != nil {
+			fmt.Println("could not write batch report:", err)
+		}
+	}
+
+	if n := counts[userFailed] + counts[userIncomplete] + counts[userNotStarted]; n > 0 {
+		return &batchError{Failed: n, Total: len(results)}
+	}
+	if counts[userErrors] > 0 {
+		files := 0
+		for _, r := range results {
+			var fErr *failedFilesError
+			if errors.As(r.Err, &fErr) {
+				files += fErr.Failed
+			}
+		}
+		return &failedFilesError{Failed: files, Report: "errors.csv in each user's directory"}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/korylprince/drive-archive/drive"
+)
+
+// handleControl reads newline separated commands from conn and applies them to c. status-json writes st's status as a single line of JSON
+func handleControl(conn net.Conn, c *drive.Control, st *drive.RunState) {
+	defer conn.Close()
+	s := bufio.NewScanner(conn)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToLower(fields[0]) {
+		case "pause":
+			c.Pause()
+			fmt.Println("control: paused")
+		case "resume":
+			c.Resume()
+			fmt.Println("control: resumed")
+		case "drain":
+			c.Drain()
+			fmt.Println("control: draining")
+		case "set-concurrency":
+			if len(fields) != 2 {
+				fmt.Fprintln(conn, "error: usage: set-concurrency <n>")
+				continue
+			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				fmt.Fprintln(conn, "error: invalid concurrency:", err)
+				continue
+			}
+			c.SetConcurrency(n)
+			fmt.Println("control: set concurrency to", n)
+		case "status":
+		case "status-json":
+			if err := json.NewEncoder(conn).Encode(st.Status()); err != nil {
+				return
+			}
+			continue
+		default:
+			fmt.Fprintln(conn, "error: unknown command. Commands are pause, resume, drain, set-concurrency <n>, status, and status-json")
+			continue
+		}
+		fmt.Fprintln(conn, "ok:", c.Status())
+	}
+}
+
+// serveMetrics serves m at /metrics on addr. The returned listener should be closed when the run is finished
+func serveMetrics(addr string, m *drive.Metrics) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go http.Serve(l, mux)
+
+	return l, nil
+}
+
+// serveControl listens for control commands on the unix socket at path.
+// The returned listener should be closed when the run is finished
+func serveControl(path string, c *drive.Control, st *drive.RunState) (net.Listener, error) {
+	// remove stale socket
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not remove existing socket: %w", err)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen: %w", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleControl(conn, c, st)
+		}
+	}()
+
+	return l, nil
+}
+
+// printStatus prints the JSON status of the run with the control socket at path
+func printStatus(path string) error {
+	conn, err := net.DialTimeout("unix", path, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("could not connect: %w", err)
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(30 * time.Second)); err != nil {
+		return fmt.Errorf("could not set deadline: %w", err)
+	}
+
+	if _, err = fmt.Fprintln(conn, "status-json"); err != nil {
+		return fmt.Errorf("could not write command: %w", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("could not read status: %w", err)
+	}
+	if strings.HasPrefix(line, "error:") {
+		return errors.New(strings.TrimSpace(strings.TrimPrefix(line, "error:")))
+	}
+
+	fmt.Print(line)
+	return nil
+}
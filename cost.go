@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/korylprince/drive-archive/drive"
+)
+
+// gib is the number of bytes in the GiB used by cloud price sheets
+const gib = 1 << 30
+
+// priceSheet is the format of the -price-sheet file. Prices are per GiB (2^30 bytes) unless noted
+type priceSheet struct {
+	// Currency is printed with estimates, e.g. USD
+	Currency string `json:"currency"`
+	// Egress is the price of transferring downloaded bytes out of the network the archive runs in, e.g. from a cloud VM to on-premises storage
+	Egress float64 `json:"egress_per_gib"`
+	// Storage is the monthly price of storing the archive, e.g. an object storage class
+	Storage float64 `json:"storage_per_gib_month"`
+	// Requests is the price of 1000 write requests to the storage, e.g. one upload per file
+	Requests float64 `json:"requests_per_1000"`
+	// Months is the number of months storage is estimated for. If zero, 12 months are used
+	Months int `json:"months"`
+}
+
+// readPriceSheet reads the price sheet at path
+func readPriceSheet(path string) (*priceSheet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open price sheet: %w", err)
+	}
+	defer f.Close()
+
+	p := new(priceSheet)
+	if err = json.NewDecoder(f).Decode(p); err != nil {
+		return nil, fmt.Errorf("could not decode price sheet: %w", err)
+	}
+	if p.Egress < 0 || p.Storage < 0 || p.Requests < 0 || p.Months < 0 {
+		return nil, errors.New("prices and months cannot be negative")
+	}
+	if p.Months == 0 {
+		p.Months = 12
+	}
+
+	return p, nil
+}
+
+// estimate returns a human readable estimate of the cost of a run, from the Stats of a dry run.
+// Transfer is priced from the bytes that would be downloaded, and storage from the size of the whole archive
+func (p *priceSheet) estimate(stats *drive.Stats) string {
+	archived := float64(stats.CapturedBytes) / gib
+	downloaded := float64(stats.DownloadedBytes) / gib
+	egress := downloaded * p.Egress
+	requests := float64(stats.Downloaded) / 1000 * p.Requests
+	monthly := archived * p.Storage
+	total := egress + requests + monthly*float64(p.Months)
+
+	b := new(strings.Builder)
+	currency := ""
+	if p.Currency != "" {
+		currency = p.Currency + ", "
+	}
+	fmt.Fprintf(b, "estimated cost (%sexcluding exported Google files, which have no size):\n", currency)
+	fmt.Fprintf(b, "\ttransfer: %.2f (%.2f GiB)\n", egress, downloaded)
+	fmt.Fprintf(b, "\trequests: %.2f (%d files)\n", requests, stats.Downloaded)
+	fmt.Fprintf(b, "\tstorage: %.2f per month (%.2f GiB)\n", monthly, archived)
+	fmt.Fprintf(b, "\ttotal for run and %d months of storage: %.2f", p.Months, total)
+	return b.String()
+}
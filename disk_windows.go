@@ -0,0 +1,8 @@
+package main
+
+import "errors"
+
+// freeSpace is not supported on Windows
+func freeSpace(path string) (int64, error) {
+	return 0, errors.New("not supported on windows")
+}
@@ -0,0 +1,91 @@
+package drive
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// rateLimited counts the rate limit errors returned to every Service, for AdaptiveConcurrency
+var rateLimited int64
+
+// rateLimitReasons are the error reasons returned with 403 responses when requests are rate limited
+var rateLimitReasons = map[string]struct{}{
+	ErrReasonRateLimitExceeded:     {},
+	ErrReasonUserRateLimitExceeded: {},
+}
+
+// isRateLimit returns true if err is a rate limit error
+func isRateLimit(err error) bool {
+	var gErr *googleapi.Error
+	if !errors.As(err, &gErr) {
+		return false
+	}
+	if gErr.Code == 429 {
+		return true
+	}
+	if gErr.Code != 403 {
+		return false
+	}
+	for _, e := range gErr.Errors {
+		if _, ok := rateLimitReasons[e.Reason]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AdaptiveConcurrency scales the concurrency of a Control with AIMD (additive increase, multiplicative decrease): the concurrency
+// is halved after each interval with rate limit errors, and raised by one after each interval without them. Rate limit errors
+// from every Service are counted, so one AdaptiveConcurrency should be used for all concurrent downloads
+type AdaptiveConcurrency struct {
+	Control *Control
+	// Min and Max bound the concurrency. Max should be the total number of downloaders using Control
+	Min, Max int
+	// Interval is how often the concurrency is adjusted
+	Interval time.Duration
+	// OnChange, if set, is called with the new concurrency and the number of rate limit errors in the interval when the concurrency changes
+	OnChange func(concurrency int, errors int64)
+}
+
+// Run adjusts the concurrency every Interval, starting at Max, until ctx is canceled
+func (a *AdaptiveConcurrency) Run(ctx context.Context) {
+	limit := a.Max
+	a.Control.SetConcurrency(limit)
+
+	t := time.NewTicker(a.Interval)
+	defer t.Stop()
+	last := atomic.LoadInt64(&rateLimited)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		n := atomic.LoadInt64(&rateLimited)
+		errs := n - last
+		last = n
+
+		next := limit
+		if errs > 0 {
+			next = limit / 2
+			if next < a.Min {
+				next = a.Min
+			}
+		} else if limit < a.Max {
+			next = limit + 1
+		}
+		if next == limit {
+			continue
+		}
+		limit = next
+		a.Control.SetConcurrency(limit)
+		if a.OnChange != nil {
+			a.OnChange(limit, errs)
+		}
+	}
+}
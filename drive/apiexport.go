@@ -0,0 +1,266 @@
+package drive
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/korylprince/drive-archive/drive/sanitize"
+	"google.golang.org/api/docs/v1"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/sheets/v4"
+)
+
+const FileTypeDocument = "application/vnd.google-apps.document"
+const FileTypeSpreadsheet = "application/vnd.google-apps.spreadsheet"
+
+// ExportTypeSheetsCSV is a pseudo export type that exports Google Sheets with the Sheets API as a directory with one CSV file per tab.
+// It's used instead of an extension's export type, so the directory is named after the spreadsheet without an extension
+const ExportTypeSheetsCSV = "drive-archive/sheets-csv"
+
+// sheetChunkRows is the number of rows requested at a time when exporting a spreadsheet with the Sheets API
+const sheetChunkRows = 5000
+
+var errNoAPIExport = errors.New("no api export available")
+
+// apiExportPath returns the path and export type exportAPI writes f to when it's exported to path,
+// or empty strings if f can't be exported with an API
+func apiExportPath(f *drive.File, path string) (string, string) {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	switch f.MimeType {
+	case FileTypeDocument:
+		return base + ".txt", "text/plain"
+	case FileTypeSpreadsheet:
+		return base, ExportTypeSheetsCSV
+	}
+	return "", ""
+}
+
+// exportedPath returns the path and export type of the local copy of f, exported as exportType to path.
+// If path doesn't exist because f was exported with exportAPI instead, the path and export type of the API export are returned
+func exportedPath(f *drive.File, exportType, path string) (string, string) {
+	if exportType == "" || exportType == ExportTypeSheetsCSV {
+		return path, exportType
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path, exportType
+	}
+	if p, typ := apiExportPath(f, path); p != "" {
+		if _, err := os.Stat(p); err == nil {
+			return p, typ
+		}
+	}
+	return path, exportType
+}
+
+// exportAPI exports f using the Docs or Sheets API. It's used as a last resort when a file is too large to export normally,
+// e.g. spreadsheets that fail with exportSizeLimitExceeded and have no working export link.
+// Docs are exported as plain text to path with a .txt extension and Sheets are exported as one CSV per tab to
+// a directory at path without its extension. If f can't be exported with an API, errNoAPIExport is returned
+func (s *Service) exportAPI(ctx context.Context, f *drive.File, path string) error {
+	p, typ := apiExportPath(f, path)
+	switch typ {
+	case "text/plain":
+		return s.commit(f, p, func(p string) error {
+			return s.exportDocText(ctx, f, p)
+		})
+	case ExportTypeSheetsCSV:
+		return s.exportSheetCSV(ctx, f, p)
+	}
+	return errNoAPIExport
+}
+
+// writeDocContent writes the text in content to b
+func writeDocContent(b *strings.Builder, content []*docs.StructuralElement) {
+	for _, e := range content {
+		switch {
+		case e.Paragraph != nil:
+			for _, pe := range e.Paragraph.Elements {
+				if pe.TextRun != nil {
+					b.WriteString(pe.TextRun.Content)
+				}
+			}
+		case e.Table != nil:
+			for _, row := range e.Table.TableRows {
+				for i, cell := range row.TableCells {
+					if i > 0 {
+						b.WriteString("\t")
+					}
+					var cb strings.Builder
+					writeDocContent(&cb, cell.Content)
+					b.WriteString(strings.Join(strings.Fields(cb.String()), " "))
+				}
+				b.WriteString("\n")
+			}
+		case e.TableOfContents != nil:
+			writeDocContent(b, e.TableOfContents.Content)
+		}
+	}
+}
+
+// exportDocText exports the text of the Google Doc f to path
+func (s *Service) exportDocText(ctx context.Context, f *drive.File, path string) error {
+	var doc *docs.Document
+	if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		var err error
+		doc, err = s.docs.Documents.Get(f.Id).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("could not get document: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	b := new(strings.Builder)
+	if doc.Body != nil {
+		writeDocContent(b, doc.Body.Content)
+	}
+	for _, h := range doc.Headers {
+		b.WriteString("\n")
+		writeDocContent(b, h.Content)
+	}
+	for _, ft := range doc.Footers {
+		b.WriteString("\n")
+		writeDocContent(b, ft.Content)
+	}
+	for _, fn := range doc.Footnotes {
+		b.WriteString("\n")
+		writeDocContent(b, fn.Content)
+	}
+
+	return writeBody(strings.NewReader(b.String()), path, f.ModifiedTime)
+}
+
+// sheetDirCurrent returns true if dir is a complete CSV export of a spreadsheet last modified at modified.
+// exportSheetCSV sets the mtime of the directory to the spreadsheet's modified time once every tab is exported
+func sheetDirCurrent(dir, modified string) bool {
+	t, err := time.Parse(time.RFC3339, modified)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+	return info.ModTime().Equal(t)
+}
+
+// exportSheetCSV exports each tab of the Google Sheet f as a CSV file in dir. CSV files of tabs that no longer exist are removed
+func (s *Service) exportSheetCSV(ctx context.Context, f *drive.File, dir string) error {
+	var ss *sheets.Spreadsheet
+	if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		var err error
+		ss, err = s.sheets.Spreadsheets.Get(f.Id).Fields("sheets/properties(title,gridProperties/rowCount)").Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("could not get spreadsheet: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+
+	written := make(map[string]bool, len(ss.Sheets))
+	for _, sh := range ss.Sheets {
+		// skip sheets without cells, e.g. charts
+		if sh.Properties == nil || sh.Properties.GridProperties == nil {
+			continue
+		}
+		name := sanitize.Name(sh.Properties.Title) + ".csv"
+		written[name] = true
+		if err := s.commit(f, filepath.Join(dir, name), func(p string) error {
+			return s.writeSheetCSV(ctx, f, sh.Properties.Title, sh.Properties.GridProperties.RowCount, p)
+		}); err != nil && err != errIdentical {
+			return fmt.Errorf("could not export sheet %s: %w", sh.Properties.Title, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("could not read directory: %w", err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".csv") && !written[e.Name()] {
+			if err = os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				return fmt.Errorf("could not remove deleted sheet: %w", err)
+			}
+		}
+	}
+
+	// mark the export as complete
+	return setMtime(dir, f.ModifiedTime)
+}
+
+// sheetChunkRows returns the number of rows requested at a time when exporting a spreadsheet with the Sheets API
+func (s *Service) sheetChunkRows() int64 {
+	if s.SheetChunkRows > 0 {
+		return int64(s.SheetChunkRows)
+	}
+	return sheetChunkRows
+}
+
+// writeSheetCSV writes the formatted values of the tab with title in the Google Sheet f to path, requesting rows in chunks
+func (s *Service) writeSheetCSV(ctx context.Context, f *drive.File, title string, rows int64, path string) error {
+	file, err := createAtomic(path)
+	if err != nil {
+		return fmt.Errorf("could not create file: %w", err)
+	}
+	defer file.abort()
+
+	w := csv.NewWriter(file)
+	title = strings.ReplaceAll(title, "'", "''")
+	// blank rows are only written if they're followed by a non-blank row
+	blank := 0
+	chunk := s.sheetChunkRows()
+	for start := int64(1); start <= rows; start += chunk {
+		var vr *sheets.ValueRange
+		if err = retry(ctx, s.initialBackoff, s.tries, func() error {
+			vr, err = s.sheets.Spreadsheets.Values.Get(f.Id, fmt.Sprintf("'%s'!%d:%d", title, start, start+chunk-1)).
+				ValueRenderOption("FORMATTED_VALUE").
+				Context(ctx).
+				Do()
+			if err != nil {
+				return fmt.Errorf("could not get values: %w", err)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, row := range vr.Values {
+			if len(row) == 0 {
+				blank++
+				continue
+			}
+			for ; blank > 0; blank-- {
+				if err = w.Write(nil); err != nil {
+					return fmt.Errorf("could not write row: %w", err)
+				}
+			}
+			record := make([]string, len(row))
+			for i, v := range row {
+				record[i] = fmt.Sprint(v)
+			}
+			if err = w.Write(record); err != nil {
+				return fmt.Errorf("could not write row: %w", err)
+			}
+		}
+		blank += int(chunk) - len(vr.Values)
+	}
+
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return fmt.Errorf("could not write csv: %w", err)
+	}
+
+	return file.commit(f.ModifiedTime)
+}
@@ -0,0 +1,70 @@
+package drive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// AtomicSuffix is added to the names of downloaded files while they're written. Finished files are renamed into place,
+// so an interrupted write never leaves a truncated file at the destination
+const AtomicSuffix = ".drive-archive.tmp"
+
+// atomicFile is a file written to a temporary path and renamed to its destination when committed
+type atomicFile struct {
+	*os.File
+	path string
+	done bool
+}
+
+// createAtomic creates a temporary file that will be renamed to path when committed.
+// If path's directory doesn't exist, e.g. because it was removed after it was created, it's created
+func createAtomic(path string) (*atomicFile, error) {
+	f, err := os.Create(path + AtomicSuffix)
+	if os.IsNotExist(err) {
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		f, err = os.Create(path + AtomicSuffix)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{File: f, path: path}, nil
+}
+
+// abort closes and removes the temporary file if it hasn't been committed
+func (f *atomicFile) abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.File.Close()
+	os.Remove(f.Name())
+}
+
+// commit flushes the temporary file to disk, sets its mtime to the RFC3339 timestamp, and renames it to its destination
+func (f *atomicFile) commit(timestamp string) error {
+	if f.done {
+		return nil
+	}
+	if err := f.Sync(); err != nil {
+		f.abort()
+		return fmt.Errorf("could not sync file: %w", err)
+	}
+	f.done = true
+	// close before setting mtime, since network filesystems may set the mtime when cached writes are flushed
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("could not close file: %w", err)
+	}
+	if err := setMtime(f.Name(), timestamp); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("could not move file into place: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// b2AuthorizeURL is the URL used to authorize B2 accounts
+const b2AuthorizeURL = "https://api.backblazeb2.com/b2api/v2/b2_authorize_account"
+
+// b2Error is an error returned by the B2 API
+type b2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *b2Error) Error() string {
+	return fmt.Sprintf("b2 error %d (%s): %s", e.Status, e.Code, e.Message)
+}
+
+// retryable returns true if the request should be retried
+func (e *b2Error) retryable() bool {
+	return e.Status == http.StatusRequestTimeout || e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// expired returns true if the request's authorization token has expired
+func (e *b2Error) expired() bool {
+	return e.Code == "expired_auth_token" || e.Code == "bad_auth_token"
+}
+
+// B2 uploads files to a Backblaze B2 bucket with the B2 native API. Files larger than the account's recommended part size
+// are uploaded with the large file API
+type B2 struct {
+	KeyID  string
+	Key    string
+	Bucket string
+	// Prefix is prepended to uploaded file names
+	Prefix string
+
+	initialBackoff time.Duration
+	tries          int
+	client         *http.Client
+
+	mu       sync.Mutex
+	apiURL   string
+	token    string
+	bucketID string
+	partSize int64
+	authTime time.Time
+}
+
+// NewB2 returns a new B2 uploading to bucket with the application key keyID and key, and authorizes the account
+func NewB2(keyID, key, bucket, prefix string) (*B2, error) {
+	b := &B2{KeyID: keyID, Key: key, Bucket: bucket, Prefix: prefix, initialBackoff: time.Second, tries: 8, client: http.DefaultClient}
+	if err := b.authorize(time.Time{}); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// do sends a request and decodes the JSON response into v
+func (b *B2) do(r *http.Request, v interface{}) error {
+	resp, err := b.client.Do(r)
+	if err != nil {
+		return &b2Error{Status: http.StatusServiceUnavailable, Code: "request_failed", Message: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bErr := &b2Error{Status: resp.StatusCode}
+		if err = json.NewDecoder(resp.Body).Decode(bErr); err != nil {
+			bErr.Message = resp.Status
+		}
+		return bErr
+	}
+
+	if v == nil {
+		return nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+	return nil
+}
+
+// authorize authorizes the account if it hasn't been authorized since after
+func (b *B2) authorize(after time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.authTime.After(after) {
+		return nil
+	}
+
+	r, err := http.NewRequest(http.MethodGet, b2AuthorizeURL, nil)
+	if err != nil {
+		return err
+	}
+	r.SetBasicAuth(b.KeyID, b.Key)
+
+	var auth struct {
+		AccountID           string `json:"accountId"`
+		AuthorizationToken  string `json:"authorizationToken"`
+		APIURL              string `json:"apiUrl"`
+		RecommendedPartSize int64  `json:"recommendedPartSize"`
+		Allowed             struct {
+			BucketID   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+		} `json:"allowed"`
+	}
+	if err = retry(context.Background(), b.initialBackoff, b.tries, func() error { return b.do(r, &auth) }); err != nil {
+		return fmt.Errorf("could not authorize account: %w", err)
+	}
+	b.apiURL, b.token, b.partSize, b.authTime = auth.APIURL, auth.AuthorizationToken, auth.RecommendedPartSize, time.Now()
+
+	if b.bucketID != "" {
+		return nil
+	}
+	if auth.Allowed.BucketID != "" && auth.Allowed.BucketName == b.Bucket {
+		b.bucketID = auth.Allowed.BucketID
+		return nil
+	}
+
+	var buckets struct {
+		Buckets []struct {
+			BucketID string `json:"bucketId"`
+		} `json:"buckets"`
+	}
+	if err = b.call("b2_list_buckets", map[string]string{"accountId": auth.AccountID, "bucketName": b.Bucket}, &buckets); err != nil {
+		return fmt.Errorf("could not find bucket: %w", err)
+	}
+	if len(buckets.Buckets) == 0 {
+		return fmt.Errorf("bucket %s not found", b.Bucket)
+	}
+	b.bucketID = buckets.Buckets[0].BucketID
+	return nil
+}
+
+// call calls the B2 API operation with body, decoding the response into v. b.mu must be held
+func (b *B2) call(op string, body, v interface{}) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return retry(context.Background(), b.initialBackoff, b.tries, func() error {
+		r, err := http.NewRequest(http.MethodPost, b.apiURL+"/b2api/v2/"+op, bytes.NewReader(buf))
+		if err != nil {
+			return err
+		}
+		r.Header.Set("Authorization", b.token)
+		return b.do(r, v)
+	})
+}
+
+// api calls the B2 API operation with body, reauthorizing if the authorization token has expired
+func (b *B2) api(op string, body, v interface{}) error {
+	b.mu.Lock()
+	start := b.authTime
+	err := b.call(op, body, v)
+	b.mu.Unlock()
+
+	var bErr *b2Error
+	if errors.As(err, &bErr) && bErr.expired() {
+		if err = b.authorize(start); err != nil {
+			return err
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.call(op, body, v)
+	}
+	return err
+}
+
+// b2UploadURL is an upload URL and its authorization token
+type b2UploadURL struct {
+	UploadURL          string `json:"uploadUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+// b2Name percent-encodes a B2 file name
+func b2Name(name string) string {
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+// upload sends a part or whole file to an upload URL, getting a new upload URL with getURL if the request fails
+func (b *B2) upload(getURL func() (*b2UploadURL, error), r io.ReadSeeker, size int64, sum string, headers map[string]string) error {
+	var u *b2UploadURL
+	return retry(context.Background(), b.initialBackoff, b.tries, func() error {
+		var err error
+		if u == nil {
+			if u, err = getURL(); err != nil {
+				return err
+			}
+		}
+		if _, err = r.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, u.UploadURL, io.NopCloser(r))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = size
+		req.Header.Set("Authorization", u.AuthorizationToken)
+		req.Header.Set("X-Bz-Content-Sha1", sum)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		if err = b.do(req, nil); err != nil {
+			// upload URLs can't be reused after a failure
+			u = nil
+			var bErr *b2Error
+			if errors.As(err, &bErr) && bErr.expired() {
+				// retry with a new upload URL
+				return &b2Error{Status: http.StatusServiceUnavailable, Code: bErr.Code, Message: bErr.Message}
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// sha1Section returns the hex encoded SHA-1 hash of r
+func sha1Section(r io.Reader) (string, error) {
+	h := sha1.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// UploadFile uploads the file at local to the bucket as name, prefixed with b.Prefix
+func (b *B2) UploadFile(local, name string) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return fmt.Errorf("could not open file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("could not stat file: %w", err)
+	}
+	name = path.Join(b.Prefix, name)
+	mtime := strconv.FormatInt(info.ModTime().UnixNano()/int64(time.Millisecond), 10)
+
+	b.mu.Lock()
+	partSize, bucketID := b.partSize, b.bucketID
+	b.mu.Unlock()
+
+	if info.Size() <= partSize {
+		sum, err := sha1Section(f)
+		if err != nil {
+			return fmt.Errorf("could not hash file: %w", err)
+		}
+		return b.upload(func() (*b2UploadURL, error) {
+			u := new(b2UploadURL)
+			return u, b.api("b2_get_upload_url", map[string]string{"bucketId": bucketID}, u)
+		}, f, info.Size(), sum, map[string]string{
+			"X-Bz-File-Name":                     b2Name(name),
+			"Content-Type":                       "b2/x-auto",
+			"X-Bz-Info-src_last_modified_millis": mtime,
+		})
+	}
+
+	var large struct {
+		FileID string `json:"fileId"`
+	}
+	if err = b.api("b2_start_large_file", map[string]interface{}{
+		"bucketId":    bucketID,
+		"fileName":    name,
+		"contentType": "b2/x-auto",
+		"fileInfo":    map[string]string{"src_last_modified_millis": mtime},
+	}, &large); err != nil {
+		return fmt.Errorf("could not start large file: %w", err)
+	}
+
+	var sums []string
+	for part, offset := 1, int64(0); offset < info.Size(); part, offset = part+1, offset+partSize {
+		size := partSize
+		if offset+size > info.Size() {
+			size = info.Size() - offset
+		}
+		section := io.NewSectionReader(f, offset, size)
+		sum, err := sha1Section(section)
+		if err != nil {
+			return fmt.Errorf("could not hash part %d: %w", part, err)
+		}
+		if err = b.upload(func() (*b2UploadURL, error) {
+			u := new(b2UploadURL)
+			return u, b.api("b2_get_upload_part_url", map[string]string{"fileId": large.FileID}, u)
+		}, section, size, sum, map[string]string{"X-Bz-Part-Number": strconv.Itoa(part)}); err != nil {
+			b.api("b2_cancel_large_file", map[string]string{"fileId": large.FileID}, nil)
+			return fmt.Errorf("could not upload part %d: %w", part, err)
+		}
+		sums = append(sums, sum)
+	}
+
+	if err = b.api("b2_finish_large_file", map[string]interface{}{"fileId": large.FileID, "partSha1Array": sums}, nil); err != nil {
+		return fmt.Errorf("could not finish large file: %w", err)
+	}
+	return nil
+}
+
+// UploadB2 uploads the manifest's captured files to b, keeping their paths relative to the manifest's root.
+// The KeepFile placeholders of the manifest's EmptyFolders are also uploaded
+func (m *Manifest) UploadB2(b *B2) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.walkFiles(func(local, rel string) error {
+		if err := b.UploadFile(local, rel); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// B2 has no directories, so empty folders are only kept if they have placeholders
+	for _, dir := range m.EmptyFolders {
+		local := filepath.Join(m.root, filepath.FromSlash(dir), KeepFile)
+		if _, err := os.Stat(local); err != nil {
+			continue
+		}
+		rel := path.Join(dir, KeepFile)
+		if err := b.UploadFile(local, rel); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package drive
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// MaxRetryElapsed, if positive, stops retrying a request once this much time has passed since it was first tried,
+// even if it has tries left. Time spent waiting for the daily quota to reset isn't counted. Set it before making any requests
+var MaxRetryElapsed time.Duration
+
+// WaitForQuotaReset, if true, retries requests that fail because the project's daily quota is exceeded once the quota resets at
+// midnight Pacific Time, instead of failing them. Set it before making any requests
+var WaitForQuotaReset bool
+
+// isDailyLimit returns true if err is returned because the project's daily quota is exceeded
+func isDailyLimit(err error) bool {
+	var gErr *googleapi.Error
+	if !errors.As(err, &gErr) || gErr.Code != 403 {
+		return false
+	}
+	for _, e := range gErr.Errors {
+		if e.Reason == ErrReasonDailyLimitExceeded {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter returns the delay requested by the Retry-After header of err's response, or 0 if there isn't one
+func retryAfter(err error) time.Duration {
+	var gErr *googleapi.Error
+	if !errors.As(err, &gErr) || gErr.Header == nil {
+		return 0
+	}
+	v := gErr.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// quotaReset returns the time until the daily quota resets after now, at midnight Pacific Time
+func quotaReset(now time.Time) time.Duration {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		// without tzdata, use Pacific Standard Time. The quota resets an hour early or on time
+		loc = time.FixedZone("PST", -8*60*60)
+	}
+	now = now.In(loc)
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, loc).Sub(now)
+}
+
+// backoff returns how long to wait before retrying after err, where d is the current exponential backoff.
+// A delay requested with Retry-After is used as is. Otherwise the delay is jittered so concurrent downloaders don't retry together,
+// and rate limit errors, which are shared by every downloader, wait between d and 2d instead of between d/2 and 3d/2
+func backoff(err error, d time.Duration) time.Duration {
+	if after := retryAfter(err); after > 0 {
+		return after
+	}
+	if isRateLimit(err) {
+		return d + time.Duration(rand.Int63n(int64(d)+1))
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)+1))
+}
@@ -0,0 +1,62 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// newFakeRun returns a Service using a fake Drive server with a synthetic tree described by opts, and the tree.
+// If wrap is set, the server's handler is wrapped with it
+func newFakeRun(t testing.TB, opts *BenchmarkOptions, wrap func(http.Handler) http.Handler) (*Service, *File) {
+	t.Helper()
+	files := SyntheticFiles(opts)
+	fake := &fakeDrive{files: files, ids: make(map[string]bool, len(files)), content: syntheticContent(opts.Size)}
+	for _, f := range files {
+		fake.ids[f.Id] = true
+	}
+	var h http.Handler = fake
+	if wrap != nil {
+		h = wrap(h)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	svc, err := newFakeService(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Logger = NewWriterLogger(os.Stderr, LogError, false)
+	tree, _ := NewTree("root", files)
+	return svc, tree
+}
+
+func BenchmarkDownloadTree(b *testing.B) {
+	for _, downloaders := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("downloaders=%d", downloaders), func(b *testing.B) {
+			opts := &BenchmarkOptions{Files: 500, Folders: 20, Size: 16 * 1024, Downloaders: downloaders}
+			svc, tree := newFakeRun(b, opts, nil)
+			b.SetBytes(int64(opts.Files) * opts.Size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				out, err := os.MkdirTemp("", "drive-archive-bench")
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				if err = svc.DownloadTree(context.Background(), tree, out, &DownloadOptions{Downloaders: downloaders, Stats: new(Stats)}); err != nil {
+					b.Fatal(err)
+				}
+
+				b.StopTimer()
+				os.RemoveAll(out)
+				b.StartTimer()
+			}
+		})
+	}
+}
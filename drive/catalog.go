@@ -0,0 +1,347 @@
+package drive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// CatalogDir is the name of the directory in an archive that its catalog is stored in
+const CatalogDir = ".catalog"
+
+// catalogListing is the listing of the last run recorded in a catalog
+type catalogListing struct {
+	User   string        `json:"user"`
+	Root   string        `json:"root"`
+	RunID  string        `json:"run_id"`
+	Listed time.Time     `json:"listed"`
+	Files  []*drive.File `json:"files"`
+	// Finished is true if the run's id is in the catalog's finished file, so finishing a run doesn't rewrite its listing
+	Finished bool `json:"-"`
+}
+
+// Catalog is a persistent record of an archive's listing and the state of each file, kept across runs in CatalogDir. The listing
+// of a run that didn't finish is reused by the next run, so it resumes without listing the user's Drive again, and the files the
+// run captured are skipped without checking their contents (see DownloadOptions.Resume). Files are recorded as they finish, one
+// JSON object per line, so the state of an interrupted run is kept, and can be looked up by Drive ID or searched by path.
+// A Catalog is safe for concurrent use.
+//
+// The catalog isn't a database: it's a JSON listing and a log of entries, which are indexed by ID in memory when it's opened.
+// The listing is written once per run, finishing a run writes only its id, and entries are appended. Files are replaced atomically,
+// and a partial last entry left by a crash is dropped when the catalog is opened
+type Catalog struct {
+	dir  string
+	root string
+
+	mu      sync.Mutex
+	listing *catalogListing
+	// resuming is true if the listing was returned by Resume
+	resuming bool
+	// entries are the latest entries of each file, keyed by ID, then path
+	entries map[string]map[string]*ManifestEntry
+	log     *os.File
+	// err is the first error recording an entry
+	err error
+}
+
+// OpenCatalog opens or creates the catalog of the archive at root
+func OpenCatalog(root string) (*Catalog, error) {
+	c := &Catalog{dir: filepath.Join(root, CatalogDir), root: root, entries: make(map[string]map[string]*ManifestEntry)}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create catalog: %w", err)
+	}
+
+	if f, err := os.Open(filepath.Join(c.dir, "listing.json")); err == nil {
+		l := new(catalogListing)
+		err = json.NewDecoder(f).Decode(l)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not decode catalog listing: %w", err)
+		}
+		c.listing = l
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not open catalog listing: %w", err)
+	}
+
+	if buf, err := os.ReadFile(filepath.Join(c.dir, "finished")); err == nil {
+		if c.listing != nil && c.listing.RunID == strings.TrimSpace(string(buf)) {
+			c.listing.Finished = true
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not read finished run: %w", err)
+	}
+
+	path := filepath.Join(c.dir, "files.ndjson")
+	entries, n, err := readJournal(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		c.add(e)
+	}
+	// a partial last line left by a crash is removed, so later entries aren't appended to it
+	if info, err := os.Stat(path); err == nil && info.Size() > n {
+		if err = os.Truncate(path, n); err != nil {
+			return nil, fmt.Errorf("could not repair catalog: %w", err)
+		}
+	}
+
+	if c.log, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+		return nil, fmt.Errorf("could not open catalog: %w", err)
+	}
+	return c, nil
+}
+
+// UnfinishedRunError is returned by Catalog.Resume when the last run of the Drive didn't finish and isn't the run being resumed
+type UnfinishedRunError struct {
+	RunID string
+}
+
+func (e *UnfinishedRunError) Error() string {
+	return fmt.Sprintf("run %s didn't finish", e.RunID)
+}
+
+// Resume returns the listing of the run with runID of user's Drive with root if it didn't finish. If it finished, an error is returned.
+// If another run was the last run of the Drive and didn't finish, an *UnfinishedRunError is returned. Otherwise nil is returned
+func (c *Catalog) Resume(runID, user, root string) ([]*drive.File, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.listing
+	if l == nil || l.User != user || l.Root != root {
+		return nil, nil
+	}
+	if l.RunID != runID {
+		if l.Finished {
+			return nil, nil
+		}
+		return nil, &UnfinishedRunError{RunID: l.RunID}
+	}
+	if l.Finished {
+		return nil, fmt.Errorf("run %s already finished", runID)
+	}
+	c.resuming = true
+	return l.Files, nil
+}
+
+// Resuming returns true if the run's listing was returned by Resume
+func (c *Catalog) Resuming() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.resuming
+}
+
+// captured returns the entry of f at path if it was captured, hasn't changed in Drive since, and its local file still exists with
+// the same size. If f is exported, path is the path of its export. exportType is the type f was exported as
+func (c *Catalog) captured(f *drive.File, path, exportType string) *ManifestEntry {
+	rel, err := filepath.Rel(c.root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = path
+	}
+
+	c.mu.Lock()
+	e := c.entries[f.Id][filepath.ToSlash(rel)]
+	c.mu.Unlock()
+	if e == nil || !e.Captured() || e.ExportType != exportType || e.ModifiedTime != f.ModifiedTime ||
+		e.MD5Checksum != f.Md5Checksum || e.HeadRevisionID != f.HeadRevisionId {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	// exported and converted files don't match Drive's size
+	if !info.IsDir() && e.MD5Checksum != "" && e.PDFA != "converted" && info.Size() != e.Size {
+		return nil
+	}
+	return e
+}
+
+// add sets e as the latest entry of its file and path. c.mu must be held
+func (c *Catalog) add(e *ManifestEntry) {
+	paths, ok := c.entries[e.ID]
+	if !ok {
+		paths = make(map[string]*ManifestEntry, 1)
+		c.entries[e.ID] = paths
+	}
+	paths[e.Path] = e
+}
+
+// writeListing writes the listing, replacing it atomically. c.mu must be held
+func (c *Catalog) writeListing() error {
+	f, err := createAtomic(filepath.Join(c.dir, "listing.json"))
+	if err != nil {
+		return fmt.Errorf("could not create catalog listing: %w", err)
+	}
+	defer f.abort()
+	if err = json.NewEncoder(f).Encode(c.listing); err != nil {
+		return fmt.Errorf("could not encode catalog listing: %w", err)
+	}
+	return f.commit("")
+}
+
+// SetListing records files as the listing of the run with runID of user's Drive with root
+func (c *Catalog) SetListing(runID, user, root string, files []*drive.File) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listing = &catalogListing{User: user, Root: root, RunID: runID, Listed: time.Now(), Files: files}
+	return c.writeListing()
+}
+
+// Finish marks the run's listing as finished, so it isn't reused
+func (c *Catalog) Finish() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.listing == nil {
+		return nil
+	}
+
+	f, err := createAtomic(filepath.Join(c.dir, "finished"))
+	if err != nil {
+		return fmt.Errorf("could not create finished run: %w", err)
+	}
+	defer f.abort()
+	if _, err = fmt.Fprintln(f, c.listing.RunID); err != nil {
+		return fmt.Errorf("could not write finished run: %w", err)
+	}
+	if err = f.commit(""); err != nil {
+		return fmt.Errorf("could not write finished run: %w", err)
+	}
+	c.listing.Finished = true
+	return nil
+}
+
+// Record records e as the latest state of its file. e must not be changed afterwards. Only the first error is kept, and it's
+// returned by Close
+func (c *Catalog) Record(e *ManifestEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(e)
+	if c.err != nil {
+		return
+	}
+
+	buf, err := json.Marshal(e)
+	if err != nil {
+		c.err = fmt.Errorf("could not encode catalog entry: %w", err)
+		return
+	}
+	if _, err = c.log.Write(append(buf, '\n')); err != nil {
+		c.err = fmt.Errorf("could not write catalog entry: %w", err)
+	}
+}
+
+// Compact removes the entries that keep returns false for, e.g. the entries of files deleted from Drive, and rewrites the
+// catalog's entries. It returns the number of entries removed
+func (c *Catalog) Compact(keep func(e *ManifestEntry) bool) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path := filepath.Join(c.dir, "files.ndjson")
+	f, err := createAtomic(path)
+	if err != nil {
+		return 0, fmt.Errorf("could not create catalog: %w", err)
+	}
+	defer f.abort()
+
+	removed := 0
+	enc := json.NewEncoder(f)
+	for id, paths := range c.entries {
+		for p, e := range paths {
+			if !keep(e) {
+				delete(paths, p)
+				removed++
+				continue
+			}
+			if err = enc.Encode(e); err != nil {
+				return 0, fmt.Errorf("could not encode catalog entry: %w", err)
+			}
+		}
+		if len(paths) == 0 {
+			delete(c.entries, id)
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	// entries recorded after this are appended to the new file
+	c.log.Close()
+	if err = f.commit(""); err != nil {
+		return removed, err
+	}
+	if c.log, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+		return removed, fmt.Errorf("could not open catalog: %w", err)
+	}
+	return removed, nil
+}
+
+// Lookup returns the entries of the file with id, ordered by path
+func (c *Catalog) Lookup(id string) []*ManifestEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	found := make([]*ManifestEntry, 0, len(c.entries[id]))
+	for _, e := range c.entries[id] {
+		found = append(found, e)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Path < found[j].Path })
+	return found
+}
+
+// Search returns the entries whose slash separated paths match the glob pattern, or whose names contain pattern (ignoring case)
+// if it has no glob characters, ordered by path. Paths and names aren't indexed, so every entry is checked
+func (c *Catalog) Search(pattern string) []*ManifestEntry {
+	if strings.ContainsAny(pattern, "*?[") {
+		return c.find(func(e *ManifestEntry) bool {
+			ok, _ := path.Match(pattern, e.Path)
+			return ok
+		})
+	}
+	pattern = strings.ToLower(pattern)
+	return c.find(func(e *ManifestEntry) bool { return strings.Contains(strings.ToLower(e.Name), pattern) })
+}
+
+// find returns the entries matched by f, ordered by path
+func (c *Catalog) find(f func(e *ManifestEntry) bool) []*ManifestEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var found []*ManifestEntry
+	for _, paths := range c.entries {
+		for _, e := range paths {
+			if f(e) {
+				found = append(found, e)
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Path < found[j].Path })
+	return found
+}
+
+// Close closes the catalog and returns the first error recording an entry
+func (c *Catalog) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.log.Close()
+	if c.err != nil {
+		return c.err
+	}
+	if err != nil {
+		return fmt.Errorf("could not close catalog: %w", err)
+	}
+	return nil
+}
+
+// SetCatalog records each finished entry in c, in addition to the journal
+func (m *Manifest) SetCatalog(c *Catalog) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.catalog = c
+}
@@ -0,0 +1,174 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCatalogCrash(t *testing.T) {
+	out := t.TempDir()
+	files := SyntheticFiles(&BenchmarkOptions{Files: 3, Folders: 1, Size: 1})
+
+	c, err := OpenCatalog(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SetListing("crashed", "user@example.com", "root", files); err != nil {
+		t.Fatal(err)
+	}
+	c.Record(&ManifestEntry{ID: "file-0", Path: "My Drive/File 0.bin", Status: StatusDownloaded})
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash while recording leaves a partial last line
+	f, err := os.OpenFile(filepath.Join(out, CatalogDir, "files.ndjson"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":"file-1","pa`)
+	f.Close()
+
+	if c, err = OpenCatalog(out); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := c.Resume("crashed", "user@example.com", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != len(files) {
+		t.Fatalf("expected %d listed files, got %d", len(files), len(listed))
+	}
+	c.Record(&ManifestEntry{ID: "file-1", Path: "My Drive/File 1.bin", Status: StatusDownloaded})
+	if err = c.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, err = OpenCatalog(out); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, id := range []string{"file-0", "file-1"} {
+		if len(c.Lookup(id)) != 1 {
+			t.Errorf("expected one entry for %s, got %d", id, len(c.Lookup(id)))
+		}
+	}
+	if _, err = c.Resume("crashed", "user@example.com", "root"); err == nil {
+		t.Error("expected resuming a finished run to fail")
+	}
+	if listed, err = c.Resume("next", "user@example.com", "root"); err != nil || listed != nil {
+		t.Errorf("expected a new run to list files, got %d files, %v", len(listed), err)
+	}
+}
+
+func TestCatalogResumeSkipsCaptured(t *testing.T) {
+	const killAfter = 10
+	var (
+		mu         sync.Mutex
+		downloaded = make(map[string]int)
+	)
+	svc, tree := newFakeRun(t, &BenchmarkOptions{Files: 50, Folders: 3, Size: 1024}, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("alt") == "media" {
+				mu.Lock()
+				downloaded[strings.TrimPrefix(r.URL.Path, "/files/")]++
+				mu.Unlock()
+			}
+			h.ServeHTTP(w, r)
+		})
+	})
+	out := t.TempDir()
+
+	// the first run is killed after some files are finished
+	c, err := OpenCatalog(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SetListing("killed", "user@example.com", "root", nil); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManifest("killed", out, time.Now())
+	m.SetCatalog(c)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	finished := 0
+	svc.DownloadTree(ctx, tree, out, &DownloadOptions{
+		Downloaders: 1,
+		Manifest:    m,
+		Progress: func(e *ProgressEvent) {
+			if e.Type == EventFinished {
+				if finished++; finished == killAfter {
+					cancel()
+				}
+			}
+		},
+	})
+	c.Close()
+
+	if c, err = OpenCatalog(out); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.Resume("killed", "user@example.com", "root"); err != nil || !c.Resuming() {
+		t.Fatalf("expected to resume the killed run: %v", err)
+	}
+
+	// a captured file changed locally with the same size isn't checked, and one with a different size is downloaded again
+	var captured []*ManifestEntry
+	for _, e := range m.Files {
+		if e.Captured() {
+			captured = append(captured, e)
+		}
+	}
+	if len(captured) < killAfter {
+		t.Fatalf("expected at least %d captured files, got %d", killAfter, len(captured))
+	}
+	same, truncated := captured[0], captured[1]
+	if err = os.WriteFile(m.localPath(same), bytes.Repeat([]byte{'y'}, int(same.Size)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(m.localPath(truncated), []byte("y"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	before := make(map[string]int, len(downloaded))
+	for id, n := range downloaded {
+		before[id] = n
+	}
+	mu.Unlock()
+	m = NewManifest("killed", out, time.Now())
+	if err = svc.DownloadTree(context.Background(), tree, out, &DownloadOptions{Downloaders: 2, Manifest: m, Stats: new(Stats), Resume: c}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range captured {
+		n := downloaded[e.ID] - before[e.ID]
+		if e == truncated && n != 1 {
+			t.Errorf("expected the changed file %s to be downloaded again", e.Path)
+		} else if e != truncated && n != 0 {
+			t.Errorf("expected the captured file %s not to be downloaded again, downloaded %d times", e.Path, n)
+		}
+	}
+	for _, e := range m.Files {
+		if !e.Captured() {
+			t.Errorf("%s wasn't captured: %s", e.Path, e.Status)
+		}
+		if e.ID == same.ID && e.Status != StatusExisting {
+			t.Errorf("expected %s to be recorded as existing, got %s", e.Path, e.Status)
+		}
+	}
+	if len(m.Files) != 50 {
+		t.Errorf("expected 50 entries, got %d", len(m.Files))
+	}
+}
@@ -0,0 +1,169 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// changeFields are the fields requested when listing changes
+var changeFields = func() []googleapi.Field {
+	fields := []googleapi.Field{"nextPageToken", "newStartPageToken", "changes/fileId", "changes/removed"}
+	for _, f := range getFields {
+		fields = append(fields, "changes/file/"+f)
+	}
+	return fields
+}()
+
+// StartPageToken returns the page token used to list changes made to the user's Google Drive after now
+func (s *Service) StartPageToken(ctx context.Context) (string, error) {
+	var token *drive.StartPageToken
+	if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		var err error
+		token, err = drive.NewChangesService(s.driveSvc).GetStartPageToken().Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("could not get start page token: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	return token.StartPageToken, nil
+}
+
+// Changes returns the changes made to files in the user's Google Drive since the page token was returned,
+// and the page token used to list later changes
+func (s *Service) Changes(ctx context.Context, token string) ([]*drive.Change, string, error) {
+	var changes []*drive.Change
+	svc := drive.NewChangesService(s.driveSvc)
+	for {
+		var resp *drive.ChangeList
+		if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+			var err error
+			resp, err = svc.List(token).Spaces("drive").Fields(s.withExtra(changeFields, "changes/file/")...).PageSize(1000).Context(ctx).Do()
+			if err != nil {
+				return fmt.Errorf("could not list changes: %w", err)
+			}
+			return nil
+		}); err != nil {
+			return nil, "", err
+		}
+		changes = append(changes, resp.Changes...)
+		if resp.NewStartPageToken != "" {
+			return changes, resp.NewStartPageToken, nil
+		}
+		token = resp.NextPageToken
+	}
+}
+
+// SyncState is the listing of the user's Google Drive saved between incremental runs
+type SyncState struct {
+	// PageToken is used to list changes made since the listing
+	PageToken string        `json:"page_token"`
+	Files     []*drive.File `json:"files"`
+}
+
+// ReadSyncState reads the SyncState at path
+func ReadSyncState(path string) (*SyncState, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open state: %w", err)
+	}
+	defer f.Close()
+
+	st := new(SyncState)
+	if err = json.NewDecoder(f).Decode(st); err != nil {
+		return nil, fmt.Errorf("could not decode state: %w", err)
+	}
+
+	return st, nil
+}
+
+// Write writes the SyncState as JSON to path, replacing it atomically
+func (st *SyncState) Write(path string) error {
+	tmp := path + PartialSuffix
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("could not create state: %w", err)
+	}
+
+	if err = json.NewEncoder(f).Encode(st); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("could not encode state: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not write state: %w", err)
+	}
+
+	if err = os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("could not replace state: %w", err)
+	}
+	return nil
+}
+
+// Apply updates the listing with changes and returns the ids of the added and changed files. Removed files are removed from the listing
+func (st *SyncState) Apply(changes []*drive.Change) map[string]bool {
+	index := make(map[string]int, len(st.Files))
+	for i, f := range st.Files {
+		index[f.Id] = i
+	}
+
+	changed := make(map[string]bool)
+	removed := make(map[string]bool)
+	for _, c := range changes {
+		if c.Removed || c.File == nil {
+			removed[c.FileId] = true
+			delete(changed, c.FileId)
+			continue
+		}
+		delete(removed, c.FileId)
+		changed[c.FileId] = true
+		if i, ok := index[c.FileId]; ok {
+			st.Files[i] = c.File
+			continue
+		}
+		index[c.FileId] = len(st.Files)
+		st.Files = append(st.Files, c.File)
+	}
+
+	if len(removed) > 0 {
+		files := st.Files[:0]
+		for _, f := range st.Files {
+			if !removed[f.Id] {
+				files = append(files, f)
+			}
+		}
+		st.Files = files
+	}
+
+	return changed
+}
+
+// Descendants returns a new set containing ids and the ids of all files under folders in ids in trees.
+// Files under changed folders may have moved, so they're downloaded again
+func Descendants(ids map[string]bool, trees ...*File) map[string]bool {
+	all := make(map[string]bool, len(ids))
+	for id := range ids {
+		all[id] = true
+	}
+
+	for _, tree := range trees {
+		tree.Walk(func(path string, f *File) error {
+			if f.IsFolder() && ids[f.ID] {
+				f.Walk(func(path string, c *File) error {
+					all[c.ID] = true
+					return nil
+				})
+			}
+			return nil
+		})
+	}
+
+	return all
+}
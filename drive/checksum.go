@@ -0,0 +1,89 @@
+package drive
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// checksumError is returned when a downloaded file's MD5 or SHA-256 checksum doesn't match Drive's, because the download was
+// truncated or corrupted. It's always retried
+type checksumError struct {
+	alg, want, got string
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("could not verify download: %s checksum %s doesn't match Drive's checksum %s", e.alg, e.got, e.want)
+}
+
+// md5File returns the hex encoded MD5 checksum of the file at path
+func md5File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("could not open file: %w", err)
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("could not read file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyChecksum returns nil if got, the MD5 checksum of the downloaded file, matches file's checksum. If it doesn't, and the file
+// has been changed in Drive since it was listed to the version that was downloaded, file's checksum, size, revision, and modified time
+// are updated so the new version is recorded, and nil is returned. Otherwise a *checksumError is returned
+func (s *Service) verifyChecksum(ctx context.Context, file *drive.File, path, got string) error {
+	if file.Md5Checksum == "" || got == file.Md5Checksum {
+		return nil
+	}
+	// a pinned revision never changes
+	if !s.PinRevisions {
+		if cur, err := s.get(ctx, file.Id); err == nil && cur.Md5Checksum == got {
+			s.logf("%s: file changed since it was listed; archived the current version\n", s.logPath(path))
+			file.Md5Checksum, file.Size, file.HeadRevisionId, file.ModifiedTime = cur.Md5Checksum, cur.Size, cur.HeadRevisionId, cur.ModifiedTime
+			return nil
+		}
+	}
+	return &checksumError{alg: "md5", want: file.Md5Checksum, got: got}
+}
+
+// writeVerified writes r, the contents of file, to path like writeBody, but only moves it into place if its checksum is verified.
+// If want256, the file's hex encoded SHA-256 checksum, is set, it's compared to the downloaded file's SHA-256 checksum. Otherwise
+// the file's MD5 checksum is verified with verifyChecksum
+func (s *Service) writeVerified(ctx context.Context, r io.Reader, file *drive.File, path, want256 string) error {
+	if file.Md5Checksum == "" && want256 == "" {
+		return writeBody(r, path, file.ModifiedTime)
+	}
+
+	f, err := createAtomic(path)
+	if err != nil {
+		return fmt.Errorf("could not create file: %w", err)
+	}
+	defer f.abort()
+
+	h := md5.New()
+	if want256 != "" {
+		h = sha256.New()
+	}
+	if _, err = io.Copy(io.MultiWriter(f, h), r); err != nil {
+		return fmt.Errorf("could not write body: %w", err)
+	}
+	got := hex.EncodeToString(h.Sum(nil))
+	if want256 != "" {
+		if got != want256 {
+			return &checksumError{alg: "sha256", want: want256, got: got}
+		}
+	} else if err = s.verifyChecksum(ctx, file, path, got); err != nil {
+		return err
+	}
+
+	return f.commit(file.ModifiedTime)
+}
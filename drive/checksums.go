@@ -0,0 +1,149 @@
+package drive
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// localPath returns the local path of e
+func (m *Manifest) localPath(e *ManifestEntry) string {
+	if filepath.IsAbs(e.Path) {
+		return e.Path
+	}
+	return filepath.Join(m.root, filepath.FromSlash(e.Path))
+}
+
+// walkFiles calls f with the local path and slash separated path relative to the manifest's root of each captured file.
+// Routed files outside of the manifest root are given paths under routed/, and Sheets exported with the API as directories
+// of CSV files are walked. m.mu must be held
+func (m *Manifest) walkFiles(f func(local, rel string) error) error {
+	for _, e := range m.Files {
+		if !e.Captured() {
+			continue
+		}
+		local := m.localPath(e)
+		rel := strings.TrimPrefix(path.Clean(e.Path), "/")
+		if path.IsAbs(e.Path) {
+			rel = path.Join("routed", rel)
+		}
+
+		if err := filepath.Walk(local, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			r, err := filepath.Rel(local, p)
+			if err != nil {
+				return err
+			}
+			return f(p, path.Join(rel, filepath.ToSlash(r)))
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileSums returns the SHA-256 hash and slash separated path of each captured file, setting the SHA256 of each entry that doesn't
+// have one by reading its file from disk. Sheets exported as directories of CSV files have no SHA256 of their own, so each file in
+// the directory is hashed and returned instead. m.mu must be held
+func (m *Manifest) fileSums() ([][2]string, error) {
+	sums := make([][2]string, 0, len(m.Files))
+	for _, e := range m.Files {
+		if !e.Captured() {
+			continue
+		}
+		if e.SHA256 != "" {
+			sums = append(sums, [2]string{e.SHA256, e.Path})
+			continue
+		}
+
+		local := m.localPath(e)
+		info, err := os.Stat(local)
+		if err != nil {
+			return nil, fmt.Errorf("%s: could not hash file: %w", e.Path, err)
+		}
+		if !info.IsDir() {
+			if e.SHA256, err = sha256File(local); err != nil {
+				return nil, fmt.Errorf("%s: could not hash file: %w", e.Path, err)
+			}
+			sums = append(sums, [2]string{e.SHA256, e.Path})
+			continue
+		}
+
+		if err = filepath.Walk(local, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			r, err := filepath.Rel(local, p)
+			if err != nil {
+				return err
+			}
+			sum, err := sha256File(p)
+			if err != nil {
+				return err
+			}
+			sums = append(sums, [2]string{sum, path.Join(e.Path, filepath.ToSlash(r))})
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("%s: could not hash file: %w", e.Path, err)
+		}
+	}
+	return sums, nil
+}
+
+// writeSums writes lines of hash and path in sha256sum format to path
+func writeSums(path string, sums [][2]string) error {
+	sort.Slice(sums, func(i, j int) bool { return sums[i][1] < sums[j][1] })
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create file: %w", err)
+	}
+	defer f.Close()
+
+	for _, s := range sums {
+		if _, err = fmt.Fprintf(f, "%s  %s\n", s[0], s[1]); err != nil {
+			return fmt.Errorf("could not write file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// WriteChecksums hashes the manifest's files and writes SHA256SUMS files that can be checked with `sha256sum -c`.
+// If perDir is true, a SHA256SUMS file is written to each directory containing files.
+// Otherwise a single SHA256SUMS file is written to the manifest's root
+func (m *Manifest) WriteChecksums(perDir bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sums, err := m.fileSums()
+	if err != nil {
+		return err
+	}
+
+	if !perDir {
+		return writeSums(filepath.Join(m.root, "SHA256SUMS"), sums)
+	}
+
+	dirs := make(map[string][][2]string)
+	for _, s := range sums {
+		dir, name := path.Split(s[1])
+		dirs[dir] = append(dirs[dir], [2]string{s[0], name})
+	}
+	for dir, sums := range dirs {
+		p := filepath.Join(filepath.FromSlash(dir), "SHA256SUMS")
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(m.root, p)
+		}
+		if err := writeSums(p, sums); err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+	}
+
+	return nil
+}
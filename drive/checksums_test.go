@@ -0,0 +1,91 @@
+package drive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSheetsCSVManifest returns a manifest in a temporary directory with a file and a spreadsheet saved as a directory of CSV files,
+// and the expected SHA256SUMS lines
+func newSheetsCSVManifest(t *testing.T) (*Manifest, []string) {
+	t.Helper()
+	root := t.TempDir()
+	files := map[string]string{
+		"My Drive/notes.txt":            "notes",
+		"My Drive/Budget/Sheet1.csv":    "a,b\n1,2\n",
+		"My Drive/Budget/Summary 2.csv": "total,3\n",
+	}
+	var lines []string
+	for p, content := range files {
+		local := filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(local, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte(content))
+		lines = append(lines, hex.EncodeToString(sum[:])+"  "+p)
+	}
+
+	m := NewManifest("test", root, time.Now())
+	m.Files = []*ManifestEntry{
+		{ID: "file", Path: "My Drive/notes.txt", Status: StatusDownloaded},
+		{ID: "sheet", Path: "My Drive/Budget", MimeType: FileTypeSpreadsheet, ExportType: "text/csv", Status: StatusDownloaded},
+	}
+	return m, lines
+}
+
+func TestWriteChecksumsSheetsCSV(t *testing.T) {
+	m, lines := newSheetsCSVManifest(t)
+	if err := m.WriteChecksums(false); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(filepath.Join(m.root, "SHA256SUMS"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range lines {
+		if !strings.Contains(string(buf), line+"\n") {
+			t.Errorf("SHA256SUMS is missing %q:\n%s", line, buf)
+		}
+	}
+	if m.Files[1].SHA256 != "" {
+		t.Errorf("expected no SHA256 for the directory entry, got %s", m.Files[1].SHA256)
+	}
+
+	if err = m.WriteChecksums(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(m.root, "My Drive", "Budget", "SHA256SUMS")); err != nil {
+		t.Errorf("expected SHA256SUMS in the spreadsheet's directory: %v", err)
+	}
+}
+
+func TestMerkleSheetsCSV(t *testing.T) {
+	m, _ := newSheetsCSVManifest(t)
+	tree, err := m.Merkle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tree.Directories["My Drive/Budget"]; !ok {
+		t.Errorf("expected the spreadsheet's directory in the tree, got %v", tree.Directories)
+	}
+
+	// changing a CSV file changes the root
+	if err = os.WriteFile(filepath.Join(m.root, "My Drive", "Budget", "Sheet1.csv"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := m.Merkle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Root == tree.Root {
+		t.Error("expected the root to change when a CSV file changed")
+	}
+}
@@ -0,0 +1,80 @@
+package drive
+
+import (
+	"encoding/csv"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Collision reasons
+const (
+	// CollisionSuffixed paths had _2, _3, etc. added because another file or folder has the same path
+	CollisionSuffixed = "suffixed"
+	// CollisionTruncated names were shortened to fit the maximum path length
+	CollisionTruncated = "truncated"
+)
+
+// Collision is a file or folder whose local path doesn't match its Drive name
+type Collision struct {
+	ID string
+	// Name is the file's name in Drive
+	Name string
+	// TreePath is the path of the file in the tree
+	TreePath string
+	// LocalPath is the path the file was archived to
+	LocalPath string
+	Reason    string
+}
+
+// CollisionReport records the files and folders whose paths were changed to avoid collisions or fit path length limits.
+// The zero value is ready to use, and a nil CollisionReport records nothing
+type CollisionReport struct {
+	mu         sync.Mutex
+	collisions []*Collision
+}
+
+// add records a collision
+func (r *CollisionReport) add(f *File, treePath, localPath, reason string) {
+	if r == nil {
+		return
+	}
+	name := f.Name
+	if f.File != nil && f.File.Name != "" {
+		name = f.File.Name
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collisions = append(r.collisions, &Collision{ID: f.ID, Name: name, TreePath: filepath.ToSlash(treePath), LocalPath: localPath, Reason: reason})
+}
+
+// Collisions returns the recorded collisions, sorted by local path
+func (r *CollisionReport) Collisions() []*Collision {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := append(make([]*Collision, 0, len(r.collisions)), r.collisions...)
+	sort.Slice(c, func(i, j int) bool { return c[i].LocalPath < c[j].LocalPath })
+	return c
+}
+
+// WriteCSV writes the recorded collisions to a CSV file at path
+func (r *CollisionReport) WriteCSV(path string) error {
+	f, err := createAtomic(path)
+	if err != nil {
+		return fmt.Errorf("could not create file: %w", err)
+	}
+	defer f.abort()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"id", "drive_name", "tree_path", "local_path", "reason"})
+	for _, c := range r.Collisions() {
+		w.Write([]string{c.ID, c.Name, c.TreePath, c.LocalPath, c.Reason})
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return fmt.Errorf("could not write report: %w", err)
+	}
+
+	return f.commit("")
+}
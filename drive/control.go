@@ -0,0 +1,122 @@
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrDrained is returned by DownloadTree when a download is stopped by Control.Drain
+var ErrDrained = errors.New("drained")
+
+// Control allows pausing, resuming, draining, and changing the concurrency of running downloads
+type Control struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	paused   bool
+	draining bool
+	limit    int
+	active   int
+}
+
+// NewControl returns a new Control
+func NewControl() *Control {
+	c := new(Control)
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Pause stops new downloads from starting. Downloads in progress are finished
+func (c *Control) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = true
+}
+
+// Resume resumes starting new downloads after Pause
+func (c *Control) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = false
+	c.cond.Broadcast()
+}
+
+// Drain stops queuing new downloads and returns ErrDrained from DownloadTree once downloads in progress are finished
+func (c *Control) Drain() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.draining = true
+	c.cond.Broadcast()
+}
+
+// SetConcurrency limits the number of downloads in progress to n. The limit can't be raised above the number of downloaders.
+// If n is less than 1, the limit is removed
+func (c *Control) SetConcurrency(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limit = n
+	c.cond.Broadcast()
+}
+
+// Draining returns true if Drain has been called
+func (c *Control) Draining() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.draining
+}
+
+// state returns running, paused, or draining. c.mu must be held
+func (c *Control) state() string {
+	switch {
+	case c.draining:
+		return "draining"
+	case c.paused:
+		return "paused"
+	}
+	return "running"
+}
+
+// Status returns a human readable description of the Control's state
+func (c *Control) Status() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fmt.Sprintf("state=%s active=%d concurrency=%d", c.state(), c.active, c.limit)
+}
+
+// acquire blocks until a download can start. It returns false if the Control is draining or ctx is done.
+// If acquire returns true, release must be called when the download is finished.
+// A nil Control never blocks
+func (c *Control) acquire(ctx context.Context) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for !c.draining && ctx.Err() == nil && (c.paused || (c.limit > 0 && c.active >= c.limit)) {
+		c.cond.Wait()
+	}
+	if c.draining || ctx.Err() != nil {
+		return false
+	}
+	c.active++
+	return true
+}
+
+// wake wakes downloads waiting in acquire so they can check if their context is done
+func (c *Control) wake() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cond.Broadcast()
+}
+
+// release marks a download started with acquire as finished
+func (c *Control) release() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	c.cond.Broadcast()
+}
@@ -1,92 +1,590 @@
 package drive
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 
-	"golang.org/x/sync/errgroup"
+	"github.com/korylprince/drive-archive/drive/sanitize"
+	"google.golang.org/api/drive/v3"
 )
 
 type download struct {
 	*File
 	Path string
+	Dest string
+	// TreePath is the file's path in the tree
+	TreePath string
+	// Parent is the folder the file was reached through in the tree
+	Parent     *File
+	ExportType string
+	// folder is true if the download is a directory that should be created
+	folder bool
+	// attempts is the number of times the download has been started
+	attempts int
+	// worker is the number of the downloader downloading the file
+	worker int
 }
 
-func (s *Service) downloader(outpath string, c <-chan *download) error {
-	for d := range c {
-		downloaded, err := s.DownloadFile(d.File.File, filepath.Join(outpath, d.Path))
-		if err != nil {
-			fmt.Printf("%s: could not download file: %v\n", d.Path, err)
-			continue
+// dirCache creates directories, remembering which have already been created
+type dirCache struct {
+	mu   sync.Mutex
+	dirs map[string]struct{}
+}
+
+func newDirCache() *dirCache {
+	return &dirCache{dirs: make(map[string]struct{})}
+}
+
+// mkdir creates dir and its parents if they haven't already been created
+func (c *dirCache) mkdir(dir string) error {
+	c.mu.Lock()
+	_, ok := c.dirs[dir]
+	c.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.dirs[dir] = struct{}{}
+	c.mu.Unlock()
+	return nil
+}
+
+// DownloadOptions configures DownloadTree
+type DownloadOptions struct {
+	// Downloaders is the number of concurrent downloaders. If less than 1, runtime.NumCPU() will be used
+	Downloaders int
+	// Router routes matching files to an output path other than the one given to DownloadTree
+	Router Router
+	// Hold, if set, is applied to each file after it's archived
+	Hold *Hold
+	// Manifest, if set, records each archived file
+	Manifest *Manifest
+	// Only, if set, skips files whose ids aren't in Only. Directories are only created for files that are downloaded
+	Only map[string]bool
+	// Resume, if set, is the catalog of the run being resumed. Files it recorded as captured, that haven't changed in Drive and
+	// whose local files still exist with the same size, are recorded as existing without checking their contents
+	Resume *Catalog
+	// ModifiedSince, if set, skips files that were created and last modified before ModifiedSince.
+	// Directories are only created for files that are downloaded
+	ModifiedSince time.Time
+	// SkipEmptyFolders, if true, only creates directories that files are downloaded to
+	SkipEmptyFolders bool
+	// Layout is how files are laid out in the output path
+	Layout Layout
+	// PDFA, if set, converts exported PDFs to PDF/A
+	PDFA *PDFAConverter
+	// Volumes, if set, splits the output into volumes. Routed files are not split
+	Volumes *VolumePlan
+	// Stats, if set, counts the results of downloads
+	Stats *Stats
+	// Control, if set, allows pausing, resuming, draining, and limiting the concurrency of downloads.
+	// Downloads are drained when the daily quota is exceeded, unless WaitForQuotaReset is true
+	Control *Control
+	// ShardThreshold, if positive, moves the children of folders with more than ShardThreshold children into subdirectories
+	// named by the first two characters of their names. With LayoutRecords, files are sharded if the tree has more than ShardThreshold files
+	ShardThreshold int
+	// SMB, if true, makes paths valid for SMB shares and retries downloads that fail because of temporary share disconnects
+	SMB bool
+	// MaxPathLength, if positive, shortens file names so full paths are at most MaxPathLength bytes. It's only used with SMB
+	MaxPathLength int
+	// OCR, if true, extracts the text of images and PDFs with Drive's OCR to sidecar files named with OCRSuffix.
+	// Files are temporarily copied into the user's Drive to be converted
+	OCR bool
+	// OCRLanguage is an optional ISO 639-1 language hint for OCR
+	OCRLanguage string
+	// MediaSidecars, if true, writes the photo and video metadata of files to sidecars named with MediaSuffix.
+	// MediaFields must be added to the Service's ExtraFields
+	MediaSidecars bool
+	// Revisions, if not RevisionsNone, writes the revision history of files to sidecars named with RevisionsSuffix and downloads
+	// the previous revisions of binary files it chooses next to them
+	Revisions Revisions
+	// CaptureMtime, if true, sets the mtimes of photos to the time they were taken instead of their Drive modified time
+	CaptureMtime bool
+	// Progress, if set, is called with progress events for each file. It's called concurrently by downloaders, and should return quickly
+	Progress func(*ProgressEvent)
+	// Filter, if set, chooses which files are downloaded. Directories are only created for files that are downloaded if it has Include patterns
+	Filter *Filter
+	// PDFRenditions, if true, also exports Google files in PDFRenditionTypes as PDFs next to their editable exports.
+	// Each rendition is counted as a listed file and recorded in the Manifest separately. It's ignored with LayoutRecords, which exports PDFs
+	PDFRenditions bool
+	// MaxSize, if positive, skips files larger than MaxSize bytes. Skipped files are recorded in the Manifest with StatusSkipped.
+	// Exported Google files have no size and are never skipped
+	MaxSize int64
+	// SheetsCSV, if true, exports Google Sheets with the Sheets API as a directory named after the spreadsheet with one CSV file
+	// per tab, instead of as XLSX files, which can't be exported if they're larger than 10 MB. It's ignored with LayoutRecords
+	SheetsCSV bool
+	// SheetValues, if true, also exports Google Sheets with the Sheets API as a values-only snapshot next to their XLSX exports:
+	// a directory named after the spreadsheet with one CSV file per tab, with formulas evaluated as the user saw them.
+	// Each snapshot is counted as a listed file and recorded in the Manifest separately. It's ignored with SheetsCSV and LayoutRecords
+	SheetValues bool
+	// Collisions, if set, records files whose paths had _2, _3, etc. added or were shortened, and folders renamed by ResolveDuplicateFolders
+	Collisions *CollisionReport
+	// DryRun, if true, logs what would be downloaded or skipped and counts it in Stats without creating directories or downloading files.
+	// Files that would be downloaded are counted as downloaded
+	DryRun bool
+}
+
+// retry calls f, retrying it if opts.SMB is true and it fails because of a temporary share disconnect
+func (opts *DownloadOptions) retry(f func() error) error {
+	if !opts.SMB {
+		return f()
+	}
+	return smbRetry(time.Second, 6, f)
+}
+
+// modifiedSince returns true if f was created or modified after t
+func modifiedSince(f *drive.File, t time.Time) bool {
+	for _, ts := range []string{f.ModifiedTime, f.CreatedTime} {
+		ft, err := time.Parse(time.RFC3339, ts)
+		if err != nil || ft.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// logf logs a message at LogInfo with the Service's Logger. Secrets are redacted from the message
+func (s *Service) logf(format string, a ...interface{}) {
+	s.log(&LogEntry{Level: LogInfo, Message: strings.TrimSuffix(fmt.Sprintf(format, a...), "\n")})
+}
+
+// warnf is like logf, but logs at LogWarn
+func (s *Service) warnf(format string, a ...interface{}) {
+	s.log(&LogEntry{Level: LogWarn, Message: strings.TrimSuffix(fmt.Sprintf(format, a...), "\n")})
+}
+
+func (s *Service) downloadOne(ctx context.Context, outpath string, opts *DownloadOptions, dirs *dirCache, d *download) {
+	path := filepath.Join(d.Dest, d.Path)
+	if !d.folder && opts.MaxSize > 0 && d.File.File.Size > opts.MaxSize {
+		s.skipped(opts, d, path)
+		return
+	}
+	if opts.DryRun {
+		s.dryRun(opts, d, path)
+		return
+	}
+	if d.folder {
+		if err := opts.retry(func() error { return dirs.mkdir(path) }); err != nil {
+			s.logFile(LogError, d, "could not create directory", err)
+			return
 		}
+		s.logFile(LogDebug, d, "created directory", nil)
+		return
+	}
+
+	opts.emit(EventStarted, d, 0, false, nil)
+
+	// parent directories are created by downloaders so that a slow mkdir doesn't block the walker
+	if err := opts.retry(func() error { return dirs.mkdir(filepath.Dir(path)) }); err != nil {
+		opts.Stats.failed(false)
+		opts.emit(EventFailed, d, 0, false, err)
+		s.logFile(LogError, d, "could not create directory", err)
+		return
+	}
+
+	var bytes int64
+	if opts.Progress != nil {
+		unwatch := s.watchers.watch(path, func(n int64) {
+			bytes = n
+			opts.emit(EventProgress, d, n, false, nil)
+		})
+		defer unwatch()
+	}
+
+	var (
+		downloaded bool
+		resumed    *ManifestEntry
+		err        error
+	)
+	start := time.Now()
+	if opts.Resume != nil {
+		local, exportType := exportedPath(d.File.File, d.ExportType, path)
+		resumed = opts.Resume.captured(d.File.File, local, exportType)
+	}
+	if resumed == nil {
+		err = opts.retry(func() error {
+			var err error
+			downloaded, err = s.DownloadFileAs(ctx, d.File.File, d.ExportType, path)
+			return err
+		})
+	}
+	// files too large to export are exported with the Docs or Sheets API to a different path
+	local, exportType := exportedPath(d.File.File, d.ExportType, path)
+	elapsed := time.Since(start)
+	var size int64
+	if info, sErr := os.Stat(local); downloaded && sErr == nil && !info.IsDir() {
+		size = info.Size()
+	}
+	if !errors.Is(err, ErrNoExportableFormat) {
+		opts.Stats.timed(d.File.File.MimeType, d.worker, downloaded, err != nil, size, elapsed)
+	}
+	if err != nil {
+		opts.emit(EventFailed, d, bytes, false, err)
+		restricted := errors.Is(err, ErrRestricted)
+		status := StatusFailed
+		switch {
+		case errors.Is(err, ErrNoExportableFormat):
+			status = StatusUnsupported
+			opts.Stats.unsupported()
+		case restricted:
+			status = StatusRestricted
+			opts.Stats.failed(true)
+		default:
+			opts.Stats.failed(false)
+		}
+		if opts.Manifest != nil {
+			e := opts.Manifest.add(d.File.File, path, status)
+			e.ExportType = d.ExportType
+			if status != StatusUnsupported {
+				e.Error = Redact(err.Error())
+			}
+			opts.Manifest.record(e)
+		}
+		s.logFile(LogError, d, "could not download file", err)
+		if isDailyLimit(err) && opts.Control != nil && !opts.Control.Draining() {
+			// every later request would fail until the quota resets
+			s.logFile(LogError, d, "daily quota exceeded; draining", nil)
+			opts.Control.Drain()
+		}
+		return
+	}
+	opts.Stats.captured(downloaded, d.ExportType != "", d.File.File.Size)
+	opts.emit(EventFinished, d, bytes, downloaded, nil)
+	var pdfa string
+	if opts.PDFA != nil && downloaded && d.ExportType == "application/pdf" {
+		pdfa = "converted"
+		if err = opts.PDFA.Convert(path, d.File.File.ModifiedTime); err != nil {
+			pdfa = err.Error()
+			s.logFile(LogWarn, d, "could not convert to PDF/A", err)
+		}
+	}
+
+	if opts.MediaSidecars {
+		if err = writeMediaSidecar(d.File.File, path, opts.CaptureMtime); err != nil {
+			s.logFile(LogWarn, d, err.Error(), nil)
+		}
+	}
+
+	if opts.Revisions != RevisionsNone {
+		if n, err := s.writeRevisions(ctx, d.File.File, local, opts.Revisions); err != nil {
+			s.logFile(LogWarn, d, "could not archive revisions", err)
+		} else if n > 0 {
+			s.logFile(LogInfo, d, fmt.Sprintf("downloaded %d previous revisions", n), nil)
+		}
+	}
+
+	var ocr string
+	if opts.OCR {
+		ocr = s.ocrSidecar(ctx, opts, d, path)
+	}
+
+	if opts.Layout == LayoutRecords {
+		if err = s.writeRecordDescriptor(d.File.File, d.TreePath, d.ExportType, path); err != nil {
+			s.logFile(LogError, d, "could not write record descriptor", err)
+			return
+		}
+	}
+	if opts.Manifest != nil {
+		status := StatusDownloaded
 		if !downloaded {
-			fmt.Printf("%s: skipped existing file\n", d.Path)
-			continue
+			status = StatusExisting
 		}
-		fmt.Printf("%s: downloaded\n", d.Path)
+		e := opts.Manifest.add(d.File.File, local, status)
+		e.ExportType, e.PDFA, e.OCR = exportType, pdfa, ocr
+		// the file was checked with Drive's checksum
+		e.SHA256 = s.listedSHA256(d.File.File, d.ExportType)
+		if resumed != nil && e.SHA256 == "" {
+			e.SHA256 = resumed.SHA256
+		}
+		opts.Manifest.record(e)
 	}
 
-	return nil
+	entry := &LogEntry{Level: LogInfo, FileID: d.File.File.Id, Path: s.logPath(d.Path), Size: d.File.File.Size, Duration: elapsed}
+	switch {
+	case !downloaded:
+		entry.Message = "skipped existing file"
+	case d.Dest != outpath:
+		entry.Message, entry.Size = "downloaded to "+s.logPath(d.Dest), size
+	default:
+		entry.Message, entry.Size = "downloaded", size
+	}
+	s.log(entry)
+
+	if opts.Hold != nil {
+		parentID := ""
+		if d.Parent != nil {
+			parentID = d.Parent.ID
+		}
+		if err = s.Hold(ctx, opts.Hold, d.File.File, parentID, d.Path); err != nil {
+			s.logFile(LogError, d, "could not apply hold", err)
+			return
+		}
+		s.logFile(LogInfo, d, "applied hold", nil)
+	}
+}
+
+// skipped records that d was skipped because it's larger than opts.MaxSize
+func (s *Service) skipped(opts *DownloadOptions, d *download, path string) {
+	opts.Stats.skipped(d.File.File.Size)
+	opts.emit(EventSkipped, d, 0, false, nil)
+	reason := fmt.Sprintf("size %d bytes is larger than the maximum %d bytes", d.File.File.Size, opts.MaxSize)
+	if opts.DryRun {
+		s.logFile(LogInfo, d, "would skip: "+reason, nil)
+		return
+	}
+	if opts.Manifest != nil {
+		e := opts.Manifest.add(d.File.File, path, StatusSkipped)
+		e.ExportType = d.ExportType
+		e.Error = reason
+		opts.Manifest.record(e)
+	}
+	s.logFile(LogInfo, d, "skipped file: "+reason, nil)
+}
+
+// dryRun logs whether d would be downloaded or skipped to path and counts it in opts.Stats
+func (s *Service) dryRun(opts *DownloadOptions, d *download, path string) {
+	if d.folder {
+		return
+	}
+
+	ok, err := needsDownload(d.File.File, d.ExportType, path)
+	opts.emit(EventSkipped, d, 0, false, nil)
+	switch {
+	case err != nil:
+		opts.Stats.unsupported()
+		s.logFile(LogInfo, d, "would skip", err)
+	case ok:
+		opts.Stats.captured(true, d.ExportType != "", d.File.File.Size)
+		s.logFile(LogInfo, d, "would download", nil)
+	default:
+		opts.Stats.captured(false, d.ExportType != "", d.File.File.Size)
+		s.logFile(LogInfo, d, "would skip existing file", nil)
+	}
 }
 
-// DownloadTree downloads the file tree rooted at root to outpath using the specified number of downloaders.
-// If downloaders is less than 1, runtime.NumCPU() will be used
-func (s *Service) DownloadTree(root *File, outpath string, downloaders int) error {
-	eg := new(errgroup.Group)
-	c := make(chan *download)
+// DownloadTree downloads the file tree rooted at root to outpath using the given options.
+// If opts is nil, the default options are used. If ctx is canceled, downloads in progress are stopped, queued files are dropped,
+// and ctx's error is returned.
+// Folders are queued before their children, but downloaders run concurrently, so a file may be downloaded before its folder's
+// own mkdir runs. Downloaders don't depend on that order: each creates its file's parent directories before writing it
+func (s *Service) DownloadTree(ctx context.Context, root *File, outpath string, opts *DownloadOptions) error {
+	if opts == nil {
+		opts = new(DownloadOptions)
+	}
+
+	// wake downloaders waiting on a paused Control when ctx is canceled
+	finished := make(chan struct{})
+	defer close(finished)
+	if opts.Control != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				opts.Control.wake()
+			case <-finished:
+			}
+		}()
+	}
+
+	wg := new(sync.WaitGroup)
+	q := newWorkQueue()
+	downloaders := opts.Downloaders
 	if downloaders < 1 {
 		downloaders = runtime.NumCPU()
 	}
-	for i := 0; i < downloaders; i++ {
-		eg.Go(func() error {
-			return s.downloader(outpath, c)
-		})
+	dirs := newDirCache()
+	for i := 1; i <= downloaders; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			s.downloader(ctx, worker, outpath, opts, dirs, q)
+		}(i)
 	}
 
 	files := make(map[string]int)
+	// unique makes sure there are no duplicate paths.
+	// If path exists, add _# to file name and check again
+	unique := func(path string) string {
+		for {
+			files[path] += 1
+			n := files[path]
+			if n == 1 {
+				return path
+			}
+			ext := filepath.Ext(path)
+			base := path[:len(path)-len(ext)]
+			path = fmt.Sprintf("%s_%d%s", base, n, ext)
+		}
+	}
+	lazy := opts.SkipEmptyFolders || !opts.ModifiedSince.IsZero() || opts.Only != nil || opts.Filter.lazy()
+
+	var sh *shards
+	if opts.ShardThreshold > 0 {
+		sh = newShards(opts.ShardThreshold)
+		if opts.Layout == LayoutRecords {
+			n, _ := TreeSize(root)
+			sh.add(".", n)
+		}
+	}
+
+	if err := root.walk(func(path string, f, parent *File) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if opts.Control != nil && opts.Control.Draining() {
+			return ErrDrained
+		}
 
-	if err := root.Walk(func(path string, f *File) error {
 		if f.IsFolder() {
-			if err := os.MkdirAll(filepath.Join(outpath, path), 0755); err != nil {
-				return fmt.Errorf("%s: could not create directory: %w", path, err)
+			if !opts.Filter.Folder(path) {
+				return SkipFolder
+			}
+			if sh != nil && opts.Layout != LayoutRecords {
+				sh.add(path, len(f.Files))
+			}
+			if f.File.Name != "" && f.Name != f.File.Name && opts.Layout != LayoutRecords {
+				// renamed by ResolveDuplicateFolders
+				opts.Collisions.add(f, path, filepath.Join(outpath, sh.path(path)), CollisionSuffixed)
+			}
+			if lazy || opts.Layout == LayoutRecords {
+				return nil
 			}
-			fmt.Printf("%s: created directory\n", path)
+			dest := outpath
+			if opts.Volumes != nil {
+				// folders split across volumes are only created for the files in them
+				if !opts.Volumes.Exists(outpath, path) {
+					return nil
+				}
+				dest = opts.Volumes.Dest(outpath, path)
+			}
+			path = sh.path(path)
+			if opts.SMB {
+				// directory names aren't shortened so they match the paths of their files
+				path, _ = sanitize.SMBPath(dest, path, 0)
+			}
+			q.c <- &download{File: f, Path: path, Dest: dest, folder: true}
+			return nil
+		}
+
+		if !opts.Filter.File(path, f.File) {
+			return nil
+		}
+
+		if !opts.ModifiedSince.IsZero() && !modifiedSince(f.File, opts.ModifiedSince) {
 			return nil
 		}
 
+		if opts.Only != nil && !opts.Only[f.ID] {
+			return nil
+		}
+
+		opts.Stats.listed(f.File.Size)
+
 		if f.File.MimeType == FileTypeShortcut {
-			fmt.Printf("%s: could not resolve shortcut\n", path)
+			opts.Stats.unsupported()
+			s.log(&LogEntry{Level: LogWarn, Message: "could not resolve shortcut", FileID: f.File.Id, Path: s.logPath(path)})
 			return nil
 		}
 
-		// add extensions to exported files
-		if ext, ok := ExportExtensions[f.File.MimeType]; ok {
-			path += ext
+		treePath := path
+		exportType := ExportTypes[f.File.MimeType]
+
+		if opts.Layout == LayoutRecords {
+			path, exportType = recordPath(f.File)
+			path = sh.flat(path)
+			// files with multiple parents are only archived once
+			if files[path] > 0 {
+				return nil
+			}
+		} else {
+			path = sh.path(path)
+			if opts.SheetsCSV && f.File.MimeType == FileTypeSpreadsheet {
+				// the directory is named after the spreadsheet
+				exportType = ExportTypeSheetsCSV
+			} else if ext, ok := ExportExtensions[f.File.MimeType]; ok {
+				// add extensions to exported files
+				path += ext
+			}
 		}
 
-		// make sure there are no duplicate paths.
-		// If path exists, add _# to file name and check again
-	checkpath:
-		files[path] += 1
-		if n := files[path]; n > 1 {
-			ext := filepath.Ext(path)
-			base := path[:len(path)-len(ext)]
-			path = fmt.Sprintf("%s_%d%s", base, n, ext)
-			goto checkpath
+		dest := opts.Router.Dest(f.File, outpath)
+		if opts.Volumes != nil && dest == outpath {
+			dest = opts.Volumes.Dest(outpath, treePath)
+		}
+
+		if opts.SMB {
+			var ok bool
+			full, _ := sanitize.SMBPath(dest, path, 0)
+			if path, ok = sanitize.SMBPath(dest, path, opts.MaxPathLength); !ok {
+				opts.Stats.failed(false)
+				if opts.Manifest != nil {
+					e := opts.Manifest.add(f.File, filepath.Join(dest, path), StatusFailed)
+					e.Error = "path is too long"
+					opts.Manifest.record(e)
+				}
+				s.log(&LogEntry{Level: LogError, Message: "could not download file", FileID: f.File.Id, Path: s.logPath(path), Error: "path is too long"})
+				return nil
+			}
+			if path != full {
+				opts.Collisions.add(f, treePath, filepath.Join(dest, path), CollisionTruncated)
+			}
 		}
 
-		c <- &download{File: f, Path: path}
+		d := &download{File: f, Path: unique(path), Dest: dest, TreePath: treePath, Parent: parent, ExportType: exportType}
+		if d.Path != path {
+			opts.Collisions.add(f, treePath, filepath.Join(dest, d.Path), CollisionSuffixed)
+		}
+		opts.emit(EventQueued, d, 0, false, nil)
+		q.c <- d
+
+		// queue a PDF rendition next to the editable export
+		if opts.PDFRenditions && opts.Layout != LayoutRecords && PDFRenditionTypes[f.File.MimeType] {
+			opts.Stats.listed(0)
+			base := d.Path
+			if exportType != ExportTypeSheetsCSV {
+				base = strings.TrimSuffix(base, filepath.Ext(base))
+			}
+			r := &download{File: f, Path: unique(base + ".pdf"), Dest: dest, TreePath: treePath, ExportType: "application/pdf"}
+			if r.Path != base+".pdf" {
+				opts.Collisions.add(f, treePath, filepath.Join(dest, r.Path), CollisionSuffixed)
+			}
+			opts.emit(EventQueued, r, 0, false, nil)
+			q.c <- r
+		}
+
+		// queue a values-only snapshot next to the XLSX export
+		if opts.SheetValues && opts.Layout != LayoutRecords && exportType != ExportTypeSheetsCSV && f.File.MimeType == FileTypeSpreadsheet {
+			opts.Stats.listed(0)
+			base := strings.TrimSuffix(d.Path, filepath.Ext(d.Path))
+			v := &download{File: f, Path: unique(base), Dest: dest, TreePath: treePath, ExportType: ExportTypeSheetsCSV}
+			if v.Path != base {
+				opts.Collisions.add(f, treePath, filepath.Join(dest, v.Path), CollisionSuffixed)
+			}
+			opts.emit(EventQueued, v, 0, false, nil)
+			q.c <- v
+		}
 
 		return nil
 	}); err != nil {
-		close(c)
-		eg.Wait()
+		// downloads in progress are finished (or canceled with ctx) before returning, and requeued downloads are dropped
+		q.stop()
+		close(q.c)
+		wg.Wait()
 		return fmt.Errorf("could not finish walking tree: %w", err)
 	}
 
-	close(c)
-	eg.Wait()
-	return nil
+	close(q.c)
+	wg.Wait()
+	return ctx.Err()
 }
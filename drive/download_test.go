@@ -0,0 +1,55 @@
+package drive
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadTreeFoldersFirst(t *testing.T) {
+	out := t.TempDir()
+	paths := make(map[string]string)
+	var folders []string
+
+	// the fake server checks a file's folders exist when the file's contents are requested, before the file is written
+	svc, tree := newFakeRun(t, &BenchmarkOptions{Files: 300, Folders: 40, Size: 1024}, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("alt") == "media" {
+				path := paths[strings.TrimPrefix(r.URL.Path, "/files/")]
+				for dir := filepath.Dir(path); dir != "."; dir = filepath.Dir(dir) {
+					if info, err := os.Stat(filepath.Join(out, dir)); err != nil || !info.IsDir() {
+						t.Errorf("%s was requested before its folder %s was created", path, dir)
+					}
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	})
+	tree.Walk(func(path string, f *File) error {
+		if f.IsFolder() {
+			folders = append(folders, path)
+		} else {
+			paths[f.ID] = path
+		}
+		return nil
+	})
+
+	if err := svc.DownloadTree(context.Background(), tree, out, &DownloadOptions{Downloaders: 8, Stats: new(Stats)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// folders without files are created too
+	for _, dir := range folders {
+		if info, err := os.Stat(filepath.Join(out, dir)); err != nil || !info.IsDir() {
+			t.Errorf("folder %s wasn't created", dir)
+		}
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(filepath.Join(out, path)); err != nil {
+			t.Errorf("file %s wasn't written: %v", path, err)
+		}
+	}
+}
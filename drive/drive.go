@@ -7,24 +7,30 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"golang.org/x/oauth2/google"
+	"google.golang.org/api/docs/v1"
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/googleapi"
-	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
 )
 
 const FileTypeFolder = "application/vnd.google-apps.folder"
 const FileTypeShortcut = "application/vnd.google-apps.shortcut"
 const FileTypeSDKPrefix = "application/vnd.google-apps.drive-sdk."
+const FileTypeGooglePrefix = "application/vnd.google-apps."
 
 const ErrReasonSizeLimitExceeded = "exportSizeLimitExceeded"
 const ErrReasonRateLimitExceeded = "rateLimitExceeded"
+const ErrReasonUserRateLimitExceeded = "userRateLimitExceeded"
+const ErrReasonDailyLimitExceeded = "dailyLimitExceeded"
 
 var ErrNoExportableFormat = errors.New("no exportable format")
 
@@ -50,6 +56,20 @@ var ExportExtensions = map[string]string{
 	"application/vnd.google-apps.site":         ".txt",
 }
 
+// PDFRenditionTypes are the Google file types exported as PDFs with DownloadOptions.PDFRenditions
+var PDFRenditionTypes = map[string]bool{
+	"application/vnd.google-apps.document":     true,
+	"application/vnd.google-apps.presentation": true,
+	"application/vnd.google-apps.spreadsheet":  true,
+	"application/vnd.google-apps.drawing":      true,
+}
+
+// CopyOversizedTypes are the Google file types exported from a copy with Service.CopyOversized
+var CopyOversizedTypes = map[string]bool{
+	"application/vnd.google-apps.document":     true,
+	"application/vnd.google-apps.presentation": true,
+}
+
 var SkipTypes = map[string]struct{}{
 	"application/vnd.google-apps.fusiontable": {},
 	"application/vnd.google-apps.map":         {},
@@ -57,55 +77,182 @@ var SkipTypes = map[string]struct{}{
 
 // checkRetry returns true if a retry should be tried
 func checkRetry(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var bErr *b2Error
+	if errors.As(err, &bErr) {
+		return bErr.retryable()
+	}
+	var iErr *ipfsError
+	if errors.As(err, &iErr) {
+		return iErr.retryable()
+	}
+	var sErr *s3Error
+	if errors.As(err, &sErr) {
+		return sErr.retryable()
+	}
+	var rErr *resumeError
+	if errors.As(err, &rErr) {
+		return true
+	}
+	var cErr *checksumError
+	if errors.As(err, &cErr) {
+		return true
+	}
 	var gErr *googleapi.Error
 	if errors.As(err, &gErr) {
 		switch gErr.Code {
 		case 400, 401, 404, 501:
 			return false
 		case 403:
-			for _, e := range gErr.Errors {
-				if e.Reason == ErrReasonRateLimitExceeded {
-					return true
-				}
-			}
-			return false
+			return isRateLimit(err)
 		}
 		return true
 	}
-	return false
+	return isNetTransient(err)
+}
+
+// netTransient are errors caused by dropped connections
+var netTransient = []error{io.ErrUnexpectedEOF, syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED, syscall.EPIPE, syscall.ETIMEDOUT}
+
+// isNetTransient returns true if err is a network error that may not happen again, e.g. a connection reset, DNS failure, or timeout
+func isNetTransient(err error) bool {
+	for _, e := range netTransient {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	// the server closed the connection before responding
+	var uErr *url.Error
+	if errors.As(err, &uErr) && errors.Is(uErr.Err, io.EOF) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var nErr net.Error
+	return errors.As(err, &nErr) && nErr.Timeout()
 }
 
-// retry retries f() with exponential backoff
-func retry(start time.Duration, maxTries int, f func() error) error {
+// retry retries f() with jittered exponential backoff, or the delay requested with Retry-After. Retries stop when ctx is done or
+// after MaxRetryElapsed. If WaitForQuotaReset is true, daily quota errors are retried when the quota resets without using a try
+func retry(ctx context.Context, start time.Duration, maxTries int, f func() error) error {
 	tries := 0
+	began := time.Now()
 	for {
 		err := f()
 		if err == nil {
 			return nil
 		}
+		if isRateLimit(err) {
+			atomic.AddInt64(&rateLimited, 1)
+		}
 
-		tries += 1
-		if tries == maxTries {
-			return err
+		var wait time.Duration
+		if WaitForQuotaReset && isDailyLimit(err) {
+			wait = quotaReset(time.Now())
+			// the wait isn't counted against MaxRetryElapsed
+			began = began.Add(wait)
+		} else {
+			tries += 1
+			if tries == maxTries {
+				return err
+			}
+
+			if !checkRetry(err) {
+				return err
+			}
+			wait = backoff(err, start)
+			start *= 2
+			if MaxRetryElapsed > 0 && time.Since(began)+wait > MaxRetryElapsed {
+				return err
+			}
 		}
+		atomic.AddInt64(&retries, 1)
 
-		if !checkRetry(err) {
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
 			return err
+		case <-t.C:
 		}
-
-		time.Sleep(start)
-		start *= 2
 	}
 }
 
 // Service is a Google Drive file service
 type Service struct {
 	*drive.FilesService
+	driveSvc       *drive.Service
+	drives         *drive.DrivesService
+	revisions      *drive.RevisionsService
 	initialBackoff time.Duration
 	tries          int
 	client         *http.Client
+	docs           *docs.Service
+	sheets         *sheets.Service
+	// sha256s are the SHA-256 checksums of listed files with PreferSHA256
+	sha256s sha256Sums
+
+	// RunID, if set, identifies the current run in logs and records
+	RunID string
+
+	// CopyRestricted, if true, downloads files whose owner has disabled downloading by copying the file,
+	// downloading the copy, and deleting the copy
+	CopyRestricted bool
+
+	// CopyOversized, if true, exports Docs and Slides presentations that are too large to export by copying the file,
+	// exporting the copy, and deleting the copy
+	CopyOversized bool
+
+	// SkipIdentical, if true, doesn't replace existing exported files that are identical to the new export, even if the Drive file's
+	// modified time has changed
+	SkipIdentical bool
+
+	// PinRevisions, if true, downloads the head revision of binary files recorded when they were listed,
+	// so the archive isn't affected by changes made during the run
+	PinRevisions bool
+
+	// ExtraFields are Drive file fields requested in addition to the fields needed to download files, e.g. lastModifyingUser
+	// or imageMediaMetadata(width,height). They're recorded in the manifest if Manifest.ExtraFields is set to the same fields
+	ExtraFields []string
+
+	// Logger, if set, is used for log entries. Otherwise entries at LogInfo and above are printed to stdout as text
+	Logger Logger
+
+	// PseudonymKey, if set, is used to replace file names in logs with keyed hashes. The same key always gives the same pseudonyms
+	PseudonymKey string
+
+	// PreferSHA256, if true, checks existing and downloaded non-Google files with Drive's SHA-256 checksum instead of MD5 when Drive
+	// has one. The checksum is listed with the files, so it must be set before listing
+	PreferSHA256 bool
+
+	// SheetChunkRows, if positive, is the number of rows requested at a time when exporting spreadsheets with the Sheets API,
+	// instead of 5000. Smaller chunks use less memory
+	SheetChunkRows int
+
+	// Throttle, if set, limits the bandwidth used by downloads
+	Throttle *Throttle
+
+	// RateLimit, if set, limits the rate of all API requests made by the Service, including downloads
+	RateLimit *RateLimiter
+
+	// Scanner, if set, scans each downloaded file before it's moved into place
+	Scanner Scanner
+	// Quarantine is the directory infected files are moved to. If empty, infected files are removed
+	Quarantine string
+
+	watchers progressWatchers
 }
 
+// Scopes are the OAuth scopes used by NewService
+var Scopes = []string{drive.DriveScope, drive.DriveMetadataScope}
+
+// ReadOnlyScopes are the OAuth scopes used by NewReadOnlyService
+var ReadOnlyScopes = []string{drive.DriveReadonlyScope}
+
 // NewService returns a new service using the service account credentials JSON file found at configPath for the given user
 // initialBackoff and tries are used to configure an exponential backoff strategy. Set tries to 1 to disable retries or set tries to <= 0 to retry infinitely
 //
@@ -117,32 +264,30 @@ type Service struct {
 //  * Add the client_id found in the JSON file to [Domain-wide Delegation](https://admin.google.com/ac/owl/domainwidedelegation)
 //    * Add the https://www.googleapis.com/auth/drive and https://www.googleapis.com/auth/drive.metadata scopes
 func NewService(configPath, user string, initialBackoff time.Duration, tries int) (*Service, error) {
-	buf, err := ioutil.ReadFile(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("could not read config: %w", err)
-	}
-
-	config, err := google.JWTConfigFromJSON(buf, drive.DriveScope, drive.DriveMetadataScope)
-	if err != nil {
-		return nil, fmt.Errorf("could not parse config: %w", err)
-	}
-	config.Subject = user
+	return newService(configPath, user, Scopes, initialBackoff, tries)
+}
 
-	client := config.Client(context.Background())
+// NewReadOnlyService is like NewService, but only requests the https://www.googleapis.com/auth/drive.readonly scope,
+// which is the only scope that needs to be added to Domain-wide Delegation.
+// Holds and CopyRestricted modify files, so they can't be used with a read-only Service
+func NewReadOnlyService(configPath, user string, initialBackoff time.Duration, tries int) (*Service, error) {
+	return newService(configPath, user, ReadOnlyScopes, initialBackoff, tries)
+}
 
-	driveSvc, err := drive.NewService(context.Background(), option.WithHTTPClient(client))
+// newService creates a Service with a ServicePool that's only used once. Use a ServicePool directly to create Services for many users
+func newService(configPath, user string, scopes []string, initialBackoff time.Duration, tries int) (*Service, error) {
+	p, err := NewServicePool(configPath, initialBackoff, tries)
 	if err != nil {
-		return nil, fmt.Errorf("Could not create drive service: %w", err)
+		return nil, err
 	}
-
-	return &Service{FilesService: drive.NewFilesService(driveSvc), initialBackoff: initialBackoff, tries: tries, client: client}, nil
+	return p.service(user, scopes)
 }
 
 // Root returns the root folder ID of the user's Google Drive
-func (s *Service) Root() (string, error) {
+func (s *Service) Root(ctx context.Context) (string, error) {
 	var id string
-	if err := retry(s.initialBackoff, s.tries, func() error {
-		file, err := s.FilesService.Get("root").Fields("id").Do()
+	if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		file, err := s.FilesService.Get("root").Fields("id").Context(ctx).Do()
 		if err != nil {
 			return fmt.Errorf("could not get root: %w", err)
 		}
@@ -154,32 +299,66 @@ func (s *Service) Root() (string, error) {
 	return id, nil
 }
 
+// listFields are the fields requested for each file when listing files
+var listFields = []googleapi.Field{
+	"nextPageToken",
+	"files/id",
+	"files/name",
+	"files/mimeType",
+	"files/md5Checksum",
+	"files/size",
+	"files/createdTime",
+	"files/modifiedTime",
+	"files/parents",
+	"files/trashed",
+	"files/capabilities/canDownload",
+	"files/copyRequiresWriterPermission",
+	"files/shortcutDetails/targetId",
+	"files/exportLinks",
+	"files/webViewLink",
+	"files/headRevisionId",
+	"files/ownedByMe",
+	"files/owners/emailAddress",
+	"files/sharedWithMeTime",
+	"files/viewedByMeTime",
+	"files/lastModifyingUser/emailAddress",
+}
+
+// withExtra returns fields with s.ExtraFields, and sha256Checksum with PreferSHA256, added, prefixed with prefix
+func (s *Service) withExtra(fields []googleapi.Field, prefix string) []googleapi.Field {
+	if len(s.ExtraFields) == 0 && !s.PreferSHA256 {
+		return fields
+	}
+	all := make([]googleapi.Field, 0, len(fields)+len(s.ExtraFields)+1)
+	all = append(all, fields...)
+	for _, f := range s.ExtraFields {
+		all = append(all, googleapi.Field(prefix+f))
+	}
+	if s.PreferSHA256 {
+		all = append(all, googleapi.Field(prefix+sha256Field))
+	}
+	return all
+}
+
 // List returns all files in the user's Google Drive
-func (s *Service) List() ([]*drive.File, error) {
-	var files []*drive.File
-	cmd := s.FilesService.List().
+func (s *Service) List(ctx context.Context) ([]*drive.File, error) {
+	return s.list(ctx, s.FilesService.List().
 		Corpora("user").
-		Fields(
-			"nextPageToken",
-			"files/id",
-			"files/name",
-			"files/mimeType",
-			"files/md5Checksum",
-			"files/modifiedTime",
-			"files/parents",
-			"files/shortcutDetails/targetId",
-			"files/exportLinks",
-		).
+		Fields(s.withExtra(listFields, "files/")...).
 		Spaces("drive").
-		PageSize(1000)
+		PageSize(1000))
+}
 
+// list returns all files returned by cmd, following page tokens
+func (s *Service) list(ctx context.Context, cmd *drive.FilesListCall) ([]*drive.File, error) {
 	var (
-		resp *drive.FileList
-		err  error
+		files []*drive.File
+		resp  *drive.FileList
+		err   error
 	)
 	for {
-		if err = retry(s.initialBackoff, s.tries, func() error {
-			resp, err = cmd.Do()
+		if err = retry(ctx, s.initialBackoff, s.tries, func() error {
+			resp, err = cmd.Context(ctx).Do()
 			if err != nil {
 				return fmt.Errorf("could not list files: %w", err)
 			}
@@ -195,47 +374,23 @@ func (s *Service) List() ([]*drive.File, error) {
 	}
 }
 
-// get returns the *drive.File corresponding to id
-// func (s *Service) get(id string) (*drive.File, error) {
-// 	cmd := s.FilesService.Get(id).
-// 		Fields(
-// 			"id",
-// 			"mimeType",
-// 			"md5Checksum",
-// 			"modifiedTime",
-// 			"exportLinks",
-// 		)
-
-// 	var (
-// 		resp *drive.File
-// 		err  error
-// 	)
-// 	if err = retry(s.initialBackoff, s.tries, func() error {
-// 		resp, err = cmd.Do()
-// 		if err != nil {
-// 			return fmt.Errorf("could not get file: %w", err)
-// 		}
-// 		return nil
-// 	}); err != nil {
-// 		return nil, err
-// 	}
-
-// 	return resp, nil
-// }
-
+// writeBody writes r to a temporary file that's renamed to path once it's complete, with its mtime set to the RFC3339 timestamp
 func writeBody(r io.Reader, path, timestamp string) error {
-	// write file
-	f, err := os.Create(path)
+	f, err := createAtomic(path)
 	if err != nil {
 		return fmt.Errorf("could not create file: %w", err)
 	}
-	defer f.Close()
 
 	if _, err := io.Copy(f, r); err != nil {
+		f.abort()
 		return fmt.Errorf("could not write export body: %w", err)
 	}
 
-	// set mtime
+	return f.commit(timestamp)
+}
+
+// setMtime sets the mtime of the file at path to the RFC3339 timestamp. If timestamp is empty, setMtime is a no-op
+func setMtime(path, timestamp string) error {
 	if timestamp == "" {
 		return nil
 	}
@@ -252,7 +407,7 @@ func writeBody(r io.Reader, path, timestamp string) error {
 	return nil
 }
 
-func (s *Service) exportAlt(file *drive.File, mimeType, path string) error {
+func (s *Service) exportAlt(ctx context.Context, file *drive.File, mimeType, path string) error {
 	var url string
 	for mime, u := range file.ExportLinks {
 		if mime == mimeType {
@@ -264,73 +419,128 @@ func (s *Service) exportAlt(file *drive.File, mimeType, path string) error {
 		return errors.New("could not complete export request: no export link found")
 	}
 
-	var (
-		resp *http.Response
-		err  error
-	)
-
-	if err = retry(s.initialBackoff, s.tries, func() error {
-		resp, err = s.client.Get(url)
+	return retry(ctx, s.initialBackoff, s.tries, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return fmt.Errorf("could not create export link request: %w", err)
+		}
+		resp, err := s.client.Do(req)
 		if err != nil {
 			return fmt.Errorf("could not complete export link request: %w", err)
 		}
-		return nil
-	}); err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("could not complete export link request: %s", resp.Status)
+		}
+
+		// the body is read in the retry so connections dropped while it's downloading are retried
+		return writeBody(s.watchers.reader(path, s.Throttle.Reader(resp.Body)), path, file.ModifiedTime)
+	})
+}
 
-	return writeBody(resp.Body, path, file.ModifiedTime)
+// isSizeLimit returns true if err was caused by a file being too large to export
+func isSizeLimit(err error) bool {
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		for _, e := range gErr.Errors {
+			if e.Reason == ErrReasonSizeLimitExceeded {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // Export exports (with specified mime type) the file with id to path.
 // Most users should use DownloadFile instead
-func (s *Service) Export(file *drive.File, mimeType, path string) error {
-	var (
-		resp *http.Response
-		err  error
-	)
-	if err = retry(s.initialBackoff, s.tries, func() error {
-		resp, err = s.FilesService.Export(file.Id, mimeType).Download()
+func (s *Service) Export(ctx context.Context, file *drive.File, mimeType, path string) error {
+	if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		resp, err := s.FilesService.Export(file.Id, mimeType).Context(ctx).Download()
 		if err != nil {
 			return fmt.Errorf("could not complete export request: %w", err)
 		}
-		return nil
+		defer resp.Body.Close()
+
+		// the body is read in the retry so connections dropped while it's downloading are retried
+		return writeBody(s.watchers.reader(path, s.Throttle.Reader(resp.Body)), path, file.ModifiedTime)
 	}); err != nil {
-		var gErr *googleapi.Error
-		if errors.As(err, &gErr) {
-			for _, e := range gErr.Errors {
-				if e.Reason == ErrReasonSizeLimitExceeded {
-					return s.exportAlt(file, mimeType, path)
-				}
+		if isSizeLimit(err) {
+			if aErr := s.exportAlt(ctx, file, mimeType, path); aErr != nil {
+				return fmt.Errorf("%w; %v", err, aErr)
 			}
+			return nil
 		}
 		return err
 	}
-	defer resp.Body.Close()
-
-	return writeBody(resp.Body, path, file.ModifiedTime)
+	return nil
 }
 
-// Download downloads the file with id to path.
+// Download downloads the file with id to path. If s.PinRevisions is true and file has a HeadRevisionId, that revision is downloaded.
+// Files of at least ResumableSize bytes are downloaded resumably. Downloads that don't match Drive's MD5 checksum are retried.
 // Most users should use DownloadFile instead
-func (s *Service) Download(file *drive.File, path string) error {
-	var (
-		resp *http.Response
-		err  error
-	)
-	if err = retry(s.initialBackoff, s.tries, func() error {
-		resp, err = s.Get(file.Id).Download()
-		if err != nil {
-			return fmt.Errorf("could not complete download request: %w", err)
+func (s *Service) Download(ctx context.Context, file *drive.File, path string) error {
+	return s.downloadSHA256(ctx, file, path, "")
+}
+
+// downloadSHA256 is like Download, but if sum is set, downloads are verified with sum, the file's hex encoded SHA-256 checksum,
+// instead of its MD5 checksum
+func (s *Service) downloadSHA256(ctx context.Context, file *drive.File, path, sum string) error {
+	if file.Size >= ResumableSize {
+		err := s.downloadResumable(ctx, file, path, sum)
+		var cErr *checksumError
+		if errors.As(err, &cErr) {
+			// the partial file was removed, so the file is downloaded again from the start
+			s.warnf("%s: %v; downloading again\n", s.logPath(path), err)
+			err = s.downloadResumable(ctx, file, path, sum)
 		}
-		return nil
-	}); err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	return writeBody(resp.Body, path, file.ModifiedTime)
+	return retry(ctx, s.initialBackoff, s.tries, func() error {
+		resp, err := s.downloadRequest(ctx, file, path, 0)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		// the body is read in the retry so connections dropped while it's downloading and corrupted downloads are retried
+		return s.writeVerified(ctx, s.watchers.reader(path, s.Throttle.Reader(resp.Body)), file, path, sum)
+	})
+}
+
+// downloadCall is a files or revisions download request
+type downloadCall interface {
+	Header() http.Header
+	Download(opts ...googleapi.CallOption) (*http.Response, error)
+}
+
+// downloadRequest requests the contents of file, which is being downloaded to path, starting at offset
+func (s *Service) downloadRequest(ctx context.Context, file *drive.File, path string, offset int64) (*http.Response, error) {
+	do := func(c downloadCall) (*http.Response, error) {
+		if offset > 0 {
+			c.Header().Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+		return c.Download()
+	}
+
+	if s.PinRevisions && file.HeadRevisionId != "" {
+		resp, err := do(s.revisions.Get(file.Id, file.HeadRevisionId).Context(ctx))
+		var gErr *googleapi.Error
+		if !errors.As(err, &gErr) || gErr.Code != 404 {
+			if err != nil {
+				return nil, fmt.Errorf("could not complete revision download request: %w", err)
+			}
+			return resp, nil
+		}
+		s.warnf("%s: pinned revision %s not found, downloading current revision\n", s.logPath(path), file.HeadRevisionId)
+	}
+
+	resp, err := do(s.Get(file.Id).SupportsAllDrives(true).Context(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("could not complete download request: %w", err)
+	}
+	return resp, nil
 }
 
 // md5Verify returns true if a file exists at path and md5(file) == hash
@@ -350,6 +560,29 @@ func md5Verify(path, hash string) bool {
 	return hex.EncodeToString(h.Sum(nil)[:]) == hash
 }
 
+// sameContents returns true if the files at a and b have the same contents
+func sameContents(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil || ai.Size() != bi.Size() {
+		return false
+	}
+
+	ah, err := sha256File(a)
+	if err != nil {
+		return false
+	}
+	bh, err := sha256File(b)
+	if err != nil {
+		return false
+	}
+
+	return ah == bh
+}
+
 // mtimeVerify returns true if a file exists at path and mtime(file) >= t
 func mtimeVerify(path string, t time.Time) bool {
 	info, err := os.Stat(path)
@@ -362,30 +595,161 @@ func mtimeVerify(path string, t time.Time) bool {
 
 // DownloadFile downloads f to path. It automatically resolves shortcuts and converts Google Docs, Slides, Sheets, and Drawings to downloadable formats.
 // If downloaded is false, the file was not downloaded because the existing file matched.
-func (s *Service) DownloadFile(f *drive.File, path string) (downloaded bool, err error) {
+func (s *Service) DownloadFile(ctx context.Context, f *drive.File, path string) (downloaded bool, err error) {
+	return s.DownloadFileAs(ctx, f, ExportTypes[f.MimeType], path)
+}
+
+// DownloadFileAs is like DownloadFile, but Google Docs, Slides, Sheets, and Drawings are exported as exportType.
+// If exportType is empty, f is downloaded directly
+func (s *Service) DownloadFileAs(ctx context.Context, f *drive.File, exportType, path string) (downloaded bool, err error) {
+	// sum is Drive's SHA-256 checksum of binary files with PreferSHA256, which downloads are verified with instead of MD5
+	sum := s.listedSHA256(f, exportType)
+	if sum != "" {
+		if got, err := sha256File(path); err == nil && got == sum {
+			return false, nil
+		}
+	}
+
+	if ok, err := needsDownload(f, exportType, path); !ok {
+		return false, err
+	}
+
+	if exportType == ExportTypeSheetsCSV {
+		return true, s.exportSheetCSV(ctx, f, path)
+	}
+
+	// if google docs file, download exported file
+	if exportType != "" {
+		if err = s.download(ctx, f, exportType, path, ""); err == errIdentical {
+			return false, nil
+		}
+		return true, err
+	}
+
+	// otherwise, download file directly
+	return true, s.download(ctx, f, "", path, sum)
+}
+
+// listedSHA256 returns Drive's SHA-256 checksum of f, if it's downloaded directly and was listed with PreferSHA256
+func (s *Service) listedSHA256(f *drive.File, exportType string) string {
+	if exportType != "" || !s.PreferSHA256 || f.Md5Checksum == "" {
+		return ""
+	}
+	return s.sha256s.get(f.Id)
+}
+
+// needsDownload returns true if f, exported as exportType if set, doesn't match the existing file at path.
+// ErrNoExportableFormat is returned if f can't be downloaded
+func needsDownload(f *drive.File, exportType, path string) (bool, error) {
 	// check for skipped mime types
 	if _, ok := SkipTypes[f.MimeType]; ok || strings.HasPrefix(f.MimeType, FileTypeSDKPrefix) {
 		return false, ErrNoExportableFormat
 	}
 
-	// if google docs file, download exported file
-	if typ, ok := ExportTypes[f.MimeType]; ok {
-		// don't download exported file if mtime is same
+	// don't export sheets again if the directory was completed for the same modified time
+	if exportType == ExportTypeSheetsCSV {
+		return !sheetDirCurrent(path, f.ModifiedTime), nil
+	}
+
+	// don't download exported file if mtime is same
+	if exportType != "" {
+		// files exported with their APIs by a previous run are checked at the path of the API export
+		p, typ := exportedPath(f, exportType, path)
+		if typ == ExportTypeSheetsCSV {
+			return !sheetDirCurrent(p, f.ModifiedTime), nil
+		}
+		path = p
 		if f.ModifiedTime != "" {
 			t, err := time.Parse(time.RFC3339, f.ModifiedTime)
 			if err == nil && mtimeVerify(path, t) {
 				return false, nil
 			}
 		}
-
-		return true, s.Export(f, typ, path)
+		return true, nil
 	}
 
 	// don't download file if md5sum is same
-	if md5Verify(path, f.Md5Checksum) {
-		return false, nil
+	return !md5Verify(path, f.Md5Checksum), nil
+}
+
+// fetch exports f as exportType, or downloads it directly if exportType is empty, to path. Downloads are verified with sum,
+// the file's SHA-256 checksum, if set. If exporting fails, Docs and Sheets are exported with their APIs as a last resort
+func (s *Service) fetch(ctx context.Context, f *drive.File, exportType, path, sum string) error {
+	if exportType == "" {
+		return s.commit(f, path, func(p string) error {
+			return s.downloadSHA256(ctx, f, p, sum)
+		})
 	}
 
-	// otherwise, download file directly
-	return true, s.Download(f, path)
+	err := s.commit(f, path, func(p string) error {
+		return s.Export(ctx, f, exportType, p)
+	})
+	var iErr *InfectedError
+	if err == nil || err == errIdentical || errors.As(err, &iErr) || isRestricted(err) || ctx.Err() != nil {
+		return err
+	}
+
+	// exporting a copy of a huge Doc or Slides presentation often succeeds when exporting the original doesn't
+	if s.CopyOversized && isSizeLimit(err) && CopyOversizedTypes[f.MimeType] {
+		cErr := s.withCopy(ctx, f, path, func(cp *drive.File) error {
+			return s.commit(cp, path, func(p string) error {
+				return s.Export(ctx, cp, exportType, p)
+			})
+		})
+		if cErr == nil || cErr == errIdentical {
+			s.warnf("%s: exported copy after export failed: %v\n", s.logPath(path), err)
+			return cErr
+		}
+		err = fmt.Errorf("%v; could not export copy: %w", err, cErr)
+	}
+
+	if fErr := s.exportAPI(ctx, f, path); fErr != nil {
+		if fErr == errNoAPIExport {
+			return err
+		}
+		return fmt.Errorf("%v; could not export with API: %w", err, fErr)
+	}
+	s.warnf("%s: exported with API after export failed: %v\n", s.logPath(path), err)
+	return nil
+}
+
+// errIdentical is returned by commit when the new file is identical to the existing file
+var errIdentical = errors.New("identical to existing file")
+
+// commit calls write with the path f should be written to. If the Service has a Scanner or SkipIdentical is set,
+// write is given a temporary path which is scanned and compared to the existing file before being moved to path.
+// If SkipIdentical is set and the existing file is identical, only its mtime is updated and errIdentical is returned
+func (s *Service) commit(f *drive.File, path string, write func(path string) error) error {
+	_, statErr := os.Stat(path)
+	compare := s.SkipIdentical && statErr == nil
+	if s.Scanner == nil && !compare {
+		return write(path)
+	}
+
+	tmp := path + PartialSuffix
+	if err := write(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if s.Scanner != nil {
+		if err := s.scan(f, tmp, path); err != nil {
+			return err
+		}
+	}
+
+	if compare && sameContents(tmp, path) {
+		os.Remove(tmp)
+		if err := setMtime(path, f.ModifiedTime); err != nil {
+			return err
+		}
+		return errIdentical
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not move file into place: %w", err)
+	}
+
+	return nil
 }
@@ -0,0 +1,107 @@
+package drive
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/korylprince/drive-archive/drive/sanitize"
+)
+
+// DuplicatePolicy is how sibling folders with the same name are handled
+type DuplicatePolicy int
+
+// Duplicate folder policies
+const (
+	// DuplicateMerge merges the contents of sibling folders with the same name into one directory
+	DuplicateMerge DuplicatePolicy = iota
+	// DuplicateSuffix adds _2, _3, etc. to the names of sibling folders with the same name, in order of their IDs
+	DuplicateSuffix
+	// DuplicateFail fails with a *DuplicateFoldersError if any sibling folders have the same name
+	DuplicateFail
+)
+
+// DuplicateFolder is a set of sibling folders with the same name
+type DuplicateFolder struct {
+	// Path is the path of the folders in the tree
+	Path string
+	IDs  []string
+}
+
+// DuplicateFoldersError is returned by ResolveDuplicateFolders with DuplicateFail
+type DuplicateFoldersError struct {
+	Duplicates []*DuplicateFolder
+}
+
+func (e *DuplicateFoldersError) Error() string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "found %d sets of duplicate folders:", len(e.Duplicates))
+	for _, d := range e.Duplicates {
+		fmt.Fprintf(b, "\n\t%s: %s", d.Path, strings.Join(d.IDs, ", "))
+	}
+	return b.String()
+}
+
+// ResolveDuplicateFolders finds sibling folders in tree with the same name and handles them with policy.
+// With DuplicateSuffix, the duplicate folders are replaced in their parent by renamed copies, so folders with multiple parents
+// are only renamed where they're duplicates
+func ResolveDuplicateFolders(tree *File, policy DuplicatePolicy) error {
+	if policy == DuplicateMerge {
+		return nil
+	}
+
+	var duplicates []*DuplicateFolder
+	tree.Walk(func(path string, f *File) error {
+		names := make(map[string]bool)
+		groups := make(map[string][]int)
+		var order []string
+		for i, c := range f.Files {
+			name := sanitize.Name(c.Name)
+			names[name] = true
+			if !c.IsFolder() {
+				continue
+			}
+			if _, ok := groups[name]; !ok {
+				order = append(order, name)
+			}
+			groups[name] = append(groups[name], i)
+		}
+
+		for _, name := range order {
+			idx := groups[name]
+			if len(idx) < 2 {
+				continue
+			}
+			sort.SliceStable(idx, func(i, j int) bool { return f.Files[idx[i]].ID < f.Files[idx[j]].ID })
+
+			d := &DuplicateFolder{Path: filepath.Join(path, name)}
+			for _, i := range idx {
+				d.IDs = append(d.IDs, f.Files[i].ID)
+			}
+			duplicates = append(duplicates, d)
+
+			if policy != DuplicateSuffix {
+				continue
+			}
+
+			n := 2
+			for _, i := range idx[1:] {
+				for names[fmt.Sprintf("%s_%d", name, n)] {
+					n++
+				}
+				c := *f.Files[i]
+				c.Name = fmt.Sprintf("%s_%d", name, n)
+				names[c.Name] = true
+				f.Files[i] = &c
+			}
+		}
+		return nil
+	})
+
+	if policy == DuplicateFail && len(duplicates) > 0 {
+		return &DuplicateFoldersError{Duplicates: duplicates}
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package drive
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// KeepFile is the name of the placeholder written to empty folders so they survive being copied to object storage,
+// which has no directories
+const KeepFile = ".keep"
+
+// EmptyFolders is how empty folders are preserved for object storage
+type EmptyFolders int
+
+// Empty folder policies
+const (
+	// EmptyFoldersNone doesn't record empty folders
+	EmptyFoldersNone EmptyFolders = iota
+	// EmptyFoldersManifest records empty folders in the manifest only
+	EmptyFoldersManifest
+	// EmptyFoldersKeep records empty folders in the manifest and writes a KeepFile placeholder in each of them
+	EmptyFoldersKeep
+)
+
+// RecordEmptyFolders walks the manifest's root and records the folders that are empty or only contain a KeepFile in
+// EmptyFolders. If policy is EmptyFoldersKeep, a KeepFile is written to each empty folder. KeepFiles left in folders
+// that are no longer empty are removed. It returns the number of empty folders found
+func (m *Manifest) RecordEmptyFolders(policy EmptyFolders) (int, error) {
+	if policy == EmptyFoldersNone {
+		return 0, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// directories written as files, e.g. Sheets exported as CSV files, aren't folders
+	known := make(map[string]bool, len(m.Files))
+	for _, e := range m.Files {
+		known[filepath.Clean(m.localPath(e))] = true
+	}
+
+	var empty, stale []string
+	err := filepath.Walk(m.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || path == m.root {
+			return nil
+		}
+		if known[path] {
+			return filepath.SkipDir
+		}
+
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %w", path, err)
+		}
+		keep := filepath.Join(path, KeepFile)
+		hasKeep := false
+		for _, e := range entries {
+			if e.Name() == KeepFile {
+				hasKeep = true
+			}
+		}
+		if len(entries) > 1 || (len(entries) == 1 && !hasKeep) {
+			// placeholders are removed after walking, since Walk still visits them
+			if hasKeep {
+				stale = append(stale, keep)
+			}
+			return nil
+		}
+
+		if rel, err := filepath.Rel(m.root, path); err == nil {
+			empty = append(empty, filepath.ToSlash(rel))
+		}
+		if policy == EmptyFoldersKeep && !hasKeep {
+			if err = ioutil.WriteFile(keep, nil, 0644); err != nil {
+				return fmt.Errorf("could not write %s: %w", keep, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not walk %s: %w", m.root, err)
+	}
+	for _, keep := range stale {
+		if err = os.Remove(keep); err != nil {
+			return 0, fmt.Errorf("could not remove %s: %w", keep, err)
+		}
+	}
+
+	sort.Strings(empty)
+	m.EmptyFolders = empty
+	return len(empty), nil
+}
@@ -0,0 +1,165 @@
+package drive
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Glob is a compiled glob pattern matched against slash separated tree paths. * and ? match within a path segment,
+// [...] matches a character class, and ** matches any number of segments. Patterns without a slash match the last
+// segment of a path, e.g. *.pdf matches every PDF
+type Glob struct {
+	Pattern string
+	re      *regexp.Regexp
+	base    bool
+}
+
+// CompileGlob compiles pattern into a Glob
+func CompileGlob(pattern string) (*Glob, error) {
+	b := new(strings.Builder)
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == -1 {
+				return nil, fmt.Errorf("invalid pattern %s: unterminated character class", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+	}
+	return &Glob{Pattern: pattern, re: re, base: !strings.Contains(pattern, "/")}, nil
+}
+
+// Match returns true if the slash separated path matches the pattern
+func (g *Glob) Match(path string) bool {
+	if g.base {
+		path = path[strings.LastIndex(path, "/")+1:]
+	}
+	return g.re.MatchString(path)
+}
+
+// Filter chooses which files in a tree are downloaded. Paths are tree paths, with invalid characters removed from names, starting with the name of the tree's root,
+// e.g. My Drive/Projects/report.pdf
+type Filter struct {
+	// Include, if not empty, only downloads files matching at least one of its patterns. Folders are always walked
+	Include []*Glob
+	// Exclude skips files and folders matching any of its patterns. Excluded folders aren't created or walked
+	Exclude []*Glob
+	// MimeInclude, if not empty, only downloads files whose mime type starts with one of its prefixes, e.g. video/ or FileTypeGooglePrefix
+	MimeInclude []string
+	// MimeExclude skips files whose mime type starts with any of its prefixes
+	MimeExclude []string
+	// LabelInclude, if not nil, only downloads files whose IDs are in LabelInclude. It's set by Service.LabelFilter
+	LabelInclude map[string]bool
+	// LabelExclude skips files whose IDs are in LabelExclude. It's set by Service.LabelFilter
+	LabelExclude map[string]bool
+	// ModifiedAfter, if set, only downloads files last modified after ModifiedAfter
+	ModifiedAfter time.Time
+	// ModifiedBefore, if set, only downloads files last modified before ModifiedBefore
+	ModifiedBefore time.Time
+}
+
+// matchAny returns true if any of globs match one of paths
+func matchAny(globs []*Glob, paths ...string) bool {
+	for _, g := range globs {
+		for _, p := range paths {
+			if g.Match(p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lazy returns true if folders should only be created for the files that are downloaded
+func (f *Filter) lazy() bool {
+	return f != nil && (len(f.Include) > 0 || len(f.MimeInclude) > 0 || len(f.MimeExclude) > 0 || f.LabelInclude != nil || len(f.LabelExclude) > 0 || !f.ModifiedAfter.IsZero() || !f.ModifiedBefore.IsZero())
+}
+
+// Folder returns true if the folder at the tree path should be walked
+func (f *Filter) Folder(path string) bool {
+	if f == nil {
+		return true
+	}
+	path = filepath.ToSlash(path)
+	// match the folder itself and patterns for its contents, e.g. **/node_modules/**
+	return !matchAny(f.Exclude, path, path+"/")
+}
+
+// hasPrefix returns true if s starts with any of prefixes
+func hasPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// mime returns true if file's mime type is allowed by the Filter
+func (f *Filter) mime(file *drive.File) bool {
+	if hasPrefix(file.MimeType, f.MimeExclude) {
+		return false
+	}
+	return len(f.MimeInclude) == 0 || hasPrefix(file.MimeType, f.MimeInclude)
+}
+
+// modified returns true if file's modified time is in the Filter's range. Files with an invalid modified time are always in the range
+func (f *Filter) modified(file *drive.File) bool {
+	if f.ModifiedAfter.IsZero() && f.ModifiedBefore.IsZero() {
+		return true
+	}
+	t, err := time.Parse(time.RFC3339, file.ModifiedTime)
+	if err != nil {
+		return true
+	}
+	if !f.ModifiedAfter.IsZero() && !t.After(f.ModifiedAfter) {
+		return false
+	}
+	return f.ModifiedBefore.IsZero() || t.Before(f.ModifiedBefore)
+}
+
+// File returns true if file, at the tree path, should be downloaded
+func (f *Filter) File(path string, file *drive.File) bool {
+	if f == nil {
+		return true
+	}
+	if !f.mime(file) || !f.labels(file) || !f.modified(file) {
+		return false
+	}
+	path = filepath.ToSlash(path)
+	if matchAny(f.Exclude, path) {
+		return false
+	}
+	return len(f.Include) == 0 || matchAny(f.Include, path)
+}
@@ -0,0 +1,126 @@
+package drive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// PartialSuffix is added to the names of files that are scanned or compared before they're moved into place
+const PartialSuffix = ".drive-archive.partial"
+
+// PartSuffix is added to the names of resumable downloads while they're written. Their resume state is written next to them
+// with ResumeStateSuffix added
+const PartSuffix = ".drive-archive.part"
+
+// ResumeStateSuffix is added to the name of a resumable download's PartSuffix file to get the name of its resume state
+const ResumeStateSuffix = ".json"
+
+// TempSuffixes are the suffixes of temporary files written while downloading. They're renamed into place when finished.
+// They're specific to this tool, so they can't match archived files
+var TempSuffixes = []string{AtomicSuffix, PartialSuffix, PartSuffix, PartSuffix + ResumeStateSuffix}
+
+// GCMinAge is the minimum time since a temporary file was modified before GC removes it,
+// so files being written by another run aren't removed
+const GCMinAge = time.Hour
+
+// hasSuffix returns true if name has one of suffixes
+func hasSuffix(name string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// GC removes stale temporary files with one of suffixes left in root by interrupted runs. If no suffixes are given, TempSuffixes are used.
+// Files modified in the last GCMinAge are kept. It returns the number of files removed and their total size
+func GC(root string, suffixes ...string) (files int, size int64, err error) {
+	if len(suffixes) == 0 {
+		suffixes = TempSuffixes
+	}
+	cutoff := time.Now().Add(-GCMinAge)
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !hasSuffix(info.Name(), suffixes) || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err = os.Remove(path); err != nil {
+			return fmt.Errorf("could not remove %s: %w", path, err)
+		}
+		files++
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return files, size, fmt.Errorf("could not walk %s: %w", root, err)
+	}
+	return files, size, nil
+}
+
+// GCCheckpoints removes the resumable downloads in root that can't be resumed because their file isn't in files, e.g. because it
+// was deleted from Drive, or has changed since the download started. Downloads whose resume state is missing or unreadable are
+// removed too. Downloads modified in the last GCMinAge, and in the directories skip, e.g. files listed separately, are kept.
+// It returns the number of files removed and their total size
+func GCCheckpoints(root string, files []*drive.File, skip ...string) (n int, size int64, err error) {
+	listed := make(map[string]*drive.File, len(files))
+	for _, f := range files {
+		listed[f.Id] = f
+	}
+	// stale returns true if the download at part can't be resumed
+	stale := func(part string) bool {
+		buf, err := os.ReadFile(part + ResumeStateSuffix)
+		if err != nil {
+			return true
+		}
+		st := new(resumeState)
+		if err = json.Unmarshal(buf, st); err != nil {
+			return true
+		}
+		f, ok := listed[st.ID]
+		return !ok || f.Md5Checksum != st.MD5 || f.Size != st.Size
+	}
+
+	cutoff := time.Now().Add(-GCMinAge)
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			for _, dir := range skip {
+				if path == dir {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		part := strings.TrimSuffix(path, ResumeStateSuffix)
+		if !strings.HasSuffix(part, PartSuffix) || info.ModTime().After(cutoff) || !stale(part) {
+			return nil
+		}
+		if err = os.Remove(path); err != nil {
+			return fmt.Errorf("could not remove %s: %w", path, err)
+		}
+		n++
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return n, size, fmt.Errorf("could not walk %s: %w", root, err)
+	}
+	return n, size, nil
+}
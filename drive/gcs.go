@@ -0,0 +1,84 @@
+package drive
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+// GCS uploads files to a Google Cloud Storage bucket
+type GCS struct {
+	Bucket string
+	// Prefix is prepended to uploaded object names
+	Prefix string
+
+	svc            *storage.Service
+	initialBackoff time.Duration
+	tries          int
+}
+
+// NewGCS returns a new GCS uploading to bucket as the service account in credentialsFile, which needs permission to create objects
+// in the bucket. Unlike Drive, the service account isn't used to impersonate a user
+func NewGCS(ctx context.Context, credentialsFile, bucket, prefix string) (*GCS, error) {
+	svc, err := storage.NewService(ctx, option.WithCredentialsFile(credentialsFile), option.WithScopes(storage.DevstorageReadWriteScope))
+	if err != nil {
+		return nil, fmt.Errorf("could not create storage service: %w", err)
+	}
+	return &GCS{Bucket: bucket, Prefix: prefix, svc: svc, initialBackoff: time.Second, tries: 8}, nil
+}
+
+// String returns the bucket's gs:// URL
+func (g *GCS) String() string {
+	return "gs://" + path.Join(g.Bucket, g.Prefix)
+}
+
+// Upload uploads the file at local to the bucket as name, prefixed with g.Prefix, unless an object with the same name and MD5
+// checksum already exists. It returns false if the upload was skipped
+func (g *GCS) Upload(ctx context.Context, local, name string) (bool, error) {
+	f, err := os.Open(local)
+	if err != nil {
+		return false, fmt.Errorf("could not open file: %w", err)
+	}
+	defer f.Close()
+	sum, err := md5Section(f)
+	if err != nil {
+		return false, fmt.Errorf("could not hash file: %w", err)
+	}
+	md5Hash := base64.StdEncoding.EncodeToString(sum)
+	name = path.Join(g.Prefix, name)
+
+	var existing *storage.Object
+	err = retry(ctx, g.initialBackoff, g.tries, func() error {
+		existing, err = g.svc.Objects.Get(g.Bucket, name).Fields("md5Hash").Context(ctx).Do()
+		return err
+	})
+	var gErr *googleapi.Error
+	switch {
+	case err == nil && existing.Md5Hash == md5Hash:
+		return false, nil
+	case err != nil && !(errors.As(err, &gErr) && gErr.Code == http.StatusNotFound):
+		return false, fmt.Errorf("could not check object: %w", err)
+	}
+
+	// GCS checks the uploaded object against Md5Hash
+	if err = retry(ctx, g.initialBackoff, g.tries, func() error {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("could not seek file: %w", err)
+		}
+		_, err := g.svc.Objects.Insert(g.Bucket, &storage.Object{Name: name, Md5Hash: md5Hash}).Media(f).Context(ctx).Do()
+		return err
+	}); err != nil {
+		return false, fmt.Errorf("could not upload object: %w", err)
+	}
+	return true, nil
+}
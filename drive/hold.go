@@ -0,0 +1,121 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Hold configures a legal hold that is applied to files after they're successfully archived
+type Hold struct {
+	// LabelID is the ID of a Drive Label to apply to archived files
+	LabelID string
+	// FolderID is the ID of a folder to move archived files into
+	FolderID string
+	// Log, if set, records each hold action as a CSV row of time, run id, file id, path, and action
+	Log io.Writer
+
+	mu sync.Mutex
+}
+
+func (h *Hold) record(runID string, f *drive.File, path, action string) error {
+	if h.Log == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w := csv.NewWriter(h.Log)
+	if err := w.Write([]string{time.Now().Format(time.RFC3339), runID, f.Id, path, action}); err != nil {
+		return fmt.Errorf("could not write hold record: %w", err)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// applyLabel applies the Drive Label with labelID to f. The API client doesn't support labels, so the request is made directly
+func (s *Service) applyLabel(ctx context.Context, f *drive.File, labelID string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"kind":               "drive#modifyLabelsRequest",
+		"labelModifications": []map[string]string{{"labelId": labelID}},
+	})
+	if err != nil {
+		return fmt.Errorf("could not encode request: %w", err)
+	}
+
+	u := fmt.Sprintf("%sfiles/%s/modifyLabels", s.driveSvc.BasePath, url.PathEscape(f.Id))
+	return retry(ctx, s.initialBackoff, s.tries, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("could not create modify labels request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("could not complete modify labels request: %w", err)
+		}
+		defer resp.Body.Close()
+		if err = googleapi.CheckResponse(resp); err != nil {
+			return fmt.Errorf("could not complete modify labels request: %w", err)
+		}
+		return nil
+	})
+}
+
+// moveTo moves f into the folder with folderID, removing it from the folder with parentID, the folder it was archived from.
+// Its other parents, e.g. other users' shared folders, are kept. If parentID is empty or isn't one of f's parents, f is only added
+// to the folder
+func (s *Service) moveTo(ctx context.Context, f *drive.File, folderID, parentID string) error {
+	remove := ""
+	for _, p := range f.Parents {
+		if p == folderID {
+			return nil
+		}
+		if p == parentID {
+			remove = p
+		}
+	}
+	return retry(ctx, s.initialBackoff, s.tries, func() error {
+		call := s.FilesService.Update(f.Id, &drive.File{}).AddParents(folderID)
+		if remove != "" {
+			call = call.RemoveParents(remove)
+		}
+		_, err := call.Fields("id").Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("could not complete move request: %w", err)
+		}
+		return nil
+	})
+}
+
+// Hold applies h to f, which was archived at path from the folder with parentID
+func (s *Service) Hold(ctx context.Context, h *Hold, f *drive.File, parentID, path string) error {
+	if h.LabelID != "" {
+		if err := s.applyLabel(ctx, f, h.LabelID); err != nil {
+			return fmt.Errorf("could not apply label: %w", err)
+		}
+		if err := h.record(s.RunID, f, path, "label:"+h.LabelID); err != nil {
+			return err
+		}
+	}
+
+	if h.FolderID != "" {
+		if err := s.moveTo(ctx, f, h.FolderID, parentID); err != nil {
+			return fmt.Errorf("could not move to hold folder: %w", err)
+		}
+		if err := h.record(s.RunID, f, path, "move:"+h.FolderID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
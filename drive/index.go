@@ -0,0 +1,137 @@
+package drive
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{"escape": url.PathEscape}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Dir}}</title>
+</head>
+<body>
+<h1>{{.Dir}}</h1>
+<ul>
+{{- range .Dirs}}
+<li><a href="{{escape .Name}}/{{.Index}}">{{.Name}}/</a></li>
+{{- end}}
+</ul>
+<table>
+<tr><th>Archived File</th><th>Drive Name</th><th>Modified</th><th>Original</th></tr>
+{{- range .Files}}
+<tr><td><a href="{{escape .File}}">{{.File}}</a></td><td>{{.Entry.Name}}</td><td>{{.Entry.ModifiedTime}}</td><td>{{if .Entry.WebViewLink}}<a href="{{.Entry.WebViewLink}}">Open in Drive</a>{{end}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+type indexFile struct {
+	File  string
+	Entry *ManifestEntry
+}
+
+type indexLink struct {
+	Name  string
+	Index string
+}
+
+type indexDir struct {
+	Dir   string
+	Dirs  []*indexLink
+	Files []*indexFile
+}
+
+// indexName returns the name of d's index file. If an archived file is named index.html, archive_index.html is used instead
+func (d *indexDir) indexName() string {
+	for _, f := range d.Files {
+		if f.File == "index.html" {
+			return "archive_index.html"
+		}
+	}
+	return "index.html"
+}
+
+// WriteIndexes writes an index.html file to each directory containing archived files, linking each local file to its original in Drive.
+// Directories containing an archived index.html file get an archive_index.html file instead
+func (m *Manifest) WriteIndexes() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dirs := make(map[string]*indexDir)
+	get := func(dir string) *indexDir {
+		d, ok := dirs[dir]
+		if !ok {
+			d = &indexDir{Dir: dir}
+			dirs[dir] = d
+		}
+		return d
+	}
+
+	for _, e := range m.Files {
+		if !e.Captured() {
+			continue
+		}
+		dir, name := path.Split(path.Clean(e.Path))
+		dir = path.Clean(dir)
+		get(dir).Files = append(get(dir).Files, &indexFile{File: name, Entry: e})
+
+		// link each directory from its parent
+		for dir != "." && dir != "/" {
+			parent, name := path.Split(dir)
+			parent = path.Clean(parent)
+			p := get(parent)
+			found := false
+			for _, d := range p.Dirs {
+				if d.Name == name {
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+			p.Dirs = append(p.Dirs, &indexLink{Name: name})
+			dir = parent
+		}
+	}
+
+	for dir, d := range dirs {
+		for _, l := range d.Dirs {
+			l.Index = dirs[path.Join(dir, l.Name)].indexName()
+		}
+		sort.Slice(d.Dirs, func(i, j int) bool { return d.Dirs[i].Name < d.Dirs[j].Name })
+		sort.Slice(d.Files, func(i, j int) bool { return d.Files[i].File < d.Files[j].File })
+
+		p := filepath.Join(filepath.FromSlash(dir), d.indexName())
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(m.root, p)
+		}
+		if err := writeIndex(p, d); err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+	}
+
+	return nil
+}
+
+func writeIndex(path string, d *indexDir) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create index: %w", err)
+	}
+	defer f.Close()
+
+	if err = indexTemplate.Execute(f, d); err != nil {
+		return fmt.Errorf("could not write index: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,269 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ipfsError is an error returned by the IPFS RPC API
+type ipfsError struct {
+	Status  int    `json:"-"`
+	Message string `json:"Message"`
+}
+
+func (e *ipfsError) Error() string {
+	return fmt.Sprintf("ipfs error %d: %s", e.Status, e.Message)
+}
+
+// retryable returns true if the request should be retried. The RPC API returns 500 for all command errors, so only
+// failed connections and gateway errors are retried
+func (e *ipfsError) retryable() bool {
+	return e.Status == http.StatusBadGateway || e.Status == http.StatusServiceUnavailable || e.Status == http.StatusGatewayTimeout
+}
+
+// IPFS adds files to an IPFS node (e.g. Kubo) with its RPC API. Files are added as CIDv1 with raw leaves and pinned,
+// and are linked into a directory in the node's Mutable File System so the whole archive has a single root CID.
+// IPFS support is experimental
+type IPFS struct {
+	// API is the URL of the node's RPC API, e.g. http://127.0.0.1:5001
+	API string
+	// Dir is the MFS directory the archive is linked into
+	Dir string
+
+	initialBackoff time.Duration
+	tries          int
+	client         *http.Client
+}
+
+// NewIPFS returns a new IPFS using the RPC API at api and linking files into the MFS directory dir
+func NewIPFS(api, dir string) *IPFS {
+	return &IPFS{API: strings.TrimRight(api, "/"), Dir: path.Clean("/" + dir), initialBackoff: time.Second, tries: 8, client: http.DefaultClient}
+}
+
+// do calls the RPC API command with args. If v is not nil, the JSON response is decoded into it. If body is not nil,
+// it's called to create the request body and its content type for each try
+func (i *IPFS) do(ctx context.Context, cmd string, args url.Values, body func() (io.Reader, string), v interface{}) error {
+	return retry(ctx, i.initialBackoff, i.tries, func() error {
+		var r io.Reader
+		typ := ""
+		if body != nil {
+			r, typ = body()
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.API+"/api/v0/"+cmd+"?"+args.Encode(), r)
+		if err != nil {
+			return err
+		}
+		if typ != "" {
+			req.Header.Set("Content-Type", typ)
+		}
+
+		resp, err := i.client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return &ipfsError{Status: http.StatusServiceUnavailable, Message: err.Error()}
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			iErr := &ipfsError{Status: resp.StatusCode}
+			if err = json.NewDecoder(resp.Body).Decode(iErr); err != nil {
+				iErr.Message = resp.Status
+			}
+			return iErr
+		}
+
+		if v == nil {
+			_, err = io.Copy(io.Discard, resp.Body)
+			return err
+		}
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return fmt.Errorf("could not decode response: %w", err)
+		}
+		return nil
+	})
+}
+
+// AddFile adds and pins the file at local, returning its CID
+func (i *IPFS) AddFile(ctx context.Context, local string) (string, error) {
+	args := url.Values{"cid-version": {"1"}, "raw-leaves": {"true"}, "pin": {"true"}, "quieter": {"true"}}
+	var added struct {
+		Hash string `json:"Hash"`
+	}
+	err := i.do(ctx, "add", args, func() (io.Reader, string) {
+		// stream the file so large files aren't read into memory
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		go func() {
+			f, err := os.Open(local)
+			if err != nil {
+				pw.CloseWithError(fmt.Errorf("could not open file: %w", err))
+				return
+			}
+			defer f.Close()
+			part, err := mw.CreateFormFile("file", filepath.Base(local))
+			if err == nil {
+				_, err = io.Copy(part, f)
+			}
+			if err == nil {
+				err = mw.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		return pr, mw.FormDataContentType()
+	}, &added)
+	if err != nil {
+		return "", err
+	}
+	if added.Hash == "" {
+		return "", fmt.Errorf("no CID returned for %s", local)
+	}
+	return added.Hash, nil
+}
+
+// link links cid to the MFS path rel, relative to i.Dir, creating parent directories as needed
+func (i *IPFS) link(ctx context.Context, cid, rel string) error {
+	dest := path.Join(i.Dir, rel)
+	if err := i.do(ctx, "files/mkdir", url.Values{"arg": {path.Dir(dest)}, "parents": {"true"}, "cid-version": {"1"}}, nil, nil); err != nil {
+		return fmt.Errorf("could not create directory: %w", err)
+	}
+	// replace a link from a previous run
+	i.do(ctx, "files/rm", url.Values{"arg": {dest}, "force": {"true"}}, nil, nil)
+	if err := i.do(ctx, "files/cp", url.Values{"arg": {"/ipfs/" + cid, dest}}, nil, nil); err != nil {
+		return fmt.Errorf("could not link file: %w", err)
+	}
+	return nil
+}
+
+// stat returns the CID of the MFS path rel, relative to i.Dir
+func (i *IPFS) stat(ctx context.Context, rel string) (string, error) {
+	var stat struct {
+		Hash string `json:"Hash"`
+	}
+	if err := i.do(ctx, "files/stat", url.Values{"arg": {path.Join(i.Dir, rel)}}, nil, &stat); err != nil {
+		return "", err
+	}
+	return stat.Hash, nil
+}
+
+// Add adds the file at local to the node and links it to rel, returning its CID
+func (i *IPFS) Add(ctx context.Context, local, rel string) (string, error) {
+	cid, err := i.AddFile(ctx, local)
+	if err != nil {
+		return "", fmt.Errorf("could not add file: %w", err)
+	}
+	if err = i.link(ctx, cid, rel); err != nil {
+		return "", err
+	}
+	return cid, nil
+}
+
+// Root returns the CID of the archive's MFS directory and pins it, so the directory structure outlives the MFS link
+func (i *IPFS) Root(ctx context.Context) (string, error) {
+	cid, err := i.stat(ctx, "")
+	if err != nil {
+		return "", fmt.Errorf("could not stat archive directory: %w", err)
+	}
+	if err = i.do(ctx, "pin/add", url.Values{"arg": {cid}}, nil, nil); err != nil {
+		return "", fmt.Errorf("could not pin archive directory: %w", err)
+	}
+	return cid, nil
+}
+
+// ExportCAR writes the DAG rooted at cid to a CAR file at path
+func (i *IPFS) ExportCAR(ctx context.Context, cid, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.API+"/api/v0/dag/export?"+url.Values{"arg": {cid}}.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not export CAR: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		iErr := &ipfsError{Status: resp.StatusCode}
+		if err = json.NewDecoder(resp.Body).Decode(iErr); err != nil {
+			iErr.Message = resp.Status
+		}
+		return fmt.Errorf("could not export CAR: %w", iErr)
+	}
+
+	f, err := createAtomic(path)
+	if err != nil {
+		return fmt.Errorf("could not create file: %w", err)
+	}
+	if _, err = io.Copy(f, resp.Body); err != nil {
+		f.abort()
+		return fmt.Errorf("could not write CAR: %w", err)
+	}
+	if err = f.commit(""); err != nil {
+		return fmt.Errorf("could not write CAR: %w", err)
+	}
+	return nil
+}
+
+// AddIPFS adds the manifest's captured files to i, recording each file's CID in the manifest, and returns the root CID
+// of the archive. Files keep their paths relative to the manifest's root. Sheets exported as a directory of CSV files
+// are recorded with the CID of their directory
+func (m *Manifest) AddIPFS(ctx context.Context, i *IPFS) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, e := range m.Files {
+		if !e.Captured() {
+			continue
+		}
+		local := m.localPath(e)
+		rel := strings.TrimPrefix(path.Clean(e.Path), "/")
+		if path.IsAbs(e.Path) {
+			rel = path.Join("routed", rel)
+		}
+
+		if err := filepath.Walk(local, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			r, err := filepath.Rel(local, p)
+			if err != nil {
+				return err
+			}
+			cid, err := i.Add(ctx, p, path.Join(rel, filepath.ToSlash(r)))
+			if err != nil {
+				return err
+			}
+			if p == local {
+				e.CID = cid
+			}
+			return nil
+		}); err != nil {
+			return "", fmt.Errorf("%s: %w", e.Path, err)
+		}
+
+		if e.CID == "" {
+			cid, err := i.stat(ctx, rel)
+			if err != nil {
+				return "", fmt.Errorf("%s: could not stat directory: %w", e.Path, err)
+			}
+			e.CID = cid
+		}
+	}
+
+	root, err := i.Root(ctx)
+	if err != nil {
+		return "", err
+	}
+	m.IPFSRoot = root
+	return root, nil
+}
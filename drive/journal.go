@@ -0,0 +1,144 @@
+package drive
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// JournalName is the name of the manifest journal written to an archive during a run
+const JournalName = "manifest.journal"
+
+// OpenJournal opens or creates the journal at path and appends each entry to it as a line of JSON when the entry's file is finished,
+// so a crashed or killed run leaves a record of the files captured before it stopped. If the journal of a run that didn't finish
+// is at path, its entries are recovered into the manifest and kept in the journal. Entries recovered for files the run adds again
+// are replaced. It returns the number of entries recovered. Call CloseJournal when the run is finished
+func (m *Manifest) OpenJournal(path string) (int, error) {
+	entries, err := ReadJournal(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.recovered == nil {
+		m.recovered = make(map[string]int)
+	}
+	for _, e := range entries {
+		// later entries for the same file are newer
+		if i, ok := m.recovered[e.key()]; ok {
+			m.Files[i] = e
+			continue
+		}
+		m.recovered[e.key()] = len(m.Files)
+		m.Files = append(m.Files, e)
+	}
+
+	if len(entries) > 0 {
+		// the journal is rewritten with the recovered entries, dropping a partial last line that later entries would be appended to
+		f, err := createAtomic(path)
+		if err != nil {
+			return 0, fmt.Errorf("could not create journal: %w", err)
+		}
+		defer f.abort()
+		enc := json.NewEncoder(f)
+		for i, e := range m.Files {
+			if j, ok := m.recovered[e.key()]; !ok || i != j {
+				continue
+			}
+			if err = enc.Encode(e); err != nil {
+				return 0, fmt.Errorf("could not encode journal entry: %w", err)
+			}
+		}
+		if err = f.commit(""); err != nil {
+			return 0, fmt.Errorf("could not write journal: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("could not open journal: %w", err)
+	}
+	m.journal = f
+	m.journalErr = nil
+	return len(m.recovered), nil
+}
+
+// record appends e to the journal, if open, and records it in the catalog, if set. e must not be changed afterwards. Only the first write error is kept,
+// since later entries would be missing too, and it's returned by CloseJournal
+func (m *Manifest) record(e *ManifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.catalog != nil {
+		m.catalog.Record(e)
+	}
+	if m.journal == nil || m.journalErr != nil {
+		return
+	}
+
+	buf, err := json.Marshal(e)
+	if err != nil {
+		m.journalErr = fmt.Errorf("could not encode journal entry: %w", err)
+		return
+	}
+	// entries are written with one call so a killed run leaves at most one partial line
+	if _, err = m.journal.Write(append(buf, '\n')); err != nil {
+		m.journalErr = fmt.Errorf("could not write journal entry: %w", err)
+	}
+}
+
+// CloseJournal closes the journal, if open, and returns the first error writing to it
+func (m *Manifest) CloseJournal() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.journal == nil {
+		return nil
+	}
+
+	err := m.journal.Close()
+	m.journal = nil
+	if m.journalErr != nil {
+		return m.journalErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not close journal: %w", err)
+	}
+	return nil
+}
+
+// ReadJournal reads the entries from the journal at path. A partial last line, left by a run killed while writing it, is ignored
+func ReadJournal(path string) ([]*ManifestEntry, error) {
+	entries, _, err := readJournal(path)
+	return entries, err
+}
+
+// readJournal is like ReadJournal, but also returns the length of the complete lines
+func readJournal(path string) (entries []*ManifestEntry, n int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not open journal: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// complete entries end with a newline
+			return entries, n, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not read journal: %w", err)
+		}
+
+		e := new(ManifestEntry)
+		if err = json.Unmarshal(line, e); err != nil {
+			return nil, 0, fmt.Errorf("could not decode journal entry %d: %w", len(entries)+1, err)
+		}
+		entries = append(entries, e)
+		n += int64(len(line))
+	}
+}
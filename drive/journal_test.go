@@ -0,0 +1,137 @@
+package drive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJournalRecoversKilledRun(t *testing.T) {
+	const killAfter = 10
+	svc, tree := newFakeRun(t, &BenchmarkOptions{Files: 100, Folders: 5, Size: 1024}, nil)
+	out := t.TempDir()
+	journal := filepath.Join(out, JournalName)
+
+	// the first run is killed after some files are finished, without closing its journal
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := NewManifest("killed", out, time.Now())
+	if _, err := m.OpenJournal(journal); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu       sync.Mutex
+		finished int
+	)
+	err := svc.DownloadTree(ctx, tree, out, &DownloadOptions{
+		Downloaders: 1,
+		Manifest:    m,
+		Stats:       new(Stats),
+		Progress: func(e *ProgressEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			if e.Type == EventFinished {
+				if finished++; finished == killAfter {
+					cancel()
+				}
+			}
+		},
+	})
+	if err == nil {
+		t.Fatal("expected the killed run to fail")
+	}
+
+	// a kill while writing leaves a partial last line
+	f, err := os.OpenFile(journal, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":"partial`)
+	f.Close()
+
+	journaled, err := ReadJournal(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	captured := 0
+	for _, e := range journaled {
+		if e.Captured() {
+			captured++
+		}
+	}
+	if captured < killAfter || captured >= 100 {
+		t.Fatalf("expected the killed run to journal between %d and 99 captured files, got %d", killAfter, captured)
+	}
+
+	// the next run recovers the journal, and only downloads the files the killed run didn't capture, so the captured files
+	// are only recorded by the journal
+	m = NewManifest("next", out, time.Now())
+	n, err := m.OpenJournal(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(journaled) {
+		t.Fatalf("expected %d recovered entries, got %d", len(journaled), n)
+	}
+	for _, e := range journaled {
+		if !hasEntry(m, e) {
+			t.Fatalf("journaled entry %s (%s) not recovered", e.Path, e.ID)
+		}
+	}
+
+	only := make(map[string]bool)
+	tree.Walk(func(path string, f *File) error {
+		only[f.ID] = true
+		return nil
+	})
+	for _, e := range journaled {
+		if e.Captured() {
+			delete(only, e.ID)
+		}
+	}
+	if err = svc.DownloadTree(context.Background(), tree, out, &DownloadOptions{Downloaders: 2, Manifest: m, Stats: new(Stats), Only: only}); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.CloseJournal(); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Write(filepath.Join(out, "manifest.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	written, err := ReadManifest(filepath.Join(out, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, e := range written.Files {
+		if seen[e.key()] {
+			t.Fatalf("duplicate entry for %s (%s)", e.Path, e.ID)
+		}
+		seen[e.key()] = true
+		if !e.Captured() {
+			t.Errorf("%s (%s) not captured: %s", e.Path, e.ID, e.Status)
+		}
+	}
+	if len(written.Files) != 100 {
+		t.Fatalf("expected 100 entries, got %d", len(written.Files))
+	}
+	for _, e := range journaled {
+		if e.Captured() && !seen[e.key()] {
+			t.Errorf("journaled entry %s (%s) not in the next manifest", e.Path, e.ID)
+		}
+	}
+}
+
+// hasEntry returns true if m has an entry equal to e
+func hasEntry(m *Manifest, e *ManifestEntry) bool {
+	for _, f := range m.Files {
+		if f.key() == e.key() && f.Status == e.Status {
+			return true
+		}
+	}
+	return false
+}
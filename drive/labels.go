@@ -0,0 +1,104 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// LabelQuery converts a label filter into a Drive search query. Filters are a label ID, which matches files with the label
+// applied, labelID.fieldID=value, which matches files whose label field has value (the choice ID for selection fields),
+// or a search query using the labels query syntax, e.g. labels/labelID.fieldID > 5
+func LabelQuery(filter string) (string, error) {
+	filter = strings.TrimSpace(filter)
+	if strings.HasPrefix(filter, "labels/") || strings.HasPrefix(filter, "'") {
+		return filter, nil
+	}
+
+	id, value := filter, ""
+	if i := strings.Index(filter, "="); i != -1 {
+		id, value = filter[:i], filter[i+1:]
+	}
+	parts := strings.Split(id, ".")
+	for _, p := range parts {
+		if p == "" || strings.ContainsAny(p, " \t") {
+			return "", fmt.Errorf("invalid label filter %s: must be labelID, labelID.fieldID=value, or a query", filter)
+		}
+	}
+
+	switch {
+	case len(parts) == 1 && value == "" && !strings.Contains(filter, "="):
+		return fmt.Sprintf("'labels/%s' in labels", id), nil
+	case len(parts) == 2 && value != "":
+		value = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+		return fmt.Sprintf("labels/%s = '%s'", id, value), nil
+	}
+	return "", fmt.Errorf("invalid label filter %s: must be labelID, labelID.fieldID=value, or a query", filter)
+}
+
+// SearchIDs returns the IDs of the files in the user's Drive and shared drives that match the search query q
+func (s *Service) SearchIDs(ctx context.Context, q string) (map[string]bool, error) {
+	files, err := s.list(ctx, s.FilesService.List().
+		Q(q).
+		Corpora("allDrives").
+		IncludeItemsFromAllDrives(true).
+		SupportsAllDrives(true).
+		Fields(googleapi.Field("nextPageToken"), googleapi.Field("files/id")).
+		PageSize(1000))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool, len(files))
+	for _, f := range files {
+		ids[f.Id] = true
+	}
+	return ids, nil
+}
+
+// LabelFilter resolves the label queries (see LabelQuery) in include and exclude into the IDs of matching files,
+// returning a copy of f with LabelInclude and LabelExclude set. If f is nil, a new Filter is returned
+func (s *Service) LabelFilter(ctx context.Context, f *Filter, include, exclude []string) (*Filter, error) {
+	c := new(Filter)
+	if f != nil {
+		*c = *f
+	}
+
+	search := func(queries []string) (map[string]bool, error) {
+		ids := make(map[string]bool)
+		for _, q := range queries {
+			matched, err := s.SearchIDs(ctx, q)
+			if err != nil {
+				return nil, fmt.Errorf("could not search for %s: %w", q, err)
+			}
+			for id := range matched {
+				ids[id] = true
+			}
+		}
+		return ids, nil
+	}
+
+	var err error
+	if len(include) > 0 {
+		if c.LabelInclude, err = search(include); err != nil {
+			return nil, err
+		}
+	}
+	if len(exclude) > 0 {
+		if c.LabelExclude, err = search(exclude); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// labels returns true if file is allowed by the Filter's label filters
+func (f *Filter) labels(file *drive.File) bool {
+	if f.LabelExclude[file.Id] {
+		return false
+	}
+	return f.LabelInclude == nil || f.LabelInclude[file.Id]
+}
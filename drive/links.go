@@ -0,0 +1,66 @@
+package drive
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VerifyUnsafeLink symlinks in the archive are absolute, point outside of the archive, or are broken. They break or escape
+// the tree when it's replicated with rsync -a or packaged with tar
+const VerifyUnsafeLink = "unsafe link"
+
+// CheckLinks walks root and returns the symlinks that aren't relative links to files inside of root.
+// Paths are relative to root. The archive doesn't create symlinks, so any found were added by other tools
+func CheckLinks(root string) []*VerifyFailure {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		realRoot = root
+	}
+
+	var failures []*VerifyFailure
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		rel := path
+		if r, err := filepath.Rel(root, path); err == nil {
+			rel = filepath.ToSlash(r)
+		}
+		fail := func(reason string) {
+			failures = append(failures, &VerifyFailure{Path: rel, Kind: VerifyUnsafeLink, Reason: reason})
+		}
+
+		target, err := os.Readlink(path)
+		if err != nil {
+			fail("could not read link: " + err.Error())
+			return nil
+		}
+		if filepath.IsAbs(target) {
+			fail("absolute target " + target)
+			return nil
+		}
+		if !within(root, filepath.Join(filepath.Dir(path), target)) {
+			fail("target " + target + " is outside of the archive")
+			return nil
+		}
+		// links to links are followed to their final target
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			fail("broken link to " + target)
+			return nil
+		}
+		if !within(realRoot, resolved) {
+			fail("target " + target + " resolves outside of the archive")
+		}
+		return nil
+	})
+
+	return failures
+}
+
+// within returns true if path is root or inside of it
+func within(root, path string) bool {
+	r, err := filepath.Rel(root, path)
+	return err == nil && r != ".." && !strings.HasPrefix(r, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,161 @@
+package drive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of a log entry
+type LogLevel int
+
+// Log levels
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < LogDebug || l > LogError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+// MarshalText encodes the level as its name
+func (l LogLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// ParseLogLevel parses a level name: debug, info, warn, or error
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level %s: must be debug, info, warn, or error", s)
+}
+
+// LogEntry is a structured log entry. File fields are empty for entries that aren't about a file
+type LogEntry struct {
+	Time    time.Time
+	Level   LogLevel
+	RunID   string
+	Message string
+	FileID  string
+	// Path is the path of the file relative to its output directory, pseudonymized if the Service has a PseudonymKey
+	Path string
+	// Size is the size of the downloaded file, or the size reported by Drive if the file wasn't downloaded
+	Size int64
+	// Duration is how long downloading the file took
+	Duration time.Duration
+	Error    string
+}
+
+// Logger logs entries. Log is called concurrently by downloaders
+type Logger interface {
+	Log(e *LogEntry)
+}
+
+// WriterLogger writes entries at or above Level to W, as lines of text or JSON objects.
+// Text lines keep the format of the messages, e.g. [run] path: could not download file: error
+type WriterLogger struct {
+	W     io.Writer
+	Level LogLevel
+	JSON  bool
+
+	mu sync.Mutex
+}
+
+// NewWriterLogger returns a new WriterLogger
+func NewWriterLogger(w io.Writer, level LogLevel, json bool) *WriterLogger {
+	return &WriterLogger{W: w, Level: level, JSON: json}
+}
+
+// defaultLogger is used by Services without a Logger
+var defaultLogger = NewWriterLogger(os.Stdout, LogInfo, false)
+
+// jsonEntry is the JSON encoding of a LogEntry
+type jsonEntry struct {
+	Time       time.Time `json:"time"`
+	Level      LogLevel  `json:"level"`
+	RunID      string    `json:"run_id,omitempty"`
+	Message    string    `json:"msg"`
+	FileID     string    `json:"file_id,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	DurationMS int64     `json:"duration_ms,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Log writes e if its level is at least l.Level
+func (l *WriterLogger) Log(e *LogEntry) {
+	if e.Level < l.Level {
+		return
+	}
+
+	var line []byte
+	if l.JSON {
+		buf, err := json.Marshal(&jsonEntry{
+			Time: e.Time, Level: e.Level, RunID: e.RunID, Message: e.Message, FileID: e.FileID, Path: e.Path,
+			Size: e.Size, DurationMS: e.Duration.Milliseconds(), Error: e.Error,
+		})
+		if err != nil {
+			return
+		}
+		line = append(buf, '\n')
+	} else {
+		b := new(strings.Builder)
+		if e.RunID != "" {
+			b.WriteString("[" + e.RunID + "] ")
+		}
+		if e.Path != "" {
+			b.WriteString(e.Path + ": ")
+		}
+		b.WriteString(e.Message)
+		if e.Error != "" {
+			b.WriteString(": " + e.Error)
+		}
+		b.WriteString("\n")
+		line = []byte(b.String())
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.W.Write(line)
+}
+
+// log redacts e and passes it to the Service's Logger
+func (s *Service) log(e *LogEntry) {
+	e.Time = time.Now()
+	e.RunID = s.RunID
+	e.Message = Redact(e.Message)
+	e.Error = Redact(e.Error)
+	l := s.Logger
+	if l == nil {
+		l = defaultLogger
+	}
+	l.Log(e)
+}
+
+// logFile logs msg about the download d at level. If err is not nil, it's logged as the entry's error
+func (s *Service) logFile(level LogLevel, d *download, msg string, err error) {
+	e := &LogEntry{Level: level, Message: msg, Path: s.logPath(d.Path)}
+	if d.File != nil && d.File.File != nil {
+		e.FileID = d.File.File.Id
+		e.Size = d.File.File.Size
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.log(e)
+}
@@ -0,0 +1,332 @@
+package drive
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Manifest entry statuses
+const (
+	StatusDownloaded = "downloaded"
+	StatusExisting   = "existing"
+	// StatusRestricted files weren't captured because their owner disabled downloading
+	StatusRestricted = "restricted"
+	// StatusUnsupported files weren't captured because they can't be downloaded or exported, e.g. Google Maps
+	StatusUnsupported = "unsupported"
+	// StatusFailed files weren't captured because downloading failed
+	StatusFailed = "failed"
+	// StatusSkipped files weren't captured because they were larger than the maximum size
+	StatusSkipped = "skipped"
+)
+
+// ManifestEntry records an archived file
+type ManifestEntry struct {
+	ID       string `json:"id"`
+	Path     string `json:"path"`
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	// ExportType is the mime type a Google file was exported as
+	ExportType   string `json:"export_type,omitempty"`
+	MD5Checksum  string `json:"md5_checksum,omitempty"`
+	ModifiedTime string `json:"modified_time,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	// ViewedByMeTime and SharedWithMeTime are when the archived user last viewed the file and when it was shared with them
+	ViewedByMeTime   string `json:"viewed_by_me_time,omitempty"`
+	SharedWithMeTime string `json:"shared_with_me_time,omitempty"`
+	// LastModifyingUser is the email of the user who last modified the file
+	LastModifyingUser string `json:"last_modifying_user,omitempty"`
+	// HeadRevisionID is the revision of the file when it was listed. Google files have no revisions
+	HeadRevisionID string `json:"head_revision_id,omitempty"`
+	// WebViewLink is the URL to open the file in Drive
+	WebViewLink string `json:"web_view_link,omitempty"`
+	// SHA256 is the hash of the local file. It's only set if the archive was hashed after downloading, or the file was checked with
+	// Drive's SHA-256 checksum
+	SHA256 string `json:"sha256,omitempty"`
+	// PDFA is "converted" or the reason PDF/A conversion failed, if conversion was attempted
+	PDFA string `json:"pdfa,omitempty"`
+	// OCR is "extracted" or the reason OCR failed, if OCR text was extracted to a sidecar file named with OCRSuffix
+	OCR    string `json:"ocr,omitempty"`
+	Status string `json:"status"`
+	// Error is the reason a failed file couldn't be downloaded
+	Error string `json:"error,omitempty"`
+	// Restriction describes sharing restrictions set by the file's owner that prevent capturing the file verbatim
+	Restriction string `json:"restriction,omitempty"`
+	// Extra maps the top level names of the manifest's ExtraFields to their values
+	Extra map[string]json.RawMessage `json:"extra,omitempty"`
+	// CID is the IPFS content identifier of the local file. It's only set if the archive was added to IPFS
+	CID string `json:"cid,omitempty"`
+}
+
+// Captured returns true if the entry's file exists in the archive
+func (e *ManifestEntry) Captured() bool {
+	return e.Status == StatusDownloaded || e.Status == StatusExisting
+}
+
+// restriction returns a description of the sharing restrictions on f, or an empty string if there are none
+func restriction(f *drive.File) string {
+	var r []string
+	if f.Capabilities != nil && !f.Capabilities.CanDownload {
+		r = append(r, "download disabled")
+	}
+	if f.CopyRequiresWriterPermission {
+		r = append(r, "copy, print, and download disabled for commenters and viewers")
+	}
+	return strings.Join(r, "; ")
+}
+
+// extraFields returns the values of fields in f, keyed by their top level names, e.g. imageMediaMetadata for imageMediaMetadata/width.
+// Fields without values are omitted
+func extraFields(f *drive.File, fields []string) map[string]json.RawMessage {
+	if len(fields) == 0 {
+		return nil
+	}
+	buf, err := json.Marshal(f)
+	if err != nil {
+		return nil
+	}
+	all := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(buf, &all); err != nil {
+		return nil
+	}
+
+	extra := make(map[string]json.RawMessage)
+	for _, field := range fields {
+		name := field
+		if i := strings.IndexAny(name, "/("); i >= 0 {
+			name = name[:i]
+		}
+		if v, ok := all[name]; ok {
+			extra[name] = v
+		}
+	}
+	if len(extra) == 0 {
+		return nil
+	}
+	return extra
+}
+
+// RunConfig records the effective configuration of a run so its selection can be reproduced
+type RunConfig struct {
+	// Version is the version of the tool that created the archive
+	Version string `json:"version,omitempty"`
+	// Flags are the values of all command line flags, including defaults
+	Flags map[string]string `json:"flags,omitempty"`
+	// ExportTypes maps Google file mime types to the mime types they were exported as
+	ExportTypes map[string]string `json:"export_types,omitempty"`
+	Started     time.Time         `json:"started"`
+	Finished    time.Time         `json:"finished"`
+}
+
+// Manifest records the files archived by a run
+type Manifest struct {
+	RunID string `json:"run_id,omitempty"`
+	// Captured is the time the run started. Changes made to files after Captured may not be reflected in the archive
+	Captured time.Time        `json:"captured"`
+	Config   *RunConfig       `json:"config,omitempty"`
+	Files    []*ManifestEntry `json:"files"`
+	// MerkleTree is set by calling Merkle
+	MerkleTree *MerkleTree `json:"merkle,omitempty"`
+	// ExtraFields, if set, are the Drive file fields recorded in each entry's Extra. They must be requested with Service.ExtraFields
+	ExtraFields []string `json:"extra_fields,omitempty"`
+	// IPFSRoot is the CID of the archive's directory. It's set by calling AddIPFS
+	IPFSRoot string `json:"ipfs_root,omitempty"`
+	// EmptyFolders are the slash separated paths of folders with no files, relative to the manifest's root.
+	// They're set by calling RecordEmptyFolders, so empty folders can be recreated from object storage
+	EmptyFolders []string `json:"empty_folders,omitempty"`
+
+	// root is the path entry paths are made relative to
+	root string
+	mu   sync.Mutex
+	// journal, if set, is the file finished entries are appended to. See OpenJournal
+	journal    *os.File
+	journalErr error
+	// catalog, if set, is the catalog finished entries are recorded in. See SetCatalog
+	catalog *Catalog
+	// recovered are the indexes in Files of the entries recovered from the journal of an earlier run, by key. See OpenJournal
+	recovered map[string]int
+}
+
+// NewManifest returns a new Manifest with paths relative to root
+func NewManifest(runID, root string, captured time.Time) *Manifest {
+	return &Manifest{RunID: runID, Captured: captured, Files: make([]*ManifestEntry, 0), root: root}
+}
+
+// ReadManifest reads the manifest at path
+func ReadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open manifest: %w", err)
+	}
+	defer f.Close()
+
+	m := new(Manifest)
+	if err = json.NewDecoder(f).Decode(m); err != nil {
+		return nil, fmt.Errorf("could not decode manifest: %w", err)
+	}
+	m.root = filepath.Dir(path)
+
+	return m, nil
+}
+
+// key identifies the file and path of the entry
+func (e *ManifestEntry) key() string {
+	return e.ID + "/" + e.Path
+}
+
+// add records f as archived at path with status and returns the new entry. An entry recovered from the journal of an earlier
+// run for the same file and path is replaced
+func (m *Manifest) add(f *drive.File, path, status string) *ManifestEntry {
+	if rel, err := filepath.Rel(m.root, path); err == nil && !strings.HasPrefix(rel, "..") {
+		path = rel
+	}
+	e := &ManifestEntry{
+		ID:               f.Id,
+		Path:             filepath.ToSlash(path),
+		Name:             f.Name,
+		MimeType:         f.MimeType,
+		MD5Checksum:      f.Md5Checksum,
+		ModifiedTime:     f.ModifiedTime,
+		Size:             f.Size,
+		WebViewLink:      f.WebViewLink,
+		HeadRevisionID:   f.HeadRevisionId,
+		ViewedByMeTime:   f.ViewedByMeTime,
+		SharedWithMeTime: f.SharedWithMeTime,
+		Status:           status,
+		Restriction:      restriction(f),
+		Extra:            extraFields(f, m.ExtraFields),
+	}
+	if f.LastModifyingUser != nil {
+		e.LastModifyingUser = f.LastModifyingUser.EmailAddress
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i, ok := m.recovered[e.key()]; ok {
+		m.Files[i] = e
+		delete(m.recovered, e.key())
+		return e
+	}
+	m.Files = append(m.Files, e)
+	return e
+}
+
+// PartialManifestName is the name of the manifest written by a run that stopped before it finished, so the manifest of the
+// last complete run isn't replaced
+const PartialManifestName = "manifest.partial.json"
+
+// Write writes the manifest as JSON to path, replacing it atomically
+func (m *Manifest) Write(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := createAtomic(path)
+	if err != nil {
+		return fmt.Errorf("could not create manifest: %w", err)
+	}
+	defer f.abort()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	if err = enc.Encode(m); err != nil {
+		return fmt.Errorf("could not encode manifest: %w", err)
+	}
+
+	if err = f.commit(""); err != nil {
+		return fmt.Errorf("could not write manifest: %w", err)
+	}
+	return nil
+}
+
+// WriteNDJSON writes the manifest's entries to path as newline delimited JSON, one entry per line
+func (m *Manifest) WriteNDJSON(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not create manifest: %w", err)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, e := range m.Files {
+		if err = enc.Encode(e); err != nil {
+			return fmt.Errorf("could not encode manifest entry: %w", err)
+		}
+	}
+
+	return f.Close()
+}
+
+// WriteErrors writes the failed and restricted entries to path as CSV, with their ID, path, status, and error,
+// and returns the number of entries written. If there are none, an existing file at path is removed
+func (m *Manifest) WriteErrors(path string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var failed []*ManifestEntry
+	for _, e := range m.Files {
+		if e.Status == StatusFailed || e.Status == StatusRestricted {
+			failed = append(failed, e)
+		}
+	}
+	if len(failed) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("could not remove previous error report: %w", err)
+		}
+		return 0, nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, fmt.Errorf("could not create error report: %w", err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write([]string{"id", "path", "status", "error"})
+	for _, e := range failed {
+		reason := e.Error
+		if reason == "" && e.Status == StatusRestricted {
+			reason = "downloading is disabled by the owner"
+		}
+		w.Write([]string{e.ID, e.Path, e.Status, reason})
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return 0, fmt.Errorf("could not write error report: %w", err)
+	}
+
+	return len(failed), f.Close()
+}
+
+// Subset returns a new Manifest with the entries under dir (relative to the manifest's root), with paths relative to dir
+func (m *Manifest) Subset(dir string) *Manifest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix := filepath.ToSlash(dir) + "/"
+	sub := NewManifest(m.RunID, filepath.Join(m.root, dir), m.Captured)
+	sub.Config = m.Config
+	sub.ExtraFields = m.ExtraFields
+	for _, e := range m.Files {
+		if strings.HasPrefix(e.Path, prefix) {
+			c := *e
+			c.Path = strings.TrimPrefix(e.Path, prefix)
+			sub.Files = append(sub.Files, &c)
+		}
+	}
+	for _, dir := range m.EmptyFolders {
+		if strings.HasPrefix(dir, prefix) {
+			sub.EmptyFolders = append(sub.EmptyFolders, strings.TrimPrefix(dir, prefix))
+		}
+	}
+	return sub
+}
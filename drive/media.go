@@ -0,0 +1,101 @@
+package drive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// MediaSuffix is added to the path of an archived photo or video to get the path of its media metadata sidecar
+const MediaSuffix = ".media.json"
+
+// MediaFields are the Drive file fields that must be added to Service.ExtraFields to write media sidecars
+var MediaFields = []string{
+	"imageMediaMetadata(time,width,height,rotation,cameraMake,cameraModel,location)",
+	"videoMediaMetadata(width,height,durationMillis)",
+}
+
+// exifTime is the format of the capture time in image metadata
+const exifTime = "2006:01:02 15:04:05"
+
+// MediaLocation is where a photo was taken
+type MediaLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
+}
+
+// MediaMetadata is the photo or video metadata extracted by Drive, written to a sidecar next to the archived file
+type MediaMetadata struct {
+	FileID string `json:"file_id"`
+	// CaptureTime is the time the photo was taken, in the camera's local time without a time zone, e.g. 2006-01-02T15:04:05
+	CaptureTime    string         `json:"capture_time,omitempty"`
+	Width          int64          `json:"width,omitempty"`
+	Height         int64          `json:"height,omitempty"`
+	Rotation       int64          `json:"rotation,omitempty"`
+	DurationMillis int64          `json:"duration_millis,omitempty"`
+	CameraMake     string         `json:"camera_make,omitempty"`
+	CameraModel    string         `json:"camera_model,omitempty"`
+	Location       *MediaLocation `json:"location,omitempty"`
+}
+
+// mediaMetadata returns the media metadata of f, or nil if f has none
+func mediaMetadata(f *drive.File) *MediaMetadata {
+	switch {
+	case f.ImageMediaMetadata != nil:
+		im := f.ImageMediaMetadata
+		m := &MediaMetadata{
+			FileID:      f.Id,
+			Width:       im.Width,
+			Height:      im.Height,
+			Rotation:    im.Rotation,
+			CameraMake:  im.CameraMake,
+			CameraModel: im.CameraModel,
+		}
+		if t, err := time.Parse(exifTime, im.Time); err == nil {
+			m.CaptureTime = t.Format("2006-01-02T15:04:05")
+		}
+		if im.Location != nil {
+			m.Location = &MediaLocation{Latitude: im.Location.Latitude, Longitude: im.Location.Longitude, Altitude: im.Location.Altitude}
+		}
+		return m
+	case f.VideoMediaMetadata != nil:
+		vm := f.VideoMediaMetadata
+		return &MediaMetadata{FileID: f.Id, Width: vm.Width, Height: vm.Height, DurationMillis: vm.DurationMillis}
+	}
+	return nil
+}
+
+// writeMediaSidecar writes the media metadata of f, archived at path, to a sidecar. If captureMtime is true and f is a photo with a
+// capture time, the mtimes of the file and sidecar are set to the capture time (in the local time zone) instead of f's modified time.
+// If f has no media metadata, no sidecar is written
+func writeMediaSidecar(f *drive.File, path string, captureMtime bool) error {
+	m := mediaMetadata(f)
+	if m == nil {
+		return nil
+	}
+
+	buf, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return fmt.Errorf("could not encode media metadata: %w", err)
+	}
+
+	timestamp := f.ModifiedTime
+	if captureMtime && f.ImageMediaMetadata != nil {
+		if t, err := time.ParseInLocation(exifTime, f.ImageMediaMetadata.Time, time.Local); err == nil {
+			timestamp = t.Format(time.RFC3339)
+			if err = os.Chtimes(path, t, t); err != nil {
+				return fmt.Errorf("could not change mtime: %w", err)
+			}
+		}
+	}
+
+	if err = writeBody(bytes.NewReader(append(buf, '\n')), path+MediaSuffix, timestamp); err != nil {
+		return fmt.Errorf("could not write media sidecar: %w", err)
+	}
+	return nil
+}
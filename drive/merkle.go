@@ -0,0 +1,107 @@
+package drive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+)
+
+// MerkleTree is a hash tree of the files in a Manifest. Each directory's digest is the SHA-256 hash of its sorted
+// children's names, types, and digests, and each file's digest is the SHA-256 hash of its contents
+type MerkleTree struct {
+	Root        string            `json:"root"`
+	Directories map[string]string `json:"directories"`
+}
+
+type merkleChild struct {
+	name  string
+	dir   bool
+	check string
+}
+
+// sha256File returns the hex encoded SHA-256 hash of the file at path
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Merkle computes a MerkleTree of the manifest's files, reading their contents from disk, and stores it in the manifest
+func (m *Manifest) Merkle() (*MerkleTree, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sums, err := m.fileSums()
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[string][]*merkleChild)
+	for _, s := range sums {
+		dir, name := path.Split(path.Clean(s[1]))
+		dir = path.Clean(dir)
+		children[dir] = append(children[dir], &merkleChild{name: name, check: s[0]})
+
+		// make sure each parent directory is a child of its parent
+		for dir != "." && dir != "/" {
+			parent, name := path.Split(dir)
+			parent = path.Clean(parent)
+			found := false
+			for _, c := range children[parent] {
+				if c.dir && c.name == name {
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+			children[parent] = append(children[parent], &merkleChild{name: name, dir: true})
+			dir = parent
+		}
+	}
+
+	tree := &MerkleTree{Directories: make(map[string]string)}
+
+	var digest func(dir string) string
+	digest = func(dir string) string {
+		cs := children[dir]
+		sort.Slice(cs, func(i, j int) bool { return cs[i].name < cs[j].name })
+		h := sha256.New()
+		for _, c := range cs {
+			typ := "f"
+			if c.dir {
+				typ = "d"
+				c.check = digest(path.Join(dir, c.name))
+			}
+			fmt.Fprintf(h, "%s %s %s\n", typ, c.check, c.name)
+		}
+		sum := hex.EncodeToString(h.Sum(nil))
+		tree.Directories[dir] = sum
+		return sum
+	}
+
+	tree.Root = digest(".")
+	// absolute paths (e.g. routed files outside of the manifest root) are hashed as their own tree
+	if _, ok := children["/"]; ok {
+		h := sha256.New()
+		fmt.Fprintf(h, "%s\n%s\n", tree.Root, digest("/"))
+		tree.Root = hex.EncodeToString(h.Sum(nil))
+	}
+
+	m.MerkleTree = tree
+	return tree, nil
+}
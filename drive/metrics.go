@@ -0,0 +1,226 @@
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// retries counts the retries of every Service, for Metrics
+var retries int64
+
+// errorReason returns a short label for the reason a download failed, for Metrics
+func errorReason(err error) string {
+	var gErr *googleapi.Error
+	switch {
+	case err == nil:
+		return "unknown"
+	case errors.Is(err, ErrRestricted):
+		return "restricted"
+	case errors.Is(err, ErrNoExportableFormat):
+		return "unsupported"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	case isSizeLimit(err):
+		return "export_size_limit"
+	case errors.As(err, &gErr):
+		switch {
+		case gErr.Code == 404:
+			return "not_found"
+		case gErr.Code == 429 || (gErr.Code == 403 && checkRetry(err)):
+			return "rate_limit"
+		case isDailyLimit(err):
+			return "daily_limit"
+		case gErr.Code == 403:
+			return "forbidden"
+		case gErr.Code >= 500:
+			return "server_error"
+		}
+		return "api_error"
+	}
+	return "other"
+}
+
+// workerMetrics are the results of one downloader
+type workerMetrics struct {
+	files   int64
+	bytes   int64
+	seconds float64
+}
+
+// Metrics collects download progress and serves it in the Prometheus text format. Use Progress as a DownloadOptions.Progress
+// callback. Workers are numbered from 1 in each tree, so concurrent runs share worker labels. A Metrics is safe for concurrent use
+type Metrics struct {
+	runID   string
+	mu      sync.Mutex
+	files   map[string]int64
+	errors  map[string]int64
+	bytes   int64
+	queued  int64
+	active  int64
+	started map[string]time.Time
+	workers map[int]*workerMetrics
+}
+
+// NewMetrics returns a new Metrics for the run with runID
+func NewMetrics(runID string) *Metrics {
+	return &Metrics{
+		runID:   runID,
+		files:   make(map[string]int64),
+		errors:  make(map[string]int64),
+		started: make(map[string]time.Time),
+		workers: make(map[int]*workerMetrics),
+	}
+}
+
+// Progress is a DownloadOptions.Progress callback that updates the metrics
+func (m *Metrics) Progress(e *ProgressEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := e.FileID + "/" + e.Path
+	// finish returns the time the file's downloader spent on it
+	finish := func() float64 {
+		m.active--
+		start, ok := m.started[key]
+		delete(m.started, key)
+		if !ok {
+			return 0
+		}
+		return e.Time.Sub(start).Seconds()
+	}
+	worker := func() *workerMetrics {
+		w, ok := m.workers[e.Worker]
+		if !ok {
+			w = new(workerMetrics)
+			m.workers[e.Worker] = w
+		}
+		return w
+	}
+
+	switch e.Type {
+	case EventQueued:
+		// files are requeued after their downloader panics
+		if _, ok := m.started[key]; ok {
+			finish()
+		}
+		m.queued++
+	case EventStarted:
+		m.queued--
+		m.active++
+		m.started[key] = e.Time
+	case EventFinished:
+		w := worker()
+		w.seconds += finish()
+		w.files++
+		if e.Downloaded {
+			m.files["downloaded"]++
+			m.bytes += e.Bytes
+			w.bytes += e.Bytes
+		} else {
+			m.files["existing"]++
+		}
+	case EventFailed:
+		worker().seconds += finish()
+		m.files["failed"]++
+		m.errors[errorReason(e.Err)]++
+	case EventSkipped:
+		m.queued--
+		m.files["skipped"]++
+	case EventDropped:
+		m.queued--
+		m.files["dropped"]++
+	}
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cw := &countWriter{w: w}
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(cw, "# HELP drive_archive_%s %s\n# TYPE drive_archive_%s %s\n", name, help, name, typ)
+	}
+
+	metric("run_info", "gauge", "The run being archived, with a constant value of 1.")
+	fmt.Fprintf(cw, "drive_archive_run_info{run_id=%q} 1\n", m.runID)
+	metric("files_total", "counter", "Files finished by result: downloaded, existing, failed, skipped, or dropped.")
+	for _, result := range sortedKeys(m.files) {
+		fmt.Fprintf(cw, "drive_archive_files_total{result=%q} %d\n", result, m.files[result])
+	}
+	metric("downloaded_bytes_total", "counter", "Bytes downloaded, including exported Google files.")
+	fmt.Fprintf(cw, "drive_archive_downloaded_bytes_total %d\n", m.bytes)
+	metric("errors_total", "counter", "Failed files by reason.")
+	for _, reason := range sortedKeys(m.errors) {
+		fmt.Fprintf(cw, "drive_archive_errors_total{reason=%q} %d\n", reason, m.errors[reason])
+	}
+	metric("retries_total", "counter", "Google API and upload requests retried after temporary errors.")
+	fmt.Fprintf(cw, "drive_archive_retries_total %d\n", atomic.LoadInt64(&retries))
+	metric("queue_depth", "gauge", "Files queued and waiting for a downloader.")
+	fmt.Fprintf(cw, "drive_archive_queue_depth %d\n", m.queued)
+	metric("active_downloads", "gauge", "Files being downloaded.")
+	fmt.Fprintf(cw, "drive_archive_active_downloads %d\n", m.active)
+
+	workers := make([]int, 0, len(m.workers))
+	for n := range m.workers {
+		workers = append(workers, n)
+	}
+	sort.Ints(workers)
+	metric("worker_files_total", "counter", "Files finished by each downloader.")
+	for _, n := range workers {
+		fmt.Fprintf(cw, "drive_archive_worker_files_total{worker=\"%d\"} %d\n", n, m.workers[n].files)
+	}
+	metric("worker_bytes_total", "counter", "Bytes downloaded by each downloader.")
+	for _, n := range workers {
+		fmt.Fprintf(cw, "drive_archive_worker_bytes_total{worker=\"%d\"} %d\n", n, m.workers[n].bytes)
+	}
+	metric("worker_busy_seconds_total", "counter", "Time each downloader spent downloading or checking files.")
+	for _, n := range workers {
+		fmt.Fprintf(cw, "drive_archive_worker_busy_seconds_total{worker=\"%d\"} %s\n", n, strconv.FormatFloat(m.workers[n].seconds, 'f', -1, 64))
+	}
+
+	return cw.n, cw.err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// countWriter counts the bytes written to w and keeps the first error
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
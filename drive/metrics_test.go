@@ -0,0 +1,46 @@
+package drive
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics("run-1")
+	now := time.Now()
+	for _, e := range []*ProgressEvent{
+		{Type: EventQueued, FileID: "a", Path: "a.bin"},
+		{Type: EventQueued, FileID: "b", Path: "b.bin"},
+		{Type: EventQueued, FileID: "c", Path: "c.bin"},
+		{Type: EventStarted, FileID: "a", Path: "a.bin", Worker: 1, Time: now},
+		{Type: EventFinished, FileID: "a", Path: "a.bin", Worker: 1, Bytes: 100, Downloaded: true, Time: now.Add(2 * time.Second)},
+		{Type: EventStarted, FileID: "b", Path: "b.bin", Worker: 2, Time: now},
+		{Type: EventFailed, FileID: "b", Path: "b.bin", Worker: 2, Err: ErrRestricted, Time: now.Add(time.Second)},
+		{Type: EventStarted, FileID: "c", Path: "c.bin", Worker: 1, Time: now},
+	} {
+		m.Progress(e)
+	}
+
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`drive_archive_run_info{run_id="run-1"} 1`,
+		`drive_archive_files_total{result="downloaded"} 1`,
+		`drive_archive_files_total{result="failed"} 1`,
+		`drive_archive_downloaded_bytes_total 100`,
+		`drive_archive_errors_total{reason="restricted"} 1`,
+		`drive_archive_queue_depth 0`,
+		`drive_archive_active_downloads 1`,
+		`drive_archive_worker_bytes_total{worker="1"} 100`,
+		`drive_archive_worker_busy_seconds_total{worker="1"} 2`,
+		`drive_archive_worker_busy_seconds_total{worker="2"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected %q in metrics:\n%s", line, buf.String())
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package drive
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+const (
+	metsNamespace   = "http://www.loc.gov/METS/"
+	premisNamespace = "http://www.loc.gov/premis/v3"
+	xlinkNamespace  = "http://www.w3.org/1999/xlink"
+)
+
+type metsAgent struct {
+	Role string `xml:"ROLE,attr"`
+	Type string `xml:"TYPE,attr"`
+	Name string `xml:"mets:name"`
+}
+
+type metsHeader struct {
+	CreateDate string       `xml:"CREATEDATE,attr"`
+	Agents     []*metsAgent `xml:"mets:agent"`
+}
+
+type premisIdentifier struct {
+	Type  string `xml:"premis:objectIdentifierType"`
+	Value string `xml:"premis:objectIdentifierValue"`
+}
+
+type premisFixity struct {
+	Algorithm  string `xml:"premis:messageDigestAlgorithm"`
+	Digest     string `xml:"premis:messageDigest"`
+	Originator string `xml:"premis:messageDigestOriginator"`
+}
+
+type premisFormat struct {
+	Name string `xml:"premis:formatDesignation>premis:formatName"`
+}
+
+type premisObject struct {
+	Type         string              `xml:"xsi:type,attr"`
+	XMLNSXSI     string              `xml:"xmlns:xsi,attr"`
+	Identifiers  []*premisIdentifier `xml:"premis:objectIdentifier"`
+	Fixity       []*premisFixity     `xml:"premis:objectCharacteristics>premis:fixity"`
+	Size         string              `xml:"premis:objectCharacteristics>premis:size,omitempty"`
+	Format       premisFormat        `xml:"premis:objectCharacteristics>premis:format"`
+	OriginalName string              `xml:"premis:originalName"`
+}
+
+type premisEvent struct {
+	IdentifierType  string `xml:"premis:eventIdentifier>premis:eventIdentifierType"`
+	IdentifierValue string `xml:"premis:eventIdentifier>premis:eventIdentifierValue"`
+	Type            string `xml:"premis:eventType"`
+	DateTime        string `xml:"premis:eventDateTime"`
+	Detail          string `xml:"premis:eventDetailInformation>premis:eventDetail"`
+	Outcome         string `xml:"premis:eventOutcomeInformation>premis:eventOutcome"`
+	Agent           string `xml:"premis:linkingAgentIdentifier>premis:linkingAgentIdentifierValue"`
+}
+
+type metsMDWrap struct {
+	MDType string         `xml:"MDTYPE,attr"`
+	Object *premisObject  `xml:"mets:xmlData>premis:object,omitempty"`
+	Events []*premisEvent `xml:"mets:xmlData>premis:event,omitempty"`
+}
+
+type metsMD struct {
+	ID   string      `xml:"ID,attr"`
+	Wrap *metsMDWrap `xml:"mets:mdWrap"`
+}
+
+type metsAmdSec struct {
+	ID         string  `xml:"ID,attr"`
+	TechMD     *metsMD `xml:"mets:techMD"`
+	DigiprovMD *metsMD `xml:"mets:digiprovMD"`
+}
+
+type metsLocation struct {
+	LocType string `xml:"LOCTYPE,attr"`
+	Href    string `xml:"xlink:href,attr"`
+}
+
+type metsFile struct {
+	ID       string        `xml:"ID,attr"`
+	MimeType string        `xml:"MIMETYPE,attr,omitempty"`
+	Size     string        `xml:"SIZE,attr,omitempty"`
+	Checksum string        `xml:"CHECKSUM,attr,omitempty"`
+	Type     string        `xml:"CHECKSUMTYPE,attr,omitempty"`
+	AdmID    string        `xml:"ADMID,attr"`
+	Location *metsLocation `xml:"mets:FLocat"`
+}
+
+type metsPointer struct {
+	FileID string `xml:"FILEID,attr"`
+}
+
+type metsDiv struct {
+	Label    string         `xml:"LABEL,attr"`
+	Type     string         `xml:"TYPE,attr,omitempty"`
+	Pointers []*metsPointer `xml:"mets:fptr,omitempty"`
+	Divs     []*metsDiv     `xml:"mets:div,omitempty"`
+}
+
+type metsDocument struct {
+	XMLName     xml.Name      `xml:"mets:mets"`
+	XMLNSMETS   string        `xml:"xmlns:mets,attr"`
+	XMLNSPREMIS string        `xml:"xmlns:premis,attr"`
+	XMLNSXLink  string        `xml:"xmlns:xlink,attr"`
+	ObjID       string        `xml:"OBJID,attr,omitempty"`
+	Header      *metsHeader   `xml:"mets:metsHdr"`
+	AmdSecs     []*metsAmdSec `xml:"mets:amdSec"`
+	Files       []*metsFile   `xml:"mets:fileSec>mets:fileGrp>mets:file"`
+	StructMap   *metsDiv      `xml:"mets:structMap>mets:div"`
+}
+
+// splitSlash splits the slash separated path p into its cleaned parent directory and name
+func splitSlash(p string) (dir, name string) {
+	dir, name = path.Split(path.Clean(p))
+	return path.Clean(dir), name
+}
+
+// metsEvents returns the PREMIS events of e
+func (m *Manifest) metsEvents(e *ManifestEntry, n int, agent string) []*premisEvent {
+	captured := m.Captured.UTC().Format(time.RFC3339)
+	event := func(typ, detail, outcome string) *premisEvent {
+		return &premisEvent{
+			IdentifierType:  "local",
+			IdentifierValue: fmt.Sprintf("EVENT_%d_%s", n, typ),
+			Type:            typ,
+			DateTime:        captured,
+			Detail:          detail,
+			Outcome:         outcome,
+			Agent:           agent,
+		}
+	}
+
+	events := []*premisEvent{event("capture",
+		fmt.Sprintf("captured from Google Drive file %s (modified %s)", e.ID, e.ModifiedTime), e.Status)}
+
+	exportTypes := ExportTypes
+	if m.Config != nil && m.Config.ExportTypes != nil {
+		exportTypes = m.Config.ExportTypes
+	}
+	if typ, ok := exportTypes[e.MimeType]; ok {
+		events = append(events, event("migration", fmt.Sprintf("exported from %s to %s", e.MimeType, typ), "success"))
+	}
+
+	if e.PDFA != "" {
+		outcome := "success"
+		if e.PDFA != "converted" {
+			outcome = "failure: " + e.PDFA
+		}
+		events = append(events, event("normalization", "converted to PDF/A", outcome))
+	}
+
+	return events
+}
+
+// WriteMETS writes a METS document with PREMIS metadata describing the manifest's archived files, their provenance,
+// fixity, and the conversions applied to them, to filename. File locations are relative to the manifest's root
+func (m *Manifest) WriteMETS(filename string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	agent := "drive-archive"
+	if m.Config != nil && m.Config.Version != "" {
+		agent += " " + m.Config.Version
+	}
+
+	doc := &metsDocument{
+		XMLNSMETS:   metsNamespace,
+		XMLNSPREMIS: premisNamespace,
+		XMLNSXLink:  xlinkNamespace,
+		ObjID:       m.RunID,
+		Header: &metsHeader{
+			CreateDate: time.Now().UTC().Format(time.RFC3339),
+			Agents:     []*metsAgent{{Role: "CREATOR", Type: "OTHER", Name: agent}},
+		},
+		StructMap: &metsDiv{Label: "archive", Type: "directory"},
+	}
+
+	dirs := map[string]*metsDiv{".": doc.StructMap}
+	var dir func(p string) *metsDiv
+	dir = func(p string) *metsDiv {
+		if d, ok := dirs[p]; ok {
+			return d
+		}
+		parent, name := splitSlash(p)
+		d := &metsDiv{Label: name, Type: "directory"}
+		dir(parent).Divs = append(dir(parent).Divs, d)
+		dirs[p] = d
+		return d
+	}
+
+	for i, e := range m.Files {
+		if !e.Captured() {
+			continue
+		}
+		n := i + 1
+		fileID, amdID := fmt.Sprintf("FILE_%d", n), fmt.Sprintf("AMD_%d", n)
+
+		obj := &premisObject{
+			Type:         "premis:file",
+			XMLNSXSI:     "http://www.w3.org/2001/XMLSchema-instance",
+			Identifiers:  []*premisIdentifier{{Type: "Google Drive ID", Value: e.ID}},
+			Format:       premisFormat{Name: e.MimeType},
+			OriginalName: e.Name,
+		}
+		if e.HeadRevisionID != "" {
+			obj.Identifiers = append(obj.Identifiers, &premisIdentifier{Type: "Google Drive revision ID", Value: e.HeadRevisionID})
+		}
+		if e.MD5Checksum != "" {
+			obj.Fixity = append(obj.Fixity, &premisFixity{Algorithm: "MD5", Digest: e.MD5Checksum, Originator: "Google Drive"})
+		}
+		if e.SHA256 != "" {
+			obj.Fixity = append(obj.Fixity, &premisFixity{Algorithm: "SHA-256", Digest: e.SHA256, Originator: agent})
+		}
+		if e.Size > 0 {
+			obj.Size = strconv.FormatInt(e.Size, 10)
+		}
+
+		doc.AmdSecs = append(doc.AmdSecs, &metsAmdSec{
+			ID:         amdID,
+			TechMD:     &metsMD{ID: "TECH_" + strconv.Itoa(n), Wrap: &metsMDWrap{MDType: "PREMIS:OBJECT", Object: obj}},
+			DigiprovMD: &metsMD{ID: "PROV_" + strconv.Itoa(n), Wrap: &metsMDWrap{MDType: "PREMIS:EVENT", Events: m.metsEvents(e, n, agent)}},
+		})
+
+		f := &metsFile{ID: fileID, AdmID: amdID, Location: &metsLocation{LocType: "URL", Href: e.Path}}
+		if e.SHA256 != "" {
+			f.Checksum, f.Type = e.SHA256, "SHA-256"
+		}
+		doc.Files = append(doc.Files, f)
+
+		parent, _ := splitSlash(e.Path)
+		dir(parent).Pointers = append(dir(parent).Pointers, &metsPointer{FileID: fileID})
+	}
+
+	out, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("could not create METS file: %w", err)
+	}
+	defer out.Close()
+
+	if _, err = out.WriteString(xml.Header); err != nil {
+		return fmt.Errorf("could not write METS file: %w", err)
+	}
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "\t")
+	if err = enc.Encode(doc); err != nil {
+		return fmt.Errorf("could not encode METS file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// MigrateOptions configures Service.Migrate
+type MigrateOptions struct {
+	// Filter, if set, chooses which files are copied. Excluded folders aren't created
+	Filter *Filter
+	// DryRun, if true, logs what would be copied without creating any files
+	DryRun bool
+}
+
+// MigrateStats counts the results of a migration
+type MigrateStats struct {
+	// Folders is the number of folders created
+	Folders int
+	// Copied is the number of files copied
+	Copied int
+	// Duplicates is the number of files not copied again because they're in multiple folders and were already copied
+	Duplicates int
+	// Failed is the number of files that couldn't be copied, e.g. because copying is restricted or their type can't be copied
+	Failed int
+	// Bytes is the size reported by Drive of the copied files. Google files have no size
+	Bytes int64
+}
+
+func (s *MigrateStats) String() string {
+	return fmt.Sprintf("created %d folders and copied %d files (%d bytes); %d files already copied to another folder, %d failed",
+		s.Folders, s.Copied, s.Bytes, s.Duplicates, s.Failed)
+}
+
+// createFolder creates a folder named name in the folder with parentID and returns its ID
+func (s *Service) createFolder(ctx context.Context, name, parentID string) (string, error) {
+	var f *drive.File
+	if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		var err error
+		f, err = s.FilesService.Create(&drive.File{Name: name, MimeType: FileTypeFolder, Parents: []string{parentID}}).
+			SupportsAllDrives(true).
+			Fields("id").
+			Context(ctx).
+			Do()
+		return err
+	}); err != nil {
+		return "", fmt.Errorf("could not create folder: %w", err)
+	}
+	return f.Id, nil
+}
+
+// Migrate copies the files in tree to a folder named after the tree's root in the Drive folder or Shared Drive with destID, recreating
+// the tree's folders. Files are copied by Drive with Files.Copy, so nothing is downloaded. The Service's user must be able to read the
+// files and add files to the destination, e.g. as a member of the Shared Drive. Files in multiple folders are copied once.
+// Files that fail to copy are logged and counted, and copying continues
+func (s *Service) Migrate(ctx context.Context, tree *File, destID string, opts *MigrateOptions) (*MigrateStats, error) {
+	if opts == nil {
+		opts = new(MigrateOptions)
+	}
+
+	stats := new(MigrateStats)
+	folders := make(map[string]string)
+	copied := make(map[string]string)
+	err := tree.Walk(func(treePath string, f *File) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		parentID := destID
+		if dir := path.Dir(treePath); dir != "." {
+			id, ok := folders[dir]
+			if !ok {
+				// the parent folder was excluded or couldn't be created
+				return SkipFolder
+			}
+			parentID = id
+		}
+
+		if f.IsFolder() {
+			if !opts.Filter.Folder(treePath) {
+				return SkipFolder
+			}
+			if _, ok := folders[treePath]; ok {
+				return nil
+			}
+			if opts.DryRun {
+				s.logf("%s: would create folder", treePath)
+				folders[treePath] = "dry-run"
+				stats.Folders++
+				return nil
+			}
+			id, err := s.createFolder(ctx, f.Name, parentID)
+			if err != nil {
+				s.warnf("%s: %v", treePath, err)
+				return SkipFolder
+			}
+			folders[treePath] = id
+			stats.Folders++
+			return nil
+		}
+
+		if !opts.Filter.File(treePath, f.File) {
+			return nil
+		}
+		if dest, ok := copied[f.ID]; ok {
+			s.logf("%s: already copied to %s", treePath, dest)
+			stats.Duplicates++
+			return nil
+		}
+		if opts.DryRun {
+			s.logf("%s: would copy", treePath)
+			copied[f.ID] = treePath
+			stats.Copied++
+			stats.Bytes += f.File.Size
+			return nil
+		}
+
+		if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+			_, err := s.FilesService.Copy(f.ID, &drive.File{Name: f.File.Name, Parents: []string{parentID}, ModifiedTime: f.File.ModifiedTime}).
+				SupportsAllDrives(true).
+				Fields("id").
+				Context(ctx).
+				Do()
+			return err
+		}); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			s.warnf("%s: could not copy file: %v", treePath, err)
+			stats.Failed++
+			return nil
+		}
+		s.logf("%s: copied", treePath)
+		copied[f.ID] = treePath
+		stats.Copied++
+		stats.Bytes += f.File.Size
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("interrupted: %w", err)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,67 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// ObjectStore uploads files to a bucket
+type ObjectStore interface {
+	// Upload uploads the file at local as name, unless an object with the same name and contents already exists.
+	// It returns false if the upload was skipped
+	Upload(ctx context.Context, local, name string) (bool, error)
+}
+
+// ParseStoreURL parses an object store URL in the form s3://bucket/prefix or gs://bucket/prefix. gcs:// is accepted as gs://
+func ParseStoreURL(s string) (scheme, bucket, prefix string, err error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", "", fmt.Errorf("could not parse URL: %w", err)
+	}
+	scheme = u.Scheme
+	if scheme == "gcs" {
+		scheme = "gs"
+	}
+	if scheme != "s3" && scheme != "gs" {
+		return "", "", "", fmt.Errorf("unsupported scheme %s: only s3:// and gs:// are supported", u.Scheme)
+	}
+	if u.Host == "" {
+		return "", "", "", fmt.Errorf("%s has no bucket", s)
+	}
+	return scheme, u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+// UploadStore uploads the manifest's captured files in dir to store, named by their paths relative to dir.
+// It returns the number of files uploaded; unchanged files are skipped
+func (m *Manifest) UploadStore(ctx context.Context, store ObjectStore, dir string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return 0, fmt.Errorf("could not resolve %s: %w", dir, err)
+	}
+	n := 0
+	err = m.walkFiles(func(local, _ string) error {
+		abs, err := filepath.Abs(local)
+		if err != nil {
+			return fmt.Errorf("could not resolve %s: %w", local, err)
+		}
+		rel, err := filepath.Rel(dir, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+		uploaded, err := store.Upload(ctx, local, filepath.ToSlash(rel))
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		if uploaded {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,224 @@
+package drive
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/korylprince/drive-archive/drive/sanitize"
+)
+
+const (
+	ocflRootConformance   = "0=ocfl_1.1"
+	ocflObjectConformance = "0=ocfl_object_1.1"
+	ocflInventoryType     = "https://ocfl.io/1.1/spec/#inventory"
+	ocflLayout            = "0002-flat-direct-storage-layout"
+)
+
+// ocflVersion is a version of an OCFL object
+type ocflVersion struct {
+	Created time.Time `json:"created"`
+	Message string    `json:"message,omitempty"`
+	// State maps digests to logical paths
+	State map[string][]string `json:"state"`
+}
+
+// ocflInventory is an OCFL object's inventory.json
+type ocflInventory struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"`
+	DigestAlgorithm string `json:"digestAlgorithm"`
+	Head            string `json:"head"`
+	// Manifest maps digests to content paths
+	Manifest map[string][]string     `json:"manifest"`
+	Versions map[string]*ocflVersion `json:"versions"`
+}
+
+// sha512File returns the hex encoded SHA-512 hash of the file at path
+func sha512File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha512.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// linkOrCopy hard links src to dst, copying it if linking fails
+func linkOrCopy(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeNamaste writes an OCFL conformance declaration file to dir if it doesn't exist
+func writeNamaste(dir, name string) error {
+	p := filepath.Join(dir, name)
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	}
+	return ioutil.WriteFile(p, []byte(strings.TrimPrefix(name, "0=")+"\n"), 0644)
+}
+
+// initOCFLRoot creates an OCFL storage root at root if it doesn't already exist
+func initOCFLRoot(root string) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return fmt.Errorf("could not create storage root: %w", err)
+	}
+	if err := writeNamaste(root, ocflRootConformance); err != nil {
+		return fmt.Errorf("could not write storage root declaration: %w", err)
+	}
+
+	layout := filepath.Join(root, "ocfl_layout.json")
+	if _, err := os.Stat(layout); err == nil {
+		return nil
+	}
+	buf, err := json.MarshalIndent(map[string]string{
+		"extension":   ocflLayout,
+		"description": "object ids are used as object directory names",
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(layout, buf, 0644); err != nil {
+		return fmt.Errorf("could not write storage layout: %w", err)
+	}
+	return nil
+}
+
+// writeInventory writes inv and its sidecar digest file to dir
+func writeInventory(dir string, inv *ocflInventory) error {
+	buf, err := json.MarshalIndent(inv, "", "\t")
+	if err != nil {
+		return fmt.Errorf("could not encode inventory: %w", err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "inventory.json"), buf, 0644); err != nil {
+		return fmt.Errorf("could not write inventory: %w", err)
+	}
+	sum := sha512.Sum512(buf)
+	sidecar := hex.EncodeToString(sum[:]) + " inventory.json\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, "inventory.json.sha512"), []byte(sidecar), 0644); err != nil {
+		return fmt.Errorf("could not write inventory digest: %w", err)
+	}
+	return nil
+}
+
+// WriteOCFL adds the manifest's archived files as a new version of the OCFL object with id in the OCFL storage root at root,
+// creating the storage root and object if necessary. Files whose contents are already in the object aren't stored again.
+// The object's directory is named id, so id must be a valid directory name. WriteOCFL returns the new version, e.g. v2
+func (m *Manifest) WriteOCFL(root, id, message string) (string, error) {
+	if !sanitize.Valid(id) {
+		return "", fmt.Errorf("invalid object id %q: must be a valid directory name", id)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := initOCFLRoot(root); err != nil {
+		return "", err
+	}
+
+	objDir := filepath.Join(root, id)
+	inv := &ocflInventory{
+		ID:              id,
+		Type:            ocflInventoryType,
+		DigestAlgorithm: "sha512",
+		Manifest:        make(map[string][]string),
+		Versions:        make(map[string]*ocflVersion),
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(objDir, "inventory.json"))
+	switch {
+	case err == nil:
+		if err = json.NewDecoder(bytes.NewReader(buf)).Decode(inv); err != nil {
+			return "", fmt.Errorf("could not decode inventory: %w", err)
+		}
+		if inv.ID != id {
+			return "", fmt.Errorf("object directory contains object %s", inv.ID)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if err = os.MkdirAll(objDir, 0755); err != nil {
+			return "", fmt.Errorf("could not create object: %w", err)
+		}
+		if err = writeNamaste(objDir, ocflObjectConformance); err != nil {
+			return "", fmt.Errorf("could not write object declaration: %w", err)
+		}
+	default:
+		return "", fmt.Errorf("could not read inventory: %w", err)
+	}
+
+	version := fmt.Sprintf("v%d", len(inv.Versions)+1)
+	state := make(map[string][]string)
+
+	add := func(local, logical string) error {
+		digest, err := sha512File(local)
+		if err != nil {
+			return fmt.Errorf("%s: could not hash file: %w", logical, err)
+		}
+		state[digest] = append(state[digest], logical)
+		if _, ok := inv.Manifest[digest]; ok {
+			return nil
+		}
+		content := path.Join(version, "content", logical)
+		if err = linkOrCopy(local, filepath.Join(objDir, filepath.FromSlash(content))); err != nil {
+			return fmt.Errorf("%s: could not store file: %w", logical, err)
+		}
+		inv.Manifest[digest] = []string{content}
+		return nil
+	}
+
+	if err = m.walkFiles(add); err != nil {
+		return "", err
+	}
+
+	inv.Head = version
+	inv.Versions[version] = &ocflVersion{Created: time.Now().UTC().Truncate(time.Second), Message: message, State: state}
+
+	versionDir := filepath.Join(objDir, version)
+	if err = os.MkdirAll(versionDir, 0755); err != nil {
+		return "", fmt.Errorf("could not create version directory: %w", err)
+	}
+	if err = writeInventory(versionDir, inv); err != nil {
+		return "", err
+	}
+	if err = writeInventory(objDir, inv); err != nil {
+		return "", err
+	}
+
+	return version, nil
+}
@@ -0,0 +1,82 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// OCRSuffix is added to the path of an archived image or PDF to get the path of its OCR text sidecar
+const OCRSuffix = ".ocr.txt"
+
+// canOCR returns true if Drive can convert files with mimeType to Google Docs with OCR
+func canOCR(mimeType string) bool {
+	switch mimeType {
+	case "application/pdf", "image/jpeg", "image/png", "image/gif", "image/bmp", "image/webp", "image/tiff":
+		return true
+	}
+	return false
+}
+
+// OCRText extracts the text of the image or PDF f with Drive's OCR and writes it to path. f is copied and converted to a Google Doc,
+// the Doc is exported as plain text, and the copy is deleted. language is an optional ISO 639-1 hint for the OCR engine.
+// If the text at path is newer than f, it isn't extracted again and extracted is false
+func (s *Service) OCRText(ctx context.Context, f *drive.File, language, path string) (extracted bool, err error) {
+	if t, err := time.Parse(time.RFC3339, f.ModifiedTime); err == nil && mtimeVerify(path, t) {
+		return false, nil
+	}
+
+	var cp *drive.File
+	if err = retry(ctx, s.initialBackoff, s.tries, func() error {
+		call := s.FilesService.Copy(f.Id, &drive.File{Name: f.Name + " (archive OCR)", MimeType: FileTypeDocument}).
+			SupportsAllDrives(true).
+			Fields("id", "mimeType", "exportLinks")
+		if language != "" {
+			call = call.OcrLanguage(language)
+		}
+		var err error
+		if cp, err = call.Context(ctx).Do(); err != nil {
+			return fmt.Errorf("could not copy file as Google Doc: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return false, err
+	}
+
+	defer func() {
+		// delete the copy even if ctx was canceled
+		ctx, cancel := context.WithTimeout(context.Background(), copyCleanupTimeout)
+		defer cancel()
+		if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+			return s.FilesService.Delete(cp.Id).SupportsAllDrives(true).Context(ctx).Do()
+		}); err != nil {
+			s.warnf("%s: could not delete OCR copy %s: %v\n", s.logPath(path), cp.Id, err)
+		}
+	}()
+
+	cp.Name = f.Name
+	cp.ModifiedTime = f.ModifiedTime
+	if err = s.Export(ctx, cp, "text/plain", path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ocrSidecar writes the OCR text sidecar of the file downloaded to path if it's an image or PDF, returning the manifest status of the sidecar
+func (s *Service) ocrSidecar(ctx context.Context, opts *DownloadOptions, d *download, path string) string {
+	if !canOCR(strings.ToLower(d.File.File.MimeType)) {
+		return ""
+	}
+	extracted, err := s.OCRText(ctx, d.File.File, opts.OCRLanguage, path+OCRSuffix)
+	if err != nil {
+		s.warnf("%s: could not extract OCR text: %v\n", s.logPath(d.Path), err)
+		return err.Error()
+	}
+	if extracted {
+		s.logf("%s: extracted OCR text\n", s.logPath(d.Path))
+	}
+	return "extracted"
+}
@@ -0,0 +1,133 @@
+package drive
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// TreeSize returns the number of files in tree and their total size reported by Drive, as they would be downloaded.
+// Exported Google files have no size
+func TreeSize(tree *File) (files int, size int64) {
+	tree.Walk(func(path string, f *File) error {
+		if !f.IsFolder() {
+			files++
+			size += f.File.Size
+		}
+		return nil
+	})
+	return files, size
+}
+
+// ownedOnly returns a copy of f containing only files owned by the user, or nil if there are none
+func ownedOnly(f *File, parents map[string]bool) *File {
+	if f.ShortcutTarget != nil {
+		f = f.ShortcutTarget
+	}
+	if !f.IsFolder() {
+		if f.File.OwnedByMe {
+			return f
+		}
+		return nil
+	}
+	if parents[f.ID] {
+		return nil
+	}
+	parents[f.ID] = true
+	defer delete(parents, f.ID)
+
+	c := *f
+	c.Files = make([]*File, 0)
+	for _, child := range f.Files {
+		if owned := ownedOnly(child, parents); owned != nil {
+			c.Files = append(c.Files, owned)
+		}
+	}
+	if len(c.Files) == 0 && !f.File.OwnedByMe {
+		return nil
+	}
+	return &c
+}
+
+// OwnedOnly returns a copy of tree containing only the files owned by the user and the folders containing them
+func OwnedOnly(tree *File) *File {
+	owned := ownedOnly(tree, make(map[string]bool))
+	if owned == nil {
+		c := *tree
+		c.Files = make([]*File, 0)
+		return &c
+	}
+	return owned
+}
+
+// OrphanBuckets is how the children of a large orphan tree are grouped into folders
+type OrphanBuckets int
+
+// Orphan bucket kinds
+const (
+	// OrphanBucketsNone keeps every orphaned file in the root of the orphan tree
+	OrphanBucketsNone OrphanBuckets = iota
+	// OrphanBucketsLetter groups files by the first letter or digit of their names. Other names are grouped in #
+	OrphanBucketsLetter
+	// OrphanBucketsOwner groups files by the email of their owner. Files without a known owner are grouped in unknown owner
+	OrphanBucketsOwner
+	// OrphanBucketsDate groups files by the month they were shared with the user, e.g. 2022-06, or created if they weren't shared
+	OrphanBucketsDate
+)
+
+// orphanBucket returns the name of the bucket f is grouped in
+func orphanBucket(f *File, by OrphanBuckets) string {
+	switch by {
+	case OrphanBucketsOwner:
+		if len(f.File.Owners) > 0 && f.File.Owners[0].EmailAddress != "" {
+			return ValidPathChars.ReplaceAllString(strings.ToLower(f.File.Owners[0].EmailAddress), "")
+		}
+		return "unknown owner"
+	case OrphanBucketsDate:
+		t := f.File.SharedWithMeTime
+		if t == "" {
+			t = f.File.CreatedTime
+		}
+		if len(t) >= len("2006-01") {
+			return t[:len("2006-01")]
+		}
+		return "unknown date"
+	}
+	name := []rune(strings.ToUpper(ValidPathChars.ReplaceAllString(f.Name, "")))
+	if len(name) > 0 && (unicode.IsLetter(name[0]) || unicode.IsDigit(name[0])) {
+		return string(name[0])
+	}
+	return "#"
+}
+
+// BucketOrphans returns a copy of the orphan tree with its children grouped into folders by bucket if it has more than
+// threshold children, so huge lists of shared files don't end up in one directory. The bucket folders are sorted by name
+// and keep the order of their files. If by is OrphanBucketsNone or threshold isn't positive, orphans is returned unchanged
+func BucketOrphans(orphans *File, by OrphanBuckets, threshold int) *File {
+	if by == OrphanBucketsNone || threshold <= 0 || len(orphans.Files) <= threshold {
+		return orphans
+	}
+
+	buckets := make(map[string]*File)
+	var names []string
+	for _, f := range orphans.Files {
+		name := orphanBucket(f, by)
+		b, ok := buckets[name]
+		if !ok {
+			b = &File{ID: "orphan-bucket/" + name, Name: name, File: &drive.File{Name: name, MimeType: FileTypeFolder}, Files: make([]*File, 0)}
+			buckets[name] = b
+			names = append(names, name)
+		}
+		b.Files = append(b.Files, f)
+	}
+	sort.Strings(names)
+
+	c := *orphans
+	c.Files = make([]*File, 0, len(names))
+	for _, name := range names {
+		c.Files = append(c.Files, buckets[name])
+	}
+	return &c
+}
@@ -0,0 +1,77 @@
+package drive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// DefaultPDFACommand converts PDFs to PDF/A-2b with Ghostscript
+var DefaultPDFACommand = []string{
+	"gs", "-dPDFA=2", "-dBATCH", "-dNOPAUSE", "-dNOOUTERSAVE", "-dQUIET",
+	"-dPDFACompatibilityPolicy=1", "-sColorConversionStrategy=RGB", "-sDEVICE=pdfwrite",
+	"-sOutputFile={out}", "{in}",
+}
+
+// PDFAConverter converts exported PDFs to PDF/A with external commands
+type PDFAConverter struct {
+	// Command is the converter command. {in} and {out} are replaced with the input and output paths
+	Command []string
+	// Validate, if set, is run after converting with {in} replaced with the converted path. A non-zero exit status fails validation.
+	// If Validate is empty, the converted file is only checked for PDF/A identification metadata
+	Validate []string
+}
+
+func expandCommand(cmd []string, in, out string) *exec.Cmd {
+	args := make([]string, len(cmd))
+	for i, a := range cmd {
+		args[i] = strings.NewReplacer("{in}", in, "{out}", out).Replace(a)
+	}
+	return exec.Command(args[0], args[1:]...)
+}
+
+// validate checks the PDF/A file at path
+func (c *PDFAConverter) validate(path string) error {
+	if len(c.Validate) == 0 {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read converted file: %w", err)
+		}
+		if !bytes.HasPrefix(buf, []byte("%PDF-")) {
+			return errors.New("converted file is not a PDF")
+		}
+		if !bytes.Contains(buf, []byte("pdfaid:part")) {
+			return errors.New("converted file is missing PDF/A identification")
+		}
+		return nil
+	}
+
+	if out, err := expandCommand(c.Validate, path, "").CombinedOutput(); err != nil {
+		return fmt.Errorf("validation failed: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// Convert converts the PDF at path to PDF/A in place, keeping its modified time. If conversion or validation fails, the original file is kept
+func (c *PDFAConverter) Convert(path, modifiedTime string) error {
+	tmp := path + ".pdfa"
+	defer os.Remove(tmp)
+
+	if out, err := expandCommand(c.Command, path, tmp).CombinedOutput(); err != nil {
+		return fmt.Errorf("conversion failed: %w: %s", err, bytes.TrimSpace(out))
+	}
+
+	if err := c.validate(tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("could not replace original file: %w", err)
+	}
+
+	return setMtime(path, modifiedTime)
+}
@@ -0,0 +1,119 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+	"google.golang.org/api/docs/v1"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// maxIdleConnsPerHost is the number of idle connections to each Google API host kept by a ServicePool's transport.
+// Every user's requests go to the same hosts, so the default of 2 would close most connections after each request
+const maxIdleConnsPerHost = 64
+
+// ServicePool creates Services that impersonate different users with the same service account key. The key is read
+// and parsed once, all Services share one transport and its connections, and token sources are cached for each user
+// and scope set so tokens are reused by Services created for the same user. A ServicePool is safe for concurrent use
+type ServicePool struct {
+	initialBackoff time.Duration
+	tries          int
+	config         *jwt.Config
+	transport      http.RoundTripper
+
+	mu      sync.Mutex
+	sources map[string]oauth2.TokenSource
+}
+
+// NewServicePool returns a new ServicePool using the service account credentials JSON file found at configPath.
+// initialBackoff and tries are used by the created Services
+func NewServicePool(configPath string, initialBackoff time.Duration, tries int) (*ServicePool, error) {
+	buf, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config: %w", err)
+	}
+
+	config, err := google.JWTConfigFromJSON(buf)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config: %w", err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+
+	return &ServicePool{
+		initialBackoff: initialBackoff,
+		tries:          tries,
+		config:         config,
+		transport:      transport,
+		sources:        make(map[string]oauth2.TokenSource),
+	}, nil
+}
+
+// tokenSource returns the cached token source for user and scopes, creating it if needed
+func (p *ServicePool) tokenSource(user string, scopes []string) oauth2.TokenSource {
+	key := user + " " + strings.Join(scopes, " ")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ts, ok := p.sources[key]; ok {
+		return ts
+	}
+
+	c := *p.config
+	c.Subject = user
+	c.Scopes = scopes
+	// tokens are fetched with the shared transport
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: p.transport})
+	ts := c.TokenSource(ctx)
+	p.sources[key] = ts
+	return ts
+}
+
+// Service returns a new Service that impersonates user with Scopes, or ReadOnlyScopes if readOnly is true
+func (p *ServicePool) Service(user string, readOnly bool) (*Service, error) {
+	scopes := Scopes
+	if readOnly {
+		scopes = ReadOnlyScopes
+	}
+	return p.service(user, scopes)
+}
+
+func (p *ServicePool) service(user string, scopes []string) (*Service, error) {
+	s := &Service{initialBackoff: p.initialBackoff, tries: p.tries}
+	client := &http.Client{Transport: &sha256Transport{svc: s, base: &limitTransport{svc: s, base: &oauth2.Transport{Source: p.tokenSource(user, scopes), Base: p.transport}}}}
+
+	driveSvc, err := drive.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("Could not create drive service: %w", err)
+	}
+
+	docsSvc, err := docs.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("Could not create docs service: %w", err)
+	}
+
+	sheetsSvc, err := sheets.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("Could not create sheets service: %w", err)
+	}
+
+	s.FilesService = drive.NewFilesService(driveSvc)
+	s.driveSvc = driveSvc
+	s.drives = drive.NewDrivesService(driveSvc)
+	s.revisions = drive.NewRevisionsService(driveSvc)
+	s.client = client
+	s.docs = docsSvc
+	s.sheets = sheetsSvc
+	return s, nil
+}
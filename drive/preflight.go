@@ -0,0 +1,33 @@
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/api/googleapi"
+)
+
+// ErrNoDriveAccess is returned by Preflight when the user can't use Drive, usually because they don't have a license
+// that includes Drive or Drive is turned off for them
+var ErrNoDriveAccess = errors.New("user has no Drive access (no Drive license or Drive is disabled)")
+
+// Preflight checks that the impersonated user can use Drive before any files are listed. If they can't,
+// an error wrapping ErrNoDriveAccess is returned
+func (s *Service) Preflight(ctx context.Context) error {
+	err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		_, err := s.driveSvc.About.Get().Fields("user(emailAddress)").Context(ctx).Do()
+		return err
+	})
+	if err == nil {
+		return nil
+	}
+
+	// rate limit errors are retried by retry, so other 403 errors mean the user isn't allowed to use Drive.
+	// Delegation errors are returned when the token is created, so they aren't mistaken for missing licenses
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) && gErr.Code == 403 && !checkRetry(err) {
+		return fmt.Errorf("%w: %v", ErrNoDriveAccess, err)
+	}
+	return fmt.Errorf("could not get user: %w", err)
+}
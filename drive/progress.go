@@ -0,0 +1,118 @@
+package drive
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressInterval is the number of bytes read between EventProgress events
+const progressInterval = 1024 * 1024
+
+// EventType is the type of a ProgressEvent
+type EventType string
+
+// Progress event types
+const (
+	// EventQueued is sent when a file is queued for downloading, or requeued after its downloader panicked
+	EventQueued EventType = "queued"
+	// EventStarted is sent when a downloader starts downloading a file
+	EventStarted EventType = "started"
+	// EventProgress is sent periodically while a file's contents are downloaded. Files exported with the Docs or Sheets APIs have no progress events
+	EventProgress EventType = "progress"
+	// EventFinished is sent when a file has been downloaded or skipped because the existing file matched
+	EventFinished EventType = "finished"
+	// EventFailed is sent when a file couldn't be downloaded
+	EventFailed EventType = "failed"
+	// EventSkipped is sent when a queued file is skipped because it's larger than the maximum size, or by a dry run
+	EventSkipped EventType = "skipped"
+	// EventDropped is sent when a queued file isn't downloaded because the download was drained, canceled, or stopped by an error
+	EventDropped EventType = "dropped"
+)
+
+// ProgressEvent reports the progress of a file downloaded by DownloadTree
+type ProgressEvent struct {
+	Type   EventType `json:"type"`
+	FileID string    `json:"file_id"`
+	// Path is the path of the file relative to its output path
+	Path string `json:"path"`
+	// Size is the size reported by Drive. Exported Google files have no size
+	Size int64 `json:"size,omitempty"`
+	// Bytes is the number of bytes downloaded so far. It's only set for EventProgress and EventFinished
+	Bytes int64 `json:"bytes,omitempty"`
+	// Downloaded is false for EventFinished if the existing file matched and wasn't downloaded
+	Downloaded bool `json:"downloaded,omitempty"`
+	// Worker is the number of the downloader that sent the event. Queued events aren't sent by downloaders
+	Worker int       `json:"worker,omitempty"`
+	Err    error     `json:"-"`
+	Time   time.Time `json:"time"`
+}
+
+// progressWatchers maps the paths being downloaded to functions called with the number of bytes read
+type progressWatchers struct {
+	mu sync.Mutex
+	m  map[string]func(n int64)
+}
+
+// watch calls f with the total number of bytes read while downloading to path (or a temporary file for path)
+// and returns a function to stop watching
+func (w *progressWatchers) watch(path string, f func(n int64)) (unwatch func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.m == nil {
+		w.m = make(map[string]func(int64))
+	}
+	w.m[path] = f
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.m, path)
+	}
+}
+
+// reader returns r wrapped to report progress to the watcher of path, if any
+func (w *progressWatchers) reader(path string, r io.Reader) io.Reader {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	f, ok := w.m[strings.TrimSuffix(path, PartialSuffix)]
+	if !ok {
+		return r
+	}
+	return &progressReader{r: r, f: f}
+}
+
+type progressReader struct {
+	r        io.Reader
+	f        func(n int64)
+	n        int64
+	reported int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.n-r.reported >= progressInterval || (err == io.EOF && r.n != r.reported) {
+		r.reported = r.n
+		r.f(r.n)
+	}
+	return n, err
+}
+
+// emit sends an event for d to opts.Progress, if set
+func (opts *DownloadOptions) emit(typ EventType, d *download, bytes int64, downloaded bool, err error) {
+	if opts.Progress == nil {
+		return
+	}
+	opts.Progress(&ProgressEvent{
+		Type:       typ,
+		FileID:     d.File.File.Id,
+		Path:       d.Path,
+		Size:       d.File.File.Size,
+		Bytes:      bytes,
+		Downloaded: downloaded,
+		Worker:     d.worker,
+		Err:        err,
+		Time:       time.Now(),
+	})
+}
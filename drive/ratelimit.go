@@ -0,0 +1,72 @@
+package drive
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting the rate of API requests. One RateLimiter can be shared by any number of Services,
+// so all of their downloaders stay under one limit instead of each backing off after being rate limited
+type RateLimiter struct {
+	qps   float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a new RateLimiter allowing qps requests per second on average, and bursts of up to burst requests.
+// If burst is less than 1, it's qps rounded up
+func NewRateLimiter(qps float64, burst int) *RateLimiter {
+	b := float64(burst)
+	if burst < 1 {
+		b = math.Max(1, math.Ceil(qps))
+	}
+	return &RateLimiter{qps: qps, burst: b, tokens: b, last: time.Now()}
+}
+
+// Wait blocks until a request may be sent or ctx is canceled. Requests are allowed in the order Wait was called
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.qps)
+	l.last = now
+	// reserve a token. Later callers wait behind the reservations of earlier callers
+	l.tokens--
+	wait := time.Duration(-l.tokens / l.qps * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// limitTransport waits for the Service's RateLimit, if set, before sending each request
+type limitTransport struct {
+	svc  *Service
+	base http.RoundTripper
+}
+
+func (t *limitTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if l := t.svc.RateLimit; l != nil {
+		if err := l.Wait(r.Context()); err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(r)
+}
@@ -0,0 +1,70 @@
+package drive
+
+import (
+	"sort"
+	"time"
+)
+
+// RecentFiles is the number of most recently active files included in reports
+const RecentFiles = 25
+
+// Recent activity kinds
+const (
+	ActivityModified = "modified"
+	ActivityViewed   = "viewed"
+	ActivityShared   = "shared"
+)
+
+// RecentActivity is a file's most recent activity, for triaging an archive
+type RecentActivity struct {
+	ID   string `json:"id"`
+	Path string `json:"path"`
+	// Activity is the most recent of ActivityModified, ActivityViewed by the archived user, or ActivityShared with them
+	Activity string    `json:"activity"`
+	Time     time.Time `json:"time"`
+	// LastModifyingUser is the email of the user who last modified the file
+	LastModifyingUser string `json:"last_modifying_user,omitempty"`
+	WebViewLink       string `json:"web_view_link,omitempty"`
+}
+
+// lastActivity returns the most recent activity recorded in e, or nil if it has no recorded times
+func (e *ManifestEntry) lastActivity() *RecentActivity {
+	var a *RecentActivity
+	for _, ts := range []struct{ activity, time string }{
+		{ActivityModified, e.ModifiedTime},
+		{ActivityViewed, e.ViewedByMeTime},
+		{ActivityShared, e.SharedWithMeTime},
+	} {
+		t, err := time.Parse(time.RFC3339, ts.time)
+		if err != nil || (a != nil && !t.After(a.Time)) {
+			continue
+		}
+		a = &RecentActivity{ID: e.ID, Path: e.Path, Activity: ts.activity, Time: t, LastModifyingUser: e.LastModifyingUser, WebViewLink: e.WebViewLink}
+	}
+	return a
+}
+
+// RecentlyActive returns the n files in the manifest most recently modified, viewed by the archived user, or shared with them,
+// most recent first. Files in multiple folders are included once
+func (m *Manifest) RecentlyActive(n int) []*RecentActivity {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var recent []*RecentActivity
+	for _, e := range m.Files {
+		if seen[e.ID] {
+			continue
+		}
+		seen[e.ID] = true
+		if a := e.lastActivity(); a != nil {
+			recent = append(recent, a)
+		}
+	}
+
+	sort.SliceStable(recent, func(i, j int) bool { return recent[i].Time.After(recent[j].Time) })
+	if len(recent) > n {
+		recent = recent[:n]
+	}
+	return recent
+}
@@ -0,0 +1,99 @@
+package drive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Layout is how files are laid out in the output path
+type Layout int
+
+const (
+	// LayoutTree mirrors the Drive folder structure
+	LayoutTree Layout = iota
+	// LayoutRecords writes every file to a flat directory, named by its Drive ID, alongside a JSON record descriptor.
+	// Google Docs, Slides, Sheets, and Drawings are exported as PDF
+	LayoutRecords
+)
+
+// RecordExportTypes is used instead of ExportTypes for LayoutRecords
+var RecordExportTypes = map[string]string{
+	"application/vnd.google-apps.document":     "application/pdf",
+	"application/vnd.google-apps.presentation": "application/pdf",
+	"application/vnd.google-apps.spreadsheet":  "application/pdf",
+	"application/vnd.google-apps.drawing":      "application/pdf",
+	"application/vnd.google-apps.jam":          "application/pdf",
+	"application/vnd.google-apps.script":       "application/vnd.google-apps.script+json",
+	"application/vnd.google-apps.form":         "application/zip",
+	"application/vnd.google-apps.site":         "text/plain",
+}
+
+// RecordExportExtensions is used instead of ExportExtensions for LayoutRecords
+var RecordExportExtensions = map[string]string{
+	"application/vnd.google-apps.document":     ".pdf",
+	"application/vnd.google-apps.presentation": ".pdf",
+	"application/vnd.google-apps.spreadsheet":  ".pdf",
+	"application/vnd.google-apps.drawing":      ".pdf",
+	"application/vnd.google-apps.jam":          ".pdf",
+	"application/vnd.google-apps.script":       ".json",
+	"application/vnd.google-apps.form":         ".zip",
+	"application/vnd.google-apps.site":         ".txt",
+}
+
+// RecordDescriptor describes a file written with LayoutRecords
+type RecordDescriptor struct {
+	RecordID     string `json:"record_id"`
+	File         string `json:"file"`
+	Title        string `json:"title"`
+	DrivePath    string `json:"drive_path"`
+	MimeType     string `json:"mime_type"`
+	ExportType   string `json:"export_type,omitempty"`
+	CreatedTime  string `json:"created_time,omitempty"`
+	ModifiedTime string `json:"modified_time,omitempty"`
+	MD5Checksum  string `json:"md5_checksum,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	RunID        string `json:"run_id,omitempty"`
+}
+
+// recordPath returns the file name and export type of f for LayoutRecords
+func recordPath(f *drive.File) (name, exportType string) {
+	if typ, ok := RecordExportTypes[f.MimeType]; ok {
+		return f.Id + RecordExportExtensions[f.MimeType], typ
+	}
+	return f.Id + ValidPathChars.ReplaceAllString(filepath.Ext(f.Name), ""), ""
+}
+
+// writeRecordDescriptor writes the record descriptor for f, which was archived at path, to <id>.record.json next to path
+func (s *Service) writeRecordDescriptor(f *drive.File, drivePath, exportType, path string) error {
+	d := &RecordDescriptor{
+		RecordID:     f.Id,
+		File:         filepath.Base(path),
+		Title:        f.Name,
+		DrivePath:    filepath.ToSlash(drivePath),
+		MimeType:     f.MimeType,
+		ExportType:   exportType,
+		CreatedTime:  f.CreatedTime,
+		ModifiedTime: f.ModifiedTime,
+		MD5Checksum:  f.Md5Checksum,
+		Size:         f.Size,
+		RunID:        s.RunID,
+	}
+
+	file, err := os.Create(filepath.Join(filepath.Dir(path), f.Id+".record.json"))
+	if err != nil {
+		return fmt.Errorf("could not create descriptor: %w", err)
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "\t")
+	if err = enc.Encode(d); err != nil {
+		return fmt.Errorf("could not encode descriptor: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package drive
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// redactPatterns match secrets that must never be logged and their replacements
+var redactPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// OAuth access tokens
+	{regexp.MustCompile(`ya29\.[\w\-.]+`), "ya29.REDACTED"},
+	{regexp.MustCompile(`(?i)(bearer\s+)[\w\-.~+/]+=*`), "${1}REDACTED"},
+	// token and key parameters
+	{regexp.MustCompile(`(?i)(\b(?:access_token|refresh_token|id_token|client_secret|private_key|key|sig|signature|token)=)[^\s&"']+`), "${1}REDACTED"},
+	// token and key fields in JSON
+	{regexp.MustCompile(`(?i)("(?:access_token|refresh_token|id_token|client_secret|private_key)"\s*:\s*")[^"]*`), "${1}REDACTED"},
+	// query strings of URLs, which may include signed or authorized parameters
+	{regexp.MustCompile(`(https?://[^\s"'?]+)\?[^\s"']*`), "${1}?REDACTED"},
+}
+
+// Redact removes tokens, keys, and URL query strings from s
+func Redact(s string) string {
+	for _, p := range redactPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+// logPath returns path as it should be logged. If the Service has a PseudonymKey,
+// each element of path is replaced with a keyed hash, keeping file extensions
+func (s *Service) logPath(path string) string {
+	if s.PseudonymKey == "" {
+		return path
+	}
+
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	for i, p := range parts {
+		if p == "" || p == "." || p == ".." {
+			continue
+		}
+		ext := filepath.Ext(p)
+		h := hmac.New(sha256.New, []byte(s.PseudonymKey))
+		h.Write([]byte(strings.TrimSuffix(p, ext)))
+		parts[i] = hex.EncodeToString(h.Sum(nil))[:12] + ValidPathChars.ReplaceAllString(ext, "")
+	}
+	return filepath.FromSlash(strings.Join(parts, "/"))
+}
@@ -0,0 +1,131 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/sheets/v4"
+)
+
+// reportChunkRows is the number of rows written to a report sheet per request
+const reportChunkRows = 10000
+
+// reportHeader is the header row of the files sheet of a report
+var reportHeader = []interface{}{"Path", "Name", "Mime Type", "Size", "Modified Time", "Status", "Restriction", "Drive Link"}
+
+// recentHeader is the header row of the recent activity sheet of a report
+var recentHeader = []interface{}{"Path", "Activity", "Time", "Last Modifying User", "Drive Link"}
+
+// WriteSheetReport creates a Google Sheet named title in the folder with folderID, with a Files tab listing the manifest's files,
+// a Recent Activity tab listing the most recently active files, and, if stats is set, a Summary tab. It returns the URL of the new Sheet
+func (s *Service) WriteSheetReport(ctx context.Context, m *Manifest, stats *Stats, folderID, title string) (string, error) {
+	var file *drive.File
+	if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		var err error
+		file, err = s.FilesService.Create(&drive.File{Name: title, MimeType: FileTypeSpreadsheet, Parents: []string{folderID}}).
+			SupportsAllDrives(true).
+			Fields("id", "webViewLink").
+			Context(ctx).
+			Do()
+		if err != nil {
+			return fmt.Errorf("could not create report: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	var ss *sheets.Spreadsheet
+	if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		var err error
+		ss, err = s.sheets.Spreadsheets.Get(file.Id).Fields("sheets/properties/sheetId").Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("could not get report: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	reqs := []*sheets.Request{{UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{
+		Properties: &sheets.SheetProperties{
+			SheetId:        ss.Sheets[0].Properties.SheetId,
+			Title:          "Files",
+			GridProperties: &sheets.GridProperties{FrozenRowCount: 1},
+		},
+		Fields: "title,gridProperties.frozenRowCount",
+	}}}
+	reqs = append(reqs, &sheets.Request{AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{
+		Title:          "Recent Activity",
+		GridProperties: &sheets.GridProperties{FrozenRowCount: 1},
+	}}})
+	if stats != nil {
+		reqs = append(reqs, &sheets.Request{AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: "Summary"}}})
+	}
+	if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		_, err := s.sheets.Spreadsheets.BatchUpdate(file.Id, &sheets.BatchUpdateSpreadsheetRequest{Requests: reqs}).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("could not update report sheets: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	rows := make([][]interface{}, 0, len(m.Files)+1)
+	rows = append(rows, reportHeader)
+	for _, e := range m.Files {
+		rows = append(rows, []interface{}{e.Path, e.Name, e.MimeType, e.Size, e.ModifiedTime, e.Status, e.Restriction, e.WebViewLink})
+	}
+	m.mu.Unlock()
+
+	for start := 0; start < len(rows); start += reportChunkRows {
+		end := start + reportChunkRows
+		if end > len(rows) {
+			end = len(rows)
+		}
+		if err := s.writeReportRows(ctx, file.Id, fmt.Sprintf("Files!A%d", start+1), rows[start:end]); err != nil {
+			return "", err
+		}
+	}
+
+	recent := [][]interface{}{recentHeader}
+	for _, a := range m.RecentlyActive(RecentFiles) {
+		recent = append(recent, []interface{}{a.Path, a.Activity, a.Time.Format(time.RFC3339), a.LastModifyingUser, a.WebViewLink})
+	}
+	if err := s.writeReportRows(ctx, file.Id, "'Recent Activity'!A1", recent); err != nil {
+		return "", err
+	}
+
+	if stats != nil {
+		var summary [][]interface{}
+		summary = append(summary, []interface{}{"run id", m.RunID}, []interface{}{"captured", m.Captured.Format("2006-01-02 15:04:05 MST")})
+		for _, line := range strings.Split(stats.String(), "\n") {
+			parts := strings.SplitN(line, ": ", 2)
+			summary = append(summary, []interface{}{parts[0], parts[len(parts)-1]})
+		}
+		if err := s.writeReportRows(ctx, file.Id, "Summary!A1", summary); err != nil {
+			return "", err
+		}
+	}
+
+	return file.WebViewLink, nil
+}
+
+// writeReportRows writes rows to the range starting at rng in the Sheet with id
+func (s *Service) writeReportRows(ctx context.Context, id, rng string, rows [][]interface{}) error {
+	return retry(ctx, s.initialBackoff, s.tries, func() error {
+		_, err := s.sheets.Spreadsheets.Values.Update(id, rng, &sheets.ValueRange{Values: rows}).
+			ValueInputOption("RAW").
+			Context(ctx).
+			Do()
+		if err != nil {
+			return fmt.Errorf("could not write report rows: %w", err)
+		}
+		return nil
+	})
+}
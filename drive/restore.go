@@ -0,0 +1,280 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// FileTypePresentation is the mime type of Google Slides
+const FileTypePresentation = "application/vnd.google-apps.presentation"
+
+// NativeImports maps Google file mime types to the export types Drive can convert back to them when they're uploaded
+var NativeImports = map[string][]string{
+	FileTypeDocument: {
+		"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"application/vnd.oasis.opendocument.text",
+		"application/rtf",
+		"text/plain",
+		"text/html",
+	},
+	FileTypeSpreadsheet: {
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"application/x-vnd.oasis.opendocument.spreadsheet",
+		"text/csv",
+		ExportTypeSheetsCSV,
+	},
+	FileTypePresentation: {
+		"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+		"application/vnd.oasis.opendocument.presentation",
+	},
+}
+
+// RestoreOptions chooses which manifest entries Service.Restore uploads. A nil RestoreOptions restores every captured file
+type RestoreOptions struct {
+	// Filter, if set, chooses entries by their manifest paths, mime types, and modified times. Paths start with the name of
+	// the archived tree's root, e.g. My Drive/Projects/report.pdf
+	Filter *Filter
+	// IDs, if not empty, only restores entries with these Drive IDs
+	IDs map[string]bool
+	// Owners, if not empty, only restores entries owned by one of these emails. The archive must have been created with
+	// the owners extra field, since entries without recorded owners never match
+	Owners map[string]bool
+	// Native, if true, converts exported Google files back to their Google types if Drive can import their export types
+	// (see NativeImports). Sheets archived as directories of CSV files are converted to one spreadsheet per tab
+	Native bool
+	// DryRun, if true, logs what would be restored without creating any files
+	DryRun bool
+}
+
+// RestoreStats counts the results of a restore
+type RestoreStats struct {
+	// Selected is the number of captured entries chosen by the RestoreOptions
+	Selected int
+	// Uploaded is the number of files uploaded. Sheets archived as directories of CSV files are uploaded as one file per tab
+	Uploaded int
+	// Failed is the number of files that couldn't be uploaded
+	Failed int
+	// Bytes is the size of the uploaded files
+	Bytes int64
+}
+
+func (s *RestoreStats) String() string {
+	return fmt.Sprintf("restored %d files (%d bytes) from %d selected entries; %d failed", s.Uploaded, s.Bytes, s.Selected, s.Failed)
+}
+
+// owners returns the emails of the owners recorded in e's extra fields
+func (e *ManifestEntry) owners() []string {
+	raw, ok := e.Extra["owners"]
+	if !ok {
+		return nil
+	}
+	var users []*drive.User
+	if err := json.Unmarshal(raw, &users); err != nil {
+		return nil
+	}
+	emails := make([]string, 0, len(users))
+	for _, u := range users {
+		emails = append(emails, u.EmailAddress)
+	}
+	return emails
+}
+
+// selects returns true if e should be restored
+func (opts *RestoreOptions) selects(e *ManifestEntry) bool {
+	if !e.Captured() {
+		return false
+	}
+	if opts == nil {
+		return true
+	}
+	if len(opts.IDs) > 0 && !opts.IDs[e.ID] {
+		return false
+	}
+	if len(opts.Owners) > 0 {
+		owned := false
+		for _, email := range e.owners() {
+			if opts.Owners[strings.ToLower(email)] {
+				owned = true
+			}
+		}
+		if !owned {
+			return false
+		}
+	}
+	return opts.Filter.File(e.Path, &drive.File{Id: e.ID, MimeType: e.MimeType, ModifiedTime: e.ModifiedTime})
+}
+
+// native returns true if e should be converted back to its Google type
+func (opts *RestoreOptions) native(e *ManifestEntry) bool {
+	if opts == nil || !opts.Native || e.ExportType == "" {
+		return false
+	}
+	for _, typ := range NativeImports[e.MimeType] {
+		if typ == e.ExportType {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreMetadata returns the metadata of the restored file for e, uploaded as the local file named name.
+// The modified time, description, and starred state are restored if they were recorded. Description and starred
+// are only recorded if the archive was created with those extra fields
+func restoreMetadata(e *ManifestEntry, name string, native bool) *drive.File {
+	f := &drive.File{Name: name, ModifiedTime: e.ModifiedTime}
+	if raw, ok := e.Extra["description"]; ok {
+		json.Unmarshal(raw, &f.Description)
+	}
+	if raw, ok := e.Extra["starred"]; ok {
+		json.Unmarshal(raw, &f.Starred)
+	}
+	if !native {
+		return f
+	}
+
+	f.MimeType = e.MimeType
+	if e.ExportType == ExportTypeSheetsCSV {
+		// tabs are named after their CSV files
+		f.Name = strings.TrimSuffix(name, filepath.Ext(name))
+	} else {
+		f.Name = e.Name
+	}
+	return f
+}
+
+// restorer uploads files to a Drive folder, creating the folders in their paths
+type restorer struct {
+	svc     *Service
+	ctx     context.Context
+	root    string
+	folders map[string]string
+}
+
+// folder returns the ID of the folder at the slash separated path relative to the restore folder, creating it and its parents if needed
+func (r *restorer) folder(dir string) (string, error) {
+	if dir == "." || dir == "" {
+		return r.root, nil
+	}
+	if id, ok := r.folders[dir]; ok {
+		return id, nil
+	}
+	parent, err := r.folder(path.Dir(dir))
+	if err != nil {
+		return "", err
+	}
+
+	id, err := r.svc.createFolder(r.ctx, path.Base(dir), parent)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", dir, err)
+	}
+	r.folders[dir] = id
+	return id, nil
+}
+
+// upload uploads the local file to the folder at the slash separated path dir relative to the restore folder, with the metadata in f
+func (r *restorer) upload(local, dir string, f *drive.File) error {
+	parent, err := r.folder(dir)
+	if err != nil {
+		return err
+	}
+	f.Parents = []string{parent}
+
+	return retry(r.ctx, r.svc.initialBackoff, r.svc.tries, func() error {
+		// the file is reopened for each try, since failed uploads may have read part of it
+		in, err := os.Open(local)
+		if err != nil {
+			return fmt.Errorf("could not open file: %w", err)
+		}
+		defer in.Close()
+
+		_, err = r.svc.FilesService.Create(f).
+			Media(in).
+			SupportsAllDrives(true).
+			Fields("id").
+			Context(r.ctx).
+			Do()
+		if err != nil {
+			return fmt.Errorf("could not upload file: %w", err)
+		}
+		return nil
+	})
+}
+
+// Restore uploads the captured files in m chosen by opts to the Drive folder or Shared Drive with folderID, recreating the folders in
+// their manifest paths and restoring their recorded metadata. Files routed outside of the manifest's root are restored under routed/. Files that fail to upload are logged
+// and counted, and restoring continues
+func (s *Service) Restore(ctx context.Context, m *Manifest, folderID string, opts *RestoreOptions) (*RestoreStats, error) {
+	m.mu.Lock()
+	entries := append(make([]*ManifestEntry, 0, len(m.Files)), m.Files...)
+	m.mu.Unlock()
+
+	stats := new(RestoreStats)
+	r := &restorer{svc: s, ctx: ctx, root: folderID, folders: make(map[string]string)}
+	for _, e := range entries {
+		if !opts.selects(e) {
+			continue
+		}
+		stats.Selected++
+
+		local := m.localPath(e)
+		rel := strings.TrimPrefix(path.Clean(e.Path), "/")
+		if path.IsAbs(e.Path) {
+			rel = path.Join("routed", rel)
+		}
+
+		native := opts.native(e)
+
+		// Sheets exported with the API are directories of CSV files
+		if err := filepath.Walk(local, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			sub, err := filepath.Rel(local, p)
+			if err != nil {
+				return err
+			}
+			dest := path.Join(rel, filepath.ToSlash(sub))
+			dir := path.Dir(dest)
+			meta := restoreMetadata(e, path.Base(dest), native)
+			// converted files are named after the original file, which may have characters that are invalid in paths
+			dest = dir + "/" + meta.Name
+
+			if opts != nil && opts.DryRun {
+				s.logf("%s: would restore to %s", p, dest)
+				stats.Uploaded++
+				stats.Bytes += info.Size()
+				return nil
+			}
+			if err = r.upload(p, dir, meta); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				s.warnf("%s: could not restore file: %v", dest, err)
+				stats.Failed++
+				return nil
+			}
+			s.logf("%s: restored", dest)
+			stats.Uploaded++
+			stats.Bytes += info.Size()
+			return nil
+		}); err != nil {
+			if ctx.Err() != nil {
+				return stats, fmt.Errorf("interrupted: %w", ctx.Err())
+			}
+			s.warnf("%s: could not restore file: %v", e.Path, err)
+			stats.Failed++
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,102 @@
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrRestricted is returned when a file can't be downloaded because its owner has disabled downloading, printing, and copying
+var ErrRestricted = errors.New("download restricted")
+
+// restrictedReasons are the error reasons returned when downloading is disabled for a file
+var restrictedReasons = map[string]struct{}{
+	"cannotDownloadFile":  {},
+	"cannotCopyFile":      {},
+	"cannotExportFile":    {},
+	"fileNotDownloadable": {},
+}
+
+// isRestricted returns true if err was caused by a download restriction
+func isRestricted(err error) bool {
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		for _, e := range gErr.Errors {
+			if _, ok := restrictedReasons[e.Reason]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// download exports f as exportType, or downloads it directly if exportType is empty, to path. Downloads are verified with sum,
+// the file's SHA-256 checksum, if set. If f is restricted and s.CopyRestricted is true, a copy of f is downloaded instead
+func (s *Service) download(ctx context.Context, f *drive.File, exportType, path, sum string) error {
+	err := s.fetch(ctx, f, exportType, path, sum)
+	if err == nil || !isRestricted(err) {
+		return err
+	}
+
+	if !s.CopyRestricted {
+		return fmt.Errorf("%w: %v", ErrRestricted, err)
+	}
+
+	if cErr := s.fetchCopy(ctx, f, exportType, path, sum); cErr != nil {
+		return fmt.Errorf("%w: %v; could not download copy: %v", ErrRestricted, err, cErr)
+	}
+
+	s.logf("%s: downloaded copy of restricted file\n", s.logPath(path))
+	return nil
+}
+
+// copyCleanupTimeout is the maximum time allowed to delete a temporary copy of a file
+const copyCleanupTimeout = time.Minute
+
+// fetchCopy copies f, downloads the copy to path like fetch, and deletes the copy
+func (s *Service) fetchCopy(ctx context.Context, f *drive.File, exportType, path, sum string) error {
+	return s.withCopy(ctx, f, path, func(cp *drive.File) error {
+		return s.fetch(ctx, cp, exportType, path, sum)
+	})
+}
+
+// withCopy copies f, calls fn with the copy, and deletes the copy. The copy has f's name and modified time
+// so files written from it match the original. path is only used in logs
+func (s *Service) withCopy(ctx context.Context, f *drive.File, path string, fn func(cp *drive.File) error) error {
+	var cp *drive.File
+	if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		var err error
+		cp, err = s.FilesService.Copy(f.Id, &drive.File{Name: f.Name + " (archive copy)"}).
+			SupportsAllDrives(true).
+			Fields("id", "mimeType", "md5Checksum", "exportLinks").
+			Context(ctx).
+			Do()
+		if err != nil {
+			return fmt.Errorf("could not copy file: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	defer func() {
+		// delete the copy even if ctx was canceled, so it isn't left in the user's Drive
+		ctx, cancel := context.WithTimeout(context.Background(), copyCleanupTimeout)
+		defer cancel()
+		if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+			return s.FilesService.Delete(cp.Id).SupportsAllDrives(true).Context(ctx).Do()
+		}); err != nil {
+			s.warnf("%s: could not delete copy %s: %v\n", s.logPath(path), cp.Id, err)
+		}
+	}()
+
+	// keep original metadata so the archived file matches the original
+	cp.Name = f.Name
+	cp.ModifiedTime = f.ModifiedTime
+
+	return fn(cp)
+}
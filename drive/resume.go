@@ -0,0 +1,148 @@
+package drive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// ResumableSize is the minimum size of files downloaded resumably. Resumable downloads are written to a PartSuffix file next to
+// the destination, with a ResumeStateSuffix file recording the file version. If a download fails, it's resumed from the end of the
+// PartSuffix file with a Range request, including by later runs if the file hasn't changed
+const ResumableSize = 64 * 1024 * 1024
+
+// resumeState identifies the version of a file a PartSuffix file was downloaded from
+type resumeState struct {
+	ID         string `json:"id"`
+	RevisionID string `json:"revision_id,omitempty"`
+	MD5        string `json:"md5"`
+	Size       int64  `json:"size"`
+}
+
+// resumeError is returned when a download fails after part of the body was written. It's always retried
+type resumeError struct {
+	err error
+}
+
+func (e *resumeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *resumeError) Unwrap() error {
+	return e.err
+}
+
+// resumeOffset returns the size of the PartSuffix file at part if it was downloaded from the same version of the file as want, or 0
+func resumeOffset(part string, want *resumeState) int64 {
+	buf, err := os.ReadFile(part + ResumeStateSuffix)
+	if err != nil {
+		return 0
+	}
+	have := new(resumeState)
+	if err = json.Unmarshal(buf, have); err != nil || *have != *want {
+		return 0
+	}
+	info, err := os.Stat(part)
+	if err != nil || info.Size() > want.Size {
+		return 0
+	}
+	return info.Size()
+}
+
+// downloadResumable downloads file to path through a PartSuffix file, resuming an earlier download of the same version of file if one exists.
+// The download is verified with want256, the file's hex encoded SHA-256 checksum, if set, and its MD5 checksum otherwise
+func (s *Service) downloadResumable(ctx context.Context, file *drive.File, path, want256 string) error {
+	part := path + PartSuffix
+	want := &resumeState{ID: file.Id, MD5: file.Md5Checksum, Size: file.Size}
+	if s.PinRevisions {
+		want.RevisionID = file.HeadRevisionId
+	}
+
+	offset := resumeOffset(part, want)
+	if offset > 0 {
+		s.logf("%s: resuming download at %d of %d bytes\n", s.logPath(path), offset, file.Size)
+	} else {
+		buf, err := json.Marshal(want)
+		if err != nil {
+			return fmt.Errorf("could not encode resume state: %w", err)
+		}
+		if err = os.WriteFile(part+ResumeStateSuffix, buf, 0644); err != nil {
+			return fmt.Errorf("could not write resume state: %w", err)
+		}
+	}
+
+	if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		resp, err := s.downloadRequest(ctx, file, path, offset)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		if resp.StatusCode != http.StatusPartialContent {
+			// the range wasn't honored, so start over
+			offset = 0
+			flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		f, err := os.OpenFile(part, flags, 0644)
+		if err != nil {
+			return fmt.Errorf("could not open partial file: %w", err)
+		}
+
+		n, err := io.Copy(f, s.watchers.reader(path, s.Throttle.Reader(resp.Body)))
+		offset += n
+		if err == nil {
+			err = f.Sync()
+		}
+		if cErr := f.Close(); err == nil && cErr != nil {
+			err = cErr
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return &resumeError{fmt.Errorf("could not write body at %d of %d bytes: %w", offset, file.Size, err)}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if want256 != "" {
+		got, err := sha256File(part)
+		if err == nil && got != want256 {
+			err = &checksumError{alg: "sha256", want: want256, got: got}
+		}
+		if err != nil {
+			os.Remove(part)
+			os.Remove(part + ResumeStateSuffix)
+			return err
+		}
+	} else if file.Md5Checksum != "" {
+		got, err := md5File(part)
+		if err == nil {
+			err = s.verifyChecksum(ctx, file, path, got)
+		}
+		if err != nil {
+			os.Remove(part)
+			os.Remove(part + ResumeStateSuffix)
+			return err
+		}
+	} else if offset != file.Size {
+		os.Remove(part)
+		os.Remove(part + ResumeStateSuffix)
+		return fmt.Errorf("could not verify download: file changed while downloading")
+	}
+
+	if err := os.Rename(part, path); err != nil {
+		return fmt.Errorf("could not move file into place: %w", err)
+	}
+	os.Remove(part + ResumeStateSuffix)
+
+	return setMtime(path, file.ModifiedTime)
+}
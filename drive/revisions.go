@@ -0,0 +1,164 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// RevisionsSuffix is added to the path of an archived file to get the path of its revision metadata sidecar
+const RevisionsSuffix = ".revisions.json"
+
+// RevisionsDirSuffix is added to the path of an archived file to get the path of the directory its previous revisions are downloaded to
+const RevisionsDirSuffix = ".revisions"
+
+// Revisions is which previous revisions of files are archived
+type Revisions int
+
+const (
+	// RevisionsNone doesn't archive revisions
+	RevisionsNone Revisions = iota
+	// RevisionsAll downloads every previous revision of binary files
+	RevisionsAll
+	// RevisionsKeepForever only downloads previous revisions of binary files marked to be kept forever, which Drive never deletes
+	RevisionsKeepForever
+)
+
+// revisionFields are the fields requested for each revision
+var revisionFields = []googleapi.Field{
+	"nextPageToken",
+	"revisions/id",
+	"revisions/mimeType",
+	"revisions/modifiedTime",
+	"revisions/keepForever",
+	"revisions/size",
+	"revisions/md5Checksum",
+	"revisions/originalFilename",
+	"revisions/lastModifyingUser/emailAddress",
+	"revisions/lastModifyingUser/displayName",
+}
+
+// RevisionMetadata is the metadata of a revision, written to a sidecar next to the archived file
+type RevisionMetadata struct {
+	ID               string `json:"id"`
+	MimeType         string `json:"mime_type,omitempty"`
+	ModifiedTime     string `json:"modified_time,omitempty"`
+	KeepForever      bool   `json:"keep_forever"`
+	Size             int64  `json:"size,omitempty"`
+	MD5Checksum      string `json:"md5_checksum,omitempty"`
+	OriginalFilename string `json:"original_filename,omitempty"`
+	// LastModifyingUser is the email, or name if there's no email, of the user who created the revision
+	LastModifyingUser string `json:"last_modifying_user,omitempty"`
+	// Path is the path of the downloaded revision relative to the archived file's directory. It's empty if the revision wasn't downloaded
+	Path string `json:"path,omitempty"`
+}
+
+// RevisionsSidecar is the revision history of a file
+type RevisionsSidecar struct {
+	FileID    string              `json:"file_id"`
+	Revisions []*RevisionMetadata `json:"revisions"`
+}
+
+// listRevisions returns the revisions of the file with id, oldest first
+func (s *Service) listRevisions(ctx context.Context, id string) ([]*drive.Revision, error) {
+	var revisions []*drive.Revision
+	token := ""
+	for {
+		var list *drive.RevisionList
+		if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+			var err error
+			list, err = s.revisions.List(id).PageSize(1000).PageToken(token).Fields(revisionFields...).Context(ctx).Do()
+			return err
+		}); err != nil {
+			return nil, fmt.Errorf("could not list revisions: %w", err)
+		}
+		revisions = append(revisions, list.Revisions...)
+		if list.NextPageToken == "" {
+			return revisions, nil
+		}
+		token = list.NextPageToken
+	}
+}
+
+// downloadRevision downloads revision r of the file with id to path, unless it's already there
+func (s *Service) downloadRevision(ctx context.Context, id string, r *drive.Revision, path string) error {
+	if r.Md5Checksum != "" && md5Verify(path, r.Md5Checksum) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("could not create revisions directory: %w", err)
+	}
+	return retry(ctx, s.initialBackoff, s.tries, func() error {
+		resp, err := s.revisions.Get(id, r.Id).Context(ctx).Download()
+		if err != nil {
+			return fmt.Errorf("could not download revision %s: %w", r.Id, err)
+		}
+		defer resp.Body.Close()
+		return writeBody(s.Throttle.Reader(resp.Body), path, r.ModifiedTime)
+	})
+}
+
+// writeRevisions writes the revision history of f, archived at path, to a sidecar named with RevisionsSuffix, and downloads the
+// previous revisions of binary files chosen by mode to a directory named with RevisionsDirSuffix. It returns the number of revisions
+// downloaded. Revisions that fail to download are logged and recorded without a path
+func (s *Service) writeRevisions(ctx context.Context, f *drive.File, path string, mode Revisions) (int, error) {
+	revisions, err := s.listRevisions(ctx, f.Id)
+	if err != nil {
+		return 0, err
+	}
+
+	// Google file revisions can only be exported, and the head revision is the archived file
+	binary := !strings.HasPrefix(f.MimeType, FileTypeGooglePrefix)
+	sidecar := &RevisionsSidecar{FileID: f.Id, Revisions: make([]*RevisionMetadata, 0, len(revisions))}
+	downloaded := 0
+	for i, r := range revisions {
+		meta := &RevisionMetadata{
+			ID:               r.Id,
+			MimeType:         r.MimeType,
+			ModifiedTime:     r.ModifiedTime,
+			KeepForever:      r.KeepForever,
+			Size:             r.Size,
+			MD5Checksum:      r.Md5Checksum,
+			OriginalFilename: r.OriginalFilename,
+		}
+		if u := r.LastModifyingUser; u != nil {
+			meta.LastModifyingUser = u.EmailAddress
+			if meta.LastModifyingUser == "" {
+				meta.LastModifyingUser = u.DisplayName
+			}
+		}
+		sidecar.Revisions = append(sidecar.Revisions, meta)
+
+		if !binary || i == len(revisions)-1 || (mode == RevisionsKeepForever && !r.KeepForever) {
+			continue
+		}
+		// revision IDs are opaque strings, so they're cleaned like file names
+		name := ValidPathChars.ReplaceAllString(r.Id, "_") + filepath.Ext(path)
+		if err = s.downloadRevision(ctx, f.Id, r, filepath.Join(path+RevisionsDirSuffix, name)); err != nil {
+			if ctx.Err() != nil {
+				return downloaded, ctx.Err()
+			}
+			s.warnf("%s: %v\n", s.logPath(path), err)
+			continue
+		}
+		meta.Path = filepath.Base(path) + RevisionsDirSuffix + "/" + name
+		downloaded++
+	}
+
+	buf, err := json.MarshalIndent(sidecar, "", "\t")
+	if err != nil {
+		return downloaded, fmt.Errorf("could not encode revisions: %w", err)
+	}
+	if err = writeBody(bytes.NewReader(append(buf, '\n')), path+RevisionsSuffix, f.ModifiedTime); err != nil {
+		return downloaded, fmt.Errorf("could not write revisions sidecar: %w", err)
+	}
+	return downloaded, nil
+}
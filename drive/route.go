@@ -0,0 +1,58 @@
+package drive
+
+import (
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Route sends files matching all of its conditions to Dest. Empty or zero conditions always match
+type Route struct {
+	// MimePrefixes matches files whose mime type starts with any of MimePrefixes
+	MimePrefixes []string
+	// MinSize matches files that are larger than MinSize bytes
+	MinSize int64
+	// MaxSize matches files that are smaller than MaxSize bytes
+	MaxSize int64
+	// Dest is the output path for matching files
+	Dest string
+	// Store, if set, is the object store the files written to Dest are uploaded to after downloading
+	Store ObjectStore
+}
+
+// Match returns true if f matches all of the route's conditions
+func (r *Route) Match(f *drive.File) bool {
+	if len(r.MimePrefixes) > 0 && !r.matchMime(f.MimeType) {
+		return false
+	}
+	if r.MinSize > 0 && f.Size <= r.MinSize {
+		return false
+	}
+	if r.MaxSize > 0 && f.Size >= r.MaxSize {
+		return false
+	}
+	return true
+}
+
+// matchMime returns true if mimeType starts with any of the route's MimePrefixes
+func (r *Route) matchMime(mimeType string) bool {
+	for _, p := range r.MimePrefixes {
+		if strings.HasPrefix(mimeType, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Router chooses an output path for each file from a list of routes
+type Router []*Route
+
+// Dest returns the Dest of the first route that matches f, or def if no routes match
+func (r Router) Dest(f *drive.File, def string) string {
+	for _, route := range r {
+		if route.Match(f) {
+			return route.Dest
+		}
+	}
+	return def
+}
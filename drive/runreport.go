@@ -0,0 +1,102 @@
+package drive
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Run report statuses
+const (
+	// RunCompleted runs downloaded every selected file
+	RunCompleted = "completed"
+	// RunCompletedWithErrors runs finished, but some files couldn't be downloaded
+	RunCompletedWithErrors = "completed_with_errors"
+	// RunDrained runs were stopped before all files were downloaded
+	RunDrained = "drained"
+	// RunFailed runs stopped because of an error
+	RunFailed = "failed"
+)
+
+// RunReportStats are the aggregate results of a run
+type RunReportStats struct {
+	Listed      int64 `json:"listed"`
+	Downloaded  int64 `json:"downloaded"`
+	Exported    int64 `json:"exported"`
+	Existing    int64 `json:"existing"`
+	Unsupported int64 `json:"unsupported"`
+	Failed      int64 `json:"failed"`
+	Restricted  int64 `json:"restricted"`
+	Skipped     int64 `json:"skipped"`
+	Dropped     int64 `json:"dropped"`
+
+	ListedBytes     int64 `json:"listed_bytes"`
+	CapturedBytes   int64 `json:"captured_bytes"`
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+	SkippedBytes    int64 `json:"skipped_bytes"`
+
+	// FileCompleteness and ByteCompleteness are the percentages returned by Stats.Completeness
+	FileCompleteness float64 `json:"file_completeness"`
+	ByteCompleteness float64 `json:"byte_completeness"`
+}
+
+// RunReport is a machine readable report of a run, for monitoring systems to confirm archives completed
+type RunReport struct {
+	RunID           string          `json:"run_id"`
+	User            string          `json:"user,omitempty"`
+	Status          string          `json:"status"`
+	Error           string          `json:"error,omitempty"`
+	Started         time.Time       `json:"started"`
+	Finished        time.Time       `json:"finished"`
+	DurationSeconds float64         `json:"duration_seconds"`
+	Stats           *RunReportStats `json:"stats"`
+	// RecentlyActive are the RecentFiles files most recently modified, viewed, or shared, for triage
+	RecentlyActive []*RecentActivity `json:"recently_active"`
+	// Files are the manifest entries of every file the run listed before it stopped
+	Files []*ManifestEntry `json:"files"`
+}
+
+// NewRunReport returns a new RunReport for the run recorded in m and s with status, finished now.
+// If err is not nil, it's recorded as the report's error
+func NewRunReport(user, status string, m *Manifest, s *Stats, err error) *RunReport {
+	r := &RunReport{RunID: m.RunID, User: user, Status: status, Started: m.Captured, Finished: time.Now()}
+	r.DurationSeconds = r.Finished.Sub(r.Started).Seconds()
+	if err != nil {
+		r.Error = Redact(err.Error())
+	}
+
+	m.mu.Lock()
+	r.Files = append(make([]*ManifestEntry, 0, len(m.Files)), m.Files...)
+	m.mu.Unlock()
+
+	r.RecentlyActive = m.RecentlyActive(RecentFiles)
+
+	files, bytes := s.Completeness()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.Stats = &RunReportStats{
+		Listed: s.Listed, Downloaded: s.Downloaded, Exported: s.Exported, Existing: s.Existing, Unsupported: s.Unsupported,
+		Failed: s.Failed, Restricted: s.Restricted, Skipped: s.Skipped, Dropped: s.Dropped,
+		ListedBytes: s.ListedBytes, CapturedBytes: s.CapturedBytes, DownloadedBytes: s.DownloadedBytes, SkippedBytes: s.SkippedBytes,
+		FileCompleteness: files, ByteCompleteness: bytes,
+	}
+	return r
+}
+
+// Write writes the report as JSON to path. The report is written to a temporary file and moved into place,
+// so monitoring never reads a partial report
+func (r *RunReport) Write(path string) error {
+	f, err := createAtomic(path)
+	if err != nil {
+		return fmt.Errorf("could not create report: %w", err)
+	}
+	defer f.abort()
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	if err = enc.Encode(r); err != nil {
+		return fmt.Errorf("could not encode report: %w", err)
+	}
+
+	return f.commit("")
+}
@@ -0,0 +1,175 @@
+package drive
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// RunStatusVersion is the version of the RunStatus JSON schema. It's incremented when fields are removed or their meaning changes
+const RunStatusVersion = 1
+
+// maxStatusErrors is the number of recent errors kept by RunState
+const maxStatusErrors = 20
+
+// ActiveFile is a file being downloaded
+type ActiveFile struct {
+	FileID string `json:"file_id"`
+	Path   string `json:"path"`
+	// Size is the size reported by Drive. Exported Google files have no size
+	Size    int64     `json:"size,omitempty"`
+	Bytes   int64     `json:"bytes"`
+	Started time.Time `json:"started"`
+}
+
+// StatusError is a file that couldn't be downloaded
+type StatusError struct {
+	FileID string    `json:"file_id"`
+	Path   string    `json:"path"`
+	Error  string    `json:"error"`
+	Time   time.Time `json:"time"`
+}
+
+// RunStatus is a snapshot of the state of a run
+type RunStatus struct {
+	Version int    `json:"version"`
+	RunID   string `json:"run_id,omitempty"`
+	// State is running, paused, or draining if the run has a Control, otherwise running
+	State   string    `json:"state"`
+	Started time.Time `json:"started"`
+	Updated time.Time `json:"updated"`
+	// Total is the number of files found in the trees being downloaded, including files that may be skipped
+	Total int64 `json:"total"`
+	// Queued is the number of files waiting for a downloader
+	Queued    int64 `json:"queued"`
+	Active    int64 `json:"active"`
+	Completed int64 `json:"completed"`
+	Failed    int64 `json:"failed"`
+	Dropped   int64 `json:"dropped"`
+	// Skipped is the number of files skipped because they're larger than the maximum size, or by a dry run
+	Skipped int64 `json:"skipped"`
+	// Bytes is the number of bytes downloaded
+	Bytes int64 `json:"bytes"`
+	// Percent is the percentage of Total that's completed, failed, dropped, or skipped
+	Percent float64 `json:"percent"`
+	// ETA is the estimated time the run will finish, based on the rate files have been processed. It's omitted until a file is processed
+	ETA         *time.Time     `json:"eta,omitempty"`
+	ActiveFiles []*ActiveFile  `json:"active_files"`
+	Errors      []*StatusError `json:"errors"`
+}
+
+// RunState tracks the progress of a run from ProgressEvents
+type RunState struct {
+	// Control, if set, is used to report whether the run is paused or draining
+	Control *Control
+
+	mu      sync.Mutex
+	status  RunStatus
+	active  map[string]*ActiveFile
+	errors  []*StatusError
+	started time.Time
+}
+
+// NewRunState returns a new RunState for the run with runID
+func NewRunState(runID string) *RunState {
+	now := time.Now()
+	return &RunState{
+		status:  RunStatus{Version: RunStatusVersion, RunID: runID, Started: now, Updated: now},
+		active:  make(map[string]*ActiveFile),
+		started: now,
+	}
+}
+
+// AddTotal adds n to the number of files in the run
+func (s *RunState) AddTotal(n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.Total += int64(n)
+}
+
+// Progress is a DownloadOptions.Progress callback that updates the state
+func (s *RunState) Progress(e *ProgressEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.Updated = e.Time
+	key := e.FileID + "/" + e.Path
+	switch e.Type {
+	case EventQueued:
+		delete(s.active, key)
+		s.status.Queued++
+	case EventStarted:
+		s.status.Queued--
+		s.active[key] = &ActiveFile{FileID: e.FileID, Path: e.Path, Size: e.Size, Started: e.Time}
+	case EventProgress:
+		if a, ok := s.active[key]; ok {
+			a.Bytes = e.Bytes
+		}
+	case EventFinished:
+		delete(s.active, key)
+		s.status.Completed++
+		if e.Downloaded {
+			s.status.Bytes += e.Bytes
+		}
+	case EventFailed:
+		delete(s.active, key)
+		s.status.Failed++
+		msg := "unknown error"
+		if e.Err != nil {
+			msg = Redact(e.Err.Error())
+		}
+		s.errors = append(s.errors, &StatusError{FileID: e.FileID, Path: e.Path, Error: msg, Time: e.Time})
+		if len(s.errors) > maxStatusErrors {
+			s.errors = s.errors[len(s.errors)-maxStatusErrors:]
+		}
+	case EventSkipped:
+		s.status.Queued--
+		s.status.Skipped++
+	case EventDropped:
+		s.status.Queued--
+		s.status.Dropped++
+	}
+}
+
+// Status returns a snapshot of the state
+func (s *RunState) Status() *RunStatus {
+	state := "running"
+	if s.Control != nil {
+		s.Control.mu.Lock()
+		state = s.Control.state()
+		s.Control.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.status
+	st.State = state
+	st.Active = int64(len(s.active))
+
+	done := st.Completed + st.Failed + st.Dropped + st.Skipped
+	if st.Total > 0 {
+		st.Percent = 100 * float64(done) / float64(st.Total)
+		if st.Percent > 100 {
+			st.Percent = 100
+		}
+	}
+	if done > 0 && st.Total > done {
+		elapsed := time.Since(s.started)
+		eta := time.Now().Add(time.Duration(float64(elapsed) / float64(done) * float64(st.Total-done))).Round(time.Second)
+		st.ETA = &eta
+	}
+
+	st.ActiveFiles = make([]*ActiveFile, 0, len(s.active))
+	for _, a := range s.active {
+		c := *a
+		st.ActiveFiles = append(st.ActiveFiles, &c)
+	}
+	sort.Slice(st.ActiveFiles, func(i, j int) bool { return st.ActiveFiles[i].Started.Before(st.ActiveFiles[j].Started) })
+
+	st.Errors = append(make([]*StatusError, 0, len(s.errors)), s.errors...)
+	return &st
+}
@@ -0,0 +1,342 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3PartSize is the size of the parts large files are uploaded to S3 in. Objects can have at most 10,000 parts
+const s3PartSize = 256 * 1024 * 1024
+
+// s3UnsignedPayload is the payload hash of requests whose bodies aren't signed
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// s3MD5Header is the object metadata header the MD5 checksum of uploaded files is stored in, since the ETags of objects uploaded
+// in parts aren't their checksums
+const s3MD5Header = "X-Amz-Meta-Md5"
+
+// s3Error is an error returned by the S3 API
+type s3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("s3 error %d (%s): %s", e.Status, e.Code, e.Message)
+}
+
+// retryable returns true if the request should be retried
+func (e *s3Error) retryable() bool {
+	return e.Status == http.StatusRequestTimeout || e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// S3 uploads files to an Amazon S3 or S3 compatible bucket, signing requests with AWS Signature Version 4.
+// Files larger than the part size are uploaded in parts
+type S3 struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is only set for temporary credentials
+	SessionToken string
+	Region       string
+	// Endpoint, if set, is the URL of an S3 compatible service, which is addressed with path style URLs.
+	// Otherwise the bucket's AWS virtual hosted URL is used
+	Endpoint string
+	Bucket   string
+	// Prefix is prepended to uploaded object names
+	Prefix string
+
+	initialBackoff time.Duration
+	tries          int
+	client         *http.Client
+	partSize       int64
+	// now returns the time requests are signed at
+	now func() time.Time
+}
+
+// NewS3 returns a new S3 uploading to bucket in region with the access key keyID and secret
+func NewS3(keyID, secret, region, bucket, prefix string) *S3 {
+	return &S3{
+		AccessKeyID:     keyID,
+		SecretAccessKey: secret,
+		Region:          region,
+		Bucket:          bucket,
+		Prefix:          prefix,
+		initialBackoff:  time.Second,
+		tries:           8,
+		client:          http.DefaultClient,
+		partSize:        s3PartSize,
+		now:             time.Now,
+	}
+}
+
+// s3Escape percent-encodes s as required by AWS Signature Version 4: every byte except unreserved characters is encoded,
+// and / is only kept if keepSlash is true
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// url returns the URL of the object with name, with query
+func (s *S3) url(name string, query url.Values) *url.URL {
+	u := &url.URL{Scheme: "https", Host: s.Bucket + ".s3." + s.Region + ".amazonaws.com", Path: "/" + name}
+	if s.Endpoint != "" {
+		if e, err := url.Parse(s.Endpoint); err == nil {
+			u.Scheme, u.Host = e.Scheme, e.Host
+			u.Path = strings.TrimSuffix(e.Path, "/") + "/" + s.Bucket + "/" + name
+		}
+	}
+	u.RawPath = s3Escape(u.Path, true)
+	u.RawQuery = query.Encode()
+	return u
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// sign adds the AWS Signature Version 4 Authorization header to r for the hex encoded SHA-256 hash of its payload.
+// Every header already set on r is signed
+func (s *S3) sign(r *http.Request, payloadHash string) {
+	t := s.now().UTC()
+	date := t.Format("20060102")
+	r.Header.Set("X-Amz-Date", t.Format("20060102T150405Z"))
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": r.URL.Host}
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	query := r.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+
+	canonical := strings.Join([]string{
+		r.Method, r.URL.EscapedPath(), strings.Join(params, "&"), canonicalHeaders.String(), signed, payloadHash,
+	}, "\n")
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + r.Header.Get("X-Amz-Date") + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	for _, part := range []string{s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+// do sends a signed request with body, retrying failures, and returns the response with its body read into v if v is set.
+// body is read from its start for each try
+func (s *S3) do(ctx context.Context, method string, u *url.URL, headers map[string]string, body io.ReadSeeker, size int64,
+	payloadHash string, v interface{}) (*http.Response, error) {
+	var resp *http.Response
+	err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		var r io.Reader
+		if body != nil && size > 0 {
+			if _, err := body.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("could not seek body: %w", err)
+			}
+			r = io.NopCloser(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+		if err != nil {
+			return fmt.Errorf("could not create request: %w", err)
+		}
+		req.URL = u
+		req.ContentLength = size
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		s.sign(req, payloadHash)
+
+		if resp, err = s.client.Do(req); err != nil {
+			return fmt.Errorf("could not complete request: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			sErr := &s3Error{Status: resp.StatusCode}
+			if err = xml.NewDecoder(resp.Body).Decode(sErr); err != nil {
+				sErr.Message = resp.Status
+			}
+			return sErr
+		}
+		if v != nil {
+			if err = xml.NewDecoder(resp.Body).Decode(v); err != nil {
+				return fmt.Errorf("could not decode response: %w", err)
+			}
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// emptySHA256 is the hex encoded SHA-256 hash of an empty payload
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// md5Section returns the MD5 checksum of r
+func md5Section(r io.Reader) ([]byte, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// String returns the bucket's s3:// URL
+func (s *S3) String() string {
+	return "s3://" + path.Join(s.Bucket, s.Prefix)
+}
+
+// Upload uploads the file at local to the bucket as name, prefixed with s.Prefix, unless an object with the same name and MD5
+// checksum already exists. It returns false if the upload was skipped
+func (s *S3) Upload(ctx context.Context, local, name string) (bool, error) {
+	f, err := os.Open(local)
+	if err != nil {
+		return false, fmt.Errorf("could not open file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, fmt.Errorf("could not stat file: %w", err)
+	}
+	sum, err := md5Section(f)
+	if err != nil {
+		return false, fmt.Errorf("could not hash file: %w", err)
+	}
+	md5Hex := hex.EncodeToString(sum)
+
+	name = path.Join(s.Prefix, name)
+	u := s.url(name, nil)
+	resp, err := s.do(ctx, http.MethodHead, u, nil, nil, 0, emptySHA256, nil)
+	var sErr *s3Error
+	switch {
+	case err == nil && resp.Header.Get(s3MD5Header) == md5Hex:
+		return false, nil
+	case err != nil && !(errors.As(err, &sErr) && sErr.Status == http.StatusNotFound):
+		return false, fmt.Errorf("could not check object: %w", err)
+	}
+
+	if info.Size() <= s.partSize {
+		_, err = s.do(ctx, http.MethodPut, u, map[string]string{
+			"Content-Md5": base64.StdEncoding.EncodeToString(sum),
+			s3MD5Header:   md5Hex,
+		}, f, info.Size(), s3UnsignedPayload, nil)
+		if err != nil {
+			return false, fmt.Errorf("could not upload object: %w", err)
+		}
+		return true, nil
+	}
+	return true, s.uploadParts(ctx, name, f, info.Size(), md5Hex)
+}
+
+// uploadParts uploads f as the object with name in parts with the multipart upload API. The upload is aborted if a part fails
+func (s *S3) uploadParts(ctx context.Context, name string, f *os.File, size int64, md5Hex string) error {
+	var start struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if _, err := s.do(ctx, http.MethodPost, s.url(name, url.Values{"uploads": {""}}), map[string]string{s3MD5Header: md5Hex}, nil, 0,
+		emptySHA256, &start); err != nil {
+		return fmt.Errorf("could not start multipart upload: %w", err)
+	}
+
+	type part struct {
+		Number int    `xml:"PartNumber"`
+		ETag   string `xml:"ETag"`
+	}
+	var parts []part
+	for n, offset := 1, int64(0); offset < size; n, offset = n+1, offset+s.partSize {
+		partSize := s.partSize
+		if offset+partSize > size {
+			partSize = size - offset
+		}
+		section := io.NewSectionReader(f, offset, partSize)
+		sum, err := md5Section(section)
+		if err != nil {
+			return fmt.Errorf("could not hash part %d: %w", n, err)
+		}
+		resp, err := s.do(ctx, http.MethodPut, s.url(name, url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {start.UploadID}}),
+			map[string]string{"Content-Md5": base64.StdEncoding.EncodeToString(sum)}, section, partSize, s3UnsignedPayload, nil)
+		if err != nil {
+			s.abort(name, start.UploadID)
+			return fmt.Errorf("could not upload part %d: %w", n, err)
+		}
+		parts = append(parts, part{Number: n, ETag: resp.Header.Get("ETag")})
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return fmt.Errorf("could not encode parts: %w", err)
+	}
+	payload := sha256.Sum256(body)
+	if _, err = s.do(ctx, http.MethodPost, s.url(name, url.Values{"uploadId": {start.UploadID}}), nil, bytes.NewReader(body),
+		int64(len(body)), hex.EncodeToString(payload[:]), nil); err != nil {
+		s.abort(name, start.UploadID)
+		return fmt.Errorf("could not complete multipart upload: %w", err)
+	}
+	return nil
+}
+
+// abort aborts the multipart upload with id of the object with name, so its parts aren't stored. It's run even if the upload's
+// context is canceled
+func (s *S3) abort(name, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), copyCleanupTimeout)
+	defer cancel()
+	s.do(ctx, http.MethodDelete, s.url(name, url.Values{"uploadId": {id}}), nil, nil, 0, emptySHA256, nil)
+}
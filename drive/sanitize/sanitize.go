@@ -0,0 +1,26 @@
+// Package sanitize makes Google Drive file names valid local path names
+package sanitize
+
+import "regexp"
+
+// Invalid matches characters that aren't valid in path names
+var Invalid = regexp.MustCompile("[^a-zA-Z0-9 !@#$%^&()\\-_=+\\[\\]{}';\\.,`~]")
+
+// Empty replaces names that have no valid characters, or are only . or .., so they aren't written to their parent's path
+const Empty = "_"
+
+// Name returns name with invalid characters removed. The result is never empty, . or .., and never contains a path separator,
+// so it's always a single path element. Different names may give the same result, so callers must detect collisions, and
+// record the original name, e.g. in the manifest, to map the path back to it
+func Name(name string) string {
+	switch name = Invalid.ReplaceAllString(name, ""); name {
+	case "", ".", "..":
+		return Empty
+	}
+	return name
+}
+
+// Valid returns true if name is already a valid path name, i.e. Name doesn't change it
+func Valid(name string) bool {
+	return Name(name) == name
+}
@@ -0,0 +1,124 @@
+//go:build go1.18
+
+package sanitize
+
+import (
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func FuzzName(f *testing.F) {
+	for _, name := range []string{"", ".", "..", "...", "a/b", "..\\..", "report.docx", "a\x00b", "/", "日本語", " . "} {
+		f.Add(name)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		n := Name(name)
+		switch n {
+		case "", ".", "..":
+			t.Fatalf("Name(%q) = %q", name, n)
+		}
+		if strings.ContainsAny(n, "/\\\x00") {
+			t.Fatalf("Name(%q) = %q contains a path separator or NUL", name, n)
+		}
+		if filepath.Base(n) != n {
+			t.Fatalf("Name(%q) = %q isn't a single path element", name, n)
+		}
+		if !Valid(n) {
+			t.Fatalf("Name(%q) = %q isn't valid", name, n)
+		}
+	})
+}
+
+// randomName returns a random sanitized name, sometimes longer than MaxName
+func randomName(r *rand.Rand) string {
+	const chars = "abcXYZ019 .-_~()"
+	n := 1 + r.Intn(40)
+	if r.Intn(4) == 0 {
+		n = MaxName + r.Intn(100)
+	}
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = chars[r.Intn(len(chars))]
+	}
+	if r.Intn(3) == 0 {
+		return Name(string(b)) + ".docx"
+	}
+	return Name(string(b))
+}
+
+// checkSMBName fails t if name isn't valid on SMB shares
+func checkSMBName(t *testing.T, name string) {
+	t.Helper()
+	switch {
+	case name == "":
+		t.Fatal("empty path element")
+	case len(name) > MaxName-Reserve:
+		t.Fatalf("path element %q is %d bytes long", name, len(name))
+	case strings.HasSuffix(name, ".") || strings.HasSuffix(name, " "):
+		t.Fatalf("path element %q ends with a dot or space", name)
+	case reserved.MatchString(name):
+		t.Fatalf("path element %q is reserved", name)
+	}
+}
+
+func TestSMBPath(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	dest := filepath.Join("mnt", "archive", "user@example.com")
+	for i := 0; i < 10000; i++ {
+		parts := make([]string, 1+r.Intn(8))
+		for j := range parts {
+			parts[j] = randomName(r)
+		}
+		path := filepath.Join(parts...)
+		maxPath := 0
+		if r.Intn(4) != 0 {
+			maxPath = 100 + r.Intn(300)
+		}
+
+		p, ok := SMBPath(dest, path, maxPath)
+		elems := strings.Split(p, string(filepath.Separator))
+		if len(elems) != len(parts) {
+			t.Fatalf("SMBPath(%q) = %q has %d elements, expected %d", path, p, len(elems), len(parts))
+		}
+		for _, e := range elems {
+			checkSMBName(t, e)
+		}
+		if !ok {
+			if maxPath <= 0 {
+				t.Fatalf("SMBPath(%q) without a maximum length failed", path)
+			}
+			continue
+		}
+		if maxPath > 0 && len(filepath.Join(dest, p))+Reserve > maxPath {
+			t.Fatalf("SMBPath(%q, %d) = %q is %d bytes long with the reserve", path, maxPath, p, len(filepath.Join(dest, p))+Reserve)
+		}
+		// only the file name is shortened
+		for j := range elems[:len(elems)-1] {
+			if elems[j] != SMBName(parts[j]) {
+				t.Fatalf("SMBPath(%q) changed folder %q to %q", path, parts[j], elems[j])
+			}
+		}
+		// shortened names are unique
+		if q, _ := SMBPath(dest, filepath.Join(append(parts[:len(parts)-1:len(parts)-1], parts[len(parts)-1]+"x")...), maxPath); q == p {
+			t.Fatalf("SMBPath gave %q for different paths", p)
+		}
+	}
+}
+
+func TestSMBName(t *testing.T) {
+	for name, want := range map[string]string{
+		"report.":  "report",
+		"report. ": "report",
+		"...":      Empty,
+		"CON":      "_CON",
+		"nul.txt":  "_nul.txt",
+		"console":  "console",
+		"a":        "a",
+	} {
+		if got := SMBName(name); got != want {
+			t.Errorf("SMBName(%q) = %q, expected %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package sanitize
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// MaxName is the maximum length of a file name on SMB shares
+const MaxName = 255
+
+// Reserve is the path length reserved for _N suffixes added to duplicate paths and temporary file extensions
+const Reserve = 16
+
+// reserved matches names reserved by Windows, which SMB servers may refuse
+var reserved = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[0-9]|lpt[0-9])(\..*)?$`)
+
+// Shorten returns name shortened to at most n bytes, keeping its extension and adding a hash of the full name so shortened names stay unique
+func Shorten(name string, n int) string {
+	sum := sha1.Sum([]byte(name))
+	hash := "~" + hex.EncodeToString(sum[:])[:8]
+
+	ext := filepath.Ext(name)
+	if len(ext) > Reserve {
+		ext = ""
+	}
+	keep := n - len(ext) - len(hash)
+	if keep < 1 {
+		return hash[1:]
+	}
+	return name[:keep] + hash + ext
+}
+
+// SMBName returns name made valid for SMB shares: trailing dots and spaces are removed, reserved names are prefixed with _,
+// and long names are shortened
+func SMBName(name string) string {
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		name = Empty
+	}
+	if reserved.MatchString(name) {
+		name = "_" + name
+	}
+	if len(name) > MaxName-Reserve {
+		name = Shorten(name, MaxName-Reserve)
+	}
+	return name
+}
+
+// SMBPath returns path, which will be written in dest, with each element made valid for SMB shares. If maxPath is positive
+// and the full path is too long, the file name is shortened. ok is false if the path can't be shortened enough
+func SMBPath(dest, path string, maxPath int) (p string, ok bool) {
+	parts := strings.Split(path, string(filepath.Separator))
+	for i, part := range parts {
+		parts[i] = SMBName(part)
+	}
+	p = filepath.Join(parts...)
+
+	if maxPath <= 0 {
+		return p, true
+	}
+	over := len(filepath.Join(dest, p)) + Reserve - maxPath
+	if over <= 0 {
+		return p, true
+	}
+
+	name := parts[len(parts)-1]
+	if len(name)-over < len(filepath.Ext(name))+9+1 {
+		return p, false
+	}
+	parts[len(parts)-1] = Shorten(name, len(name)-over)
+	return filepath.Join(parts...), true
+}
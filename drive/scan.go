@@ -0,0 +1,154 @@
+package drive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Scanner scans files for malware before they are committed to the archive
+type Scanner interface {
+	// Scan returns the detected signature if the file at path is infected, or an empty string if it's clean
+	Scan(path string) (signature string, err error)
+}
+
+// InfectedError is returned when a downloaded file is flagged by a Scanner
+type InfectedError struct {
+	Signature string
+	// Quarantine is the path the infected file was moved to. If empty, the file was removed
+	Quarantine string
+}
+
+func (e *InfectedError) Error() string {
+	if e.Quarantine == "" {
+		return fmt.Sprintf("infected with %s: removed", e.Signature)
+	}
+	return fmt.Sprintf("infected with %s: quarantined to %s", e.Signature, e.Quarantine)
+}
+
+// clamdChunkSize is the size of chunks sent to clamd. It must be smaller than clamd's StreamMaxLength
+const clamdChunkSize = 64 * 1024
+
+// DefaultClamdTimeout is the default maximum time allowed for a single scan, so a hung clamd doesn't block downloads forever
+const DefaultClamdTimeout = 5 * time.Minute
+
+// ClamdScanner scans files with a clamd daemon using the INSTREAM command
+type ClamdScanner struct {
+	// Network is "tcp" or "unix"
+	Network string
+	// Address is the host:port or socket path of the daemon
+	Address string
+	// Timeout is the maximum time allowed for a single scan. If zero, no timeout is used
+	Timeout time.Duration
+}
+
+// NewClamdScanner returns a ClamdScanner for addr, which is in the form tcp://host:port or unix:///path/to/socket, with DefaultClamdTimeout
+func NewClamdScanner(addr string) (*ClamdScanner, error) {
+	parts := strings.SplitN(addr, "://", 2)
+	if len(parts) != 2 || (parts[0] != "tcp" && parts[0] != "unix") {
+		return nil, fmt.Errorf("invalid clamd address %s: must start with tcp:// or unix://", addr)
+	}
+	return &ClamdScanner{Network: parts[0], Address: parts[1], Timeout: DefaultClamdTimeout}, nil
+}
+
+// Scan implements the Scanner interface
+func (c *ClamdScanner) Scan(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("could not open file: %w", err)
+	}
+	defer f.Close()
+
+	conn, err := net.DialTimeout(c.Network, c.Address, c.Timeout)
+	if err != nil {
+		return "", fmt.Errorf("could not connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	if c.Timeout > 0 {
+		if err = conn.SetDeadline(time.Now().Add(c.Timeout)); err != nil {
+			return "", fmt.Errorf("could not set deadline: %w", err)
+		}
+	}
+
+	if _, err = conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", fmt.Errorf("could not write command: %w", err)
+	}
+
+	// stream file as length-prefixed chunks, terminated by a zero length chunk
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return "", fmt.Errorf("could not write chunk size: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return "", fmt.Errorf("could not write chunk: %w", err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not read file: %w", err)
+		}
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err = conn.Write(size); err != nil {
+		return "", fmt.Errorf("could not write end of stream: %w", err)
+	}
+
+	resp, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("could not read response: %w", err)
+	}
+	result := string(bytes.TrimRight(resp, "\x00\n"))
+	result = strings.TrimPrefix(result, "stream: ")
+
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd error: %s", result)
+}
+
+// scan scans the file at tmp, which will be committed to path, with the Service's Scanner.
+// If the file is infected, it's moved to the quarantine directory (or removed) and an *InfectedError is returned
+func (s *Service) scan(f *drive.File, tmp, path string) error {
+	sig, err := s.Scanner.Scan(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("could not scan file: %w", err)
+	}
+
+	if sig == "" {
+		return nil
+	}
+
+	if s.Quarantine == "" {
+		if err = os.Remove(tmp); err != nil {
+			return fmt.Errorf("could not remove infected file (%s): %w", sig, err)
+		}
+		return &InfectedError{Signature: sig}
+	}
+
+	qpath := filepath.Join(s.Quarantine, f.Id+"_"+filepath.Base(path))
+	if err = os.Rename(tmp, qpath); err != nil {
+		return fmt.Errorf("could not quarantine infected file (%s): %w", sig, err)
+	}
+	return &InfectedError{Signature: sig, Quarantine: qpath}
+}
@@ -0,0 +1,100 @@
+package drive
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/googleapi"
+)
+
+// sha256Field is the Drive file field with the file's hex encoded SHA-256 checksum. The Drive API client doesn't have the field,
+// so it's decoded from responses by sha256Transport
+const sha256Field = "sha256Checksum"
+
+// sha256Sums are Drive's SHA-256 checksums of files, keyed by file ID
+type sha256Sums struct {
+	mu sync.Mutex
+	m  map[string]string
+}
+
+func (s *sha256Sums) set(id, sum string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[string]string)
+	}
+	s.m[id] = sum
+}
+
+// get returns the checksum of the file with id, or an empty string if Drive has none, e.g. for Google files, or it wasn't listed
+func (s *sha256Sums) get(id string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.m[id]
+}
+
+// withSHA256 returns fields with the extra fields and the SHA-256 checksum field, with prefix, added
+func (s *Service) withSHA256(fields []googleapi.Field, prefix string) []googleapi.Field {
+	fields = s.withExtra(fields, prefix)
+	if s.PreferSHA256 {
+		return fields
+	}
+	// fields may be a package variable, so it's never appended to in place
+	return append(fields[:len(fields):len(fields)], googleapi.Field(prefix+sha256Field))
+}
+
+// sha256Entry is the part of a Drive file decoded by sha256Transport
+type sha256Entry struct {
+	ID     string `json:"id"`
+	SHA256 string `json:"sha256Checksum"`
+}
+
+// sha256Transport records the SHA-256 checksums of the files in responses that request them, so they're listed with the files
+// instead of requested separately for each file
+type sha256Transport struct {
+	svc  *Service
+	base http.RoundTripper
+}
+
+func (t *sha256Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusOK || !strings.Contains(r.URL.Query().Get("fields"), sha256Field) {
+		return resp, err
+	}
+
+	buf, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(buf))
+
+	// files are returned by files.get, files.list, and changes.list
+	var body struct {
+		sha256Entry
+		Files   []*sha256Entry `json:"files"`
+		Changes []struct {
+			File *sha256Entry `json:"file"`
+		} `json:"changes"`
+	}
+	if err = json.Unmarshal(buf, &body); err != nil {
+		// the Drive API client reports the error
+		return resp, nil
+	}
+	files := append(body.Files, &body.sha256Entry)
+	for _, c := range body.Changes {
+		if c.File != nil {
+			files = append(files, c.File)
+		}
+	}
+	for _, f := range files {
+		if f.ID != "" && f.SHA256 != "" {
+			t.svc.sha256s.set(f.ID, f.SHA256)
+		}
+	}
+	return resp, nil
+}
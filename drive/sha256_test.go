@@ -0,0 +1,129 @@
+package drive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// withListedSHA256 wraps a fake Drive handler to add the sha256Checksum returned by sum to listed files when it's requested.
+// Files with an empty sum have none
+func withListedSHA256(t *testing.T, sum func(id string) string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/files" || !strings.Contains(r.URL.Query().Get("fields"), sha256Field) {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			var list map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+				t.Error(err)
+			}
+			for _, f := range list["files"].([]interface{}) {
+				f := f.(map[string]interface{})
+				if s := sum(f["id"].(string)); f["mimeType"] != FileTypeFolder && s != "" {
+					f[sha256Field] = s
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(list)
+		})
+	}
+}
+
+func TestPreferSHA256Listed(t *testing.T) {
+	opts := &BenchmarkOptions{Files: 20, Folders: 2, Size: 1024}
+	sum := sha256.Sum256(syntheticContent(opts.Size))
+	want := hex.EncodeToString(sum[:])
+
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	listed := withListedSHA256(t, func(string) string { return want })
+	svc, _ := newFakeRun(t, opts, func(h http.Handler) http.Handler {
+		h = listed(h)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requests = append(requests, r.URL.Path+"?alt="+r.URL.Query().Get("alt"))
+			mu.Unlock()
+			h.ServeHTTP(w, r)
+		})
+	})
+	svc.client.Transport = &sha256Transport{svc: svc, base: svc.client.Transport}
+	svc.PreferSHA256 = true
+
+	list, err := svc.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, _ := NewTree("root", list)
+	out := t.TempDir()
+	m := NewManifest("test", out, time.Now())
+	if err = svc.DownloadTree(context.Background(), tree, out, &DownloadOptions{Downloaders: 4, Manifest: m, Stats: new(Stats)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Files) != opts.Files {
+		t.Fatalf("expected %d entries, got %d", opts.Files, len(m.Files))
+	}
+	for _, e := range m.Files {
+		if e.SHA256 != want {
+			t.Errorf("%s: expected Drive's sha256 %s in the manifest, got %q", e.Path, want, e.SHA256)
+		}
+	}
+
+	// each file is only requested to download it
+	for _, r := range requests {
+		if strings.HasPrefix(r, "/files/") && !strings.HasSuffix(r, "?alt=media") {
+			t.Errorf("unexpected request %s", r)
+		}
+	}
+}
+
+func TestVerifyRemoteSHA256(t *testing.T) {
+	opts := &BenchmarkOptions{Files: 10, Folders: 1, Size: 1024}
+	sum := sha256.Sum256(syntheticContent(opts.Size))
+	want := hex.EncodeToString(sum[:])
+
+	// file-3 changed in Drive without a new revision or modified time, and Drive has no md5 checksums to compare
+	svc, _ := newFakeRun(t, opts, withListedSHA256(t, func(id string) string {
+		if id == "file-3" {
+			return strings.Repeat("0", len(want))
+		}
+		return want
+	}))
+	svc.client.Transport = &sha256Transport{svc: svc, base: svc.client.Transport}
+
+	m := NewManifest("test", t.TempDir(), time.Now())
+	for _, f := range SyntheticFiles(opts) {
+		if f.MimeType == FileTypeFolder {
+			continue
+		}
+		m.Files = append(m.Files, &ManifestEntry{
+			ID: f.Id, Path: "My Drive/" + f.Name, MimeType: f.MimeType, ModifiedTime: f.ModifiedTime, HeadRevisionID: f.HeadRevisionId,
+			SHA256: want, Status: StatusDownloaded,
+		})
+	}
+
+	r, err := svc.VerifyRemote(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Failures) != 1 || r.Failures[0].Path != "My Drive/File 3.bin" || r.Failures[0].Kind != VerifyStale {
+		t.Fatalf("expected only File 3.bin to be stale, got %d failures", len(r.Failures))
+	}
+	if r.Checked != opts.Files {
+		t.Errorf("expected %d checked files, got %d", opts.Files, r.Checked)
+	}
+}
@@ -0,0 +1,57 @@
+package drive
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// shardName returns the name of the shard directory for name
+func shardName(name string) string {
+	r := []rune(strings.ToLower(name))
+	if len(r) > 2 {
+		r = r[:2]
+	}
+	return string(r)
+}
+
+// shards tracks the number of children of each directory in a tree, by path, so large directories can be sharded
+type shards struct {
+	threshold int
+	children  map[string]int
+}
+
+func newShards(threshold int) *shards {
+	return &shards{threshold: threshold, children: make(map[string]int)}
+}
+
+// add records the number of children of the folder at path
+func (s *shards) add(path string, children int) {
+	s.children[path] = children
+}
+
+// path returns path with the children of directories with more than threshold children moved into
+// subdirectories named by the first two characters of their names
+func (s *shards) path(path string) string {
+	if s == nil {
+		return path
+	}
+	parts := strings.Split(path, string(filepath.Separator))
+	out, orig := parts[0], parts[0]
+	for _, part := range parts[1:] {
+		if s.children[orig] > s.threshold {
+			out = filepath.Join(out, shardName(part))
+		}
+		out = filepath.Join(out, part)
+		orig = filepath.Join(orig, part)
+	}
+	return out
+}
+
+// flat returns name, which is in a flat directory, moved into a subdirectory named by its first two characters
+// if the directory has more than threshold files
+func (s *shards) flat(name string) string {
+	if s == nil || s.children["."] <= s.threshold {
+		return name
+	}
+	return filepath.Join(shardName(name), name)
+}
@@ -0,0 +1,96 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// SharedDrives returns the shared drives the user is a member of and can edit.
+// If readOnly is true, shared drives where the user only has the reader or commenter role are also returned
+func (s *Service) SharedDrives(ctx context.Context, readOnly bool) ([]*drive.Drive, error) {
+	var drives []*drive.Drive
+	cmd := s.drives.List().
+		Fields("nextPageToken", "drives/id", "drives/name", "drives/capabilities").
+		PageSize(100)
+
+	var (
+		resp *drive.DriveList
+		err  error
+	)
+	for {
+		if err = retry(ctx, s.initialBackoff, s.tries, func() error {
+			resp, err = cmd.Context(ctx).Do()
+			if err != nil {
+				return fmt.Errorf("could not list shared drives: %w", err)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		for _, d := range resp.Drives {
+			if readOnly || (d.Capabilities != nil && d.Capabilities.CanEdit) {
+				drives = append(drives, d)
+			}
+		}
+		if resp.NextPageToken == "" {
+			return drives, nil
+		}
+		cmd.PageToken(resp.NextPageToken)
+	}
+}
+
+// ListSharedDrive returns all files, including trashed files, in the shared drive with driveID
+func (s *Service) ListSharedDrive(ctx context.Context, driveID string) ([]*drive.File, error) {
+	return s.list(ctx, s.FilesService.List().
+		Corpora("drive").
+		DriveId(driveID).
+		IncludeItemsFromAllDrives(true).
+		SupportsAllDrives(true).
+		Fields(s.withExtra(listFields, "files/")...).
+		PageSize(1000))
+}
+
+// NewSharedDriveTree parses a list of files from a shared drive and returns a tree rooted at the shared drive.
+// Trashed files are placed under a Trash folder and files with missing parents are placed under a Lost+Found folder
+func NewSharedDriveTree(d *drive.Drive, list []*drive.File) *File {
+	trashID := d.Id + "/trash"
+
+	trashed := make(map[string]bool)
+	for _, f := range list {
+		if f.Trashed {
+			trashed[f.Id] = true
+		}
+	}
+
+	// move top-level trashed files (whose parents aren't also trashed) to trash folder
+	files := make([]*drive.File, 0, len(list)+1)
+	files = append(files, &drive.File{Id: trashID, Name: "Trash", MimeType: FileTypeFolder, Parents: []string{d.Id}})
+	for _, f := range list {
+		if f.Trashed {
+			inTrash := false
+			for _, p := range f.Parents {
+				if trashed[p] {
+					inTrash = true
+				}
+			}
+			if !inTrash {
+				c := *f
+				c.Parents = []string{trashID}
+				f = &c
+			}
+		}
+		files = append(files, f)
+	}
+
+	root, orphans := NewTree(d.Id, files)
+	root.Name = d.Name
+
+	orphans.ID = d.Id + "/lost+found"
+	orphans.Name = "Lost+Found"
+	orphans.Parents = []*File{root}
+	root.Files = append(root.Files, orphans)
+
+	return root
+}
@@ -0,0 +1,165 @@
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/korylprince/drive-archive/drive/sanitize"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// shortcutBatchSize is the number of concurrent requests used to fetch shortcut targets
+const shortcutBatchSize = 10
+
+// getFields are the fields requested for each file when getting a single file
+var getFields = func() []googleapi.Field {
+	fields := make([]googleapi.Field, 0, len(listFields))
+	for _, f := range listFields {
+		if strings.HasPrefix(string(f), "files/") {
+			fields = append(fields, googleapi.Field(strings.TrimPrefix(string(f), "files/")))
+		}
+	}
+	return fields
+}()
+
+// get returns the file with id
+func (s *Service) get(ctx context.Context, id string) (*drive.File, error) {
+	return s.getFile(ctx, id, s.withExtra(getFields, ""))
+}
+
+// getFile returns the file with id with fields
+func (s *Service) getFile(ctx context.Context, id string, fields []googleapi.Field) (*drive.File, error) {
+	var file *drive.File
+	if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		var err error
+		file, err = s.FilesService.Get(id).SupportsAllDrives(true).Fields(fields...).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("could not get file: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// listChildren returns the files in the folder with id
+func (s *Service) listChildren(ctx context.Context, id string) ([]*drive.File, error) {
+	return s.list(ctx, s.FilesService.List().
+		Q(fmt.Sprintf("'%s' in parents and trashed = false", id)).
+		Corpora("allDrives").
+		IncludeItemsFromAllDrives(true).
+		SupportsAllDrives(true).
+		Fields(s.withExtra(listFields, "files/")...).
+		PageSize(1000))
+}
+
+// shortcutResolver fetches the targets of shortcuts that couldn't be resolved from a listing
+type shortcutResolver struct {
+	s     *Service
+	mu    sync.Mutex
+	nodes map[string]*File
+}
+
+// node returns the tree node for f, creating it and the tree of its children if f is a folder
+func (r *shortcutResolver) node(ctx context.Context, f *drive.File) (*File, error) {
+	r.mu.Lock()
+	if n, ok := r.nodes[f.Id]; ok {
+		r.mu.Unlock()
+		return n, nil
+	}
+	n := &File{ID: f.Id, Name: f.Name, File: f}
+	r.nodes[f.Id] = n
+	r.mu.Unlock()
+
+	if !n.IsFolder() {
+		return n, nil
+	}
+
+	children, err := r.s.listChildren(ctx, f.Id)
+	if err != nil {
+		return nil, err
+	}
+	n.Files = make([]*File, 0, len(children))
+	for _, c := range children {
+		cn, err := r.node(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		cn.Parents = append(cn.Parents, n)
+		n.Files = append(n.Files, cn)
+	}
+	sort.SliceStable(n.Files, func(i, j int) bool {
+		ni := sanitize.Name(n.Files[i].Name)
+		nj := sanitize.Name(n.Files[j].Name)
+		if ni == nj {
+			return n.Files[i].ID < n.Files[j].ID
+		}
+		return ni < nj
+	})
+
+	return n, nil
+}
+
+// ResolveShortcuts fetches the targets of shortcuts in trees that couldn't be resolved from the listing, e.g. files in shared drives
+// the user isn't a member of, and links the shortcuts to them. Targets are fetched concurrently. Shortcuts to targets the user can't access
+// are left unresolved. ResolveShortcuts returns the number of shortcuts resolved
+func (s *Service) ResolveShortcuts(ctx context.Context, trees ...*File) (int, error) {
+	r := &shortcutResolver{s: s, nodes: make(map[string]*File)}
+	// map target ids to unresolved shortcuts
+	targets := make(map[string][]*File)
+	for _, tree := range trees {
+		tree.Walk(func(path string, f *File) error {
+			if f.File.MimeType == FileTypeShortcut && f.ShortcutTarget == nil && f.File.ShortcutDetails != nil {
+				targets[f.File.ShortcutDetails.TargetId] = append(targets[f.File.ShortcutDetails.TargetId], f)
+			}
+			return nil
+		})
+	}
+
+	var (
+		mu       sync.Mutex
+		resolved int
+	)
+	eg, ctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, shortcutBatchSize)
+	for id, shortcuts := range targets {
+		id, shortcuts := id, shortcuts
+		eg.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			f, err := s.get(ctx, id)
+			if err != nil {
+				var gErr *googleapi.Error
+				if errors.As(err, &gErr) && (gErr.Code == 403 || gErr.Code == 404) {
+					s.warnf("%s: could not resolve shortcut target %s: %v\n", s.logPath(shortcuts[0].Name), id, err)
+					return nil
+				}
+				return err
+			}
+
+			n, err := r.node(ctx, f)
+			if err != nil {
+				return fmt.Errorf("could not get shortcut target %s: %w", id, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, sc := range shortcuts {
+				sc.ShortcutTarget = n
+				resolved++
+			}
+			return nil
+		})
+	}
+
+	err := eg.Wait()
+	return resolved, err
+}
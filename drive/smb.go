@@ -0,0 +1,34 @@
+package drive
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// smbTransient are errors caused by temporary share disconnects
+var smbTransient = []error{syscall.EIO, syscall.ESTALE, syscall.EHOSTDOWN, syscall.ENOTCONN, syscall.ECONNRESET, syscall.ETIMEDOUT}
+
+// isSMBTransient returns true if err may be caused by a temporary share disconnect
+func isSMBTransient(err error) bool {
+	for _, e := range smbTransient {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// smbRetry retries f with exponential backoff while it fails with errors caused by temporary share disconnects
+func smbRetry(start time.Duration, maxTries int, f func() error) error {
+	tries := 0
+	for {
+		err := f()
+		tries++
+		if err == nil || tries == maxTries || !isSMBTransient(err) {
+			return err
+		}
+		time.Sleep(start)
+		start *= 2
+	}
+}
@@ -0,0 +1,122 @@
+package drive
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/korylprince/drive-archive/drive/sanitize"
+)
+
+// VolumeName returns the directory name of volume n
+func VolumeName(n int) string {
+	return fmt.Sprintf("vol%03d", n)
+}
+
+// VolumePlan splits an archive into numbered volumes of at most MaxSize bytes, keeping folders in a single volume where possible.
+// Sizes are taken from Drive, so exported Google files (which have no size) are counted as empty
+type VolumePlan struct {
+	// Root is the path volumes are created in
+	Root    string
+	MaxSize int64
+
+	assigned  map[string]int
+	volume    int
+	remaining int64
+}
+
+// NewVolumePlan returns a new VolumePlan creating volumes of at most maxSize bytes in root
+func NewVolumePlan(root string, maxSize int64) *VolumePlan {
+	return &VolumePlan{Root: root, MaxSize: maxSize, assigned: make(map[string]int)}
+}
+
+// Volumes returns the number of volumes planned
+func (p *VolumePlan) Volumes() int {
+	return p.volume
+}
+
+// treeSize returns the total size of the files in the tree rooted at f
+func treeSize(f *File, parents map[string]bool) int64 {
+	if f.ShortcutTarget != nil {
+		f = f.ShortcutTarget
+	}
+	if !f.IsFolder() {
+		return f.File.Size
+	}
+	if parents[f.ID] {
+		return 0
+	}
+	parents[f.ID] = true
+	defer delete(parents, f.ID)
+
+	var size int64
+	for _, c := range f.Files {
+		size += treeSize(c, parents)
+	}
+	return size
+}
+
+func (p *VolumePlan) assign(f *File, path string, parents map[string]bool) {
+	if f.ShortcutTarget != nil {
+		f = f.ShortcutTarget
+	}
+	size := treeSize(f, parents)
+
+	switch {
+	case p.volume > 0 && size <= p.remaining:
+		// fits in current volume
+	case size <= p.MaxSize || !f.IsFolder():
+		// fits in a new volume, or is a single file too large for any volume
+		p.volume++
+		p.remaining = p.MaxSize
+		if size > p.remaining {
+			p.remaining = size
+		}
+	default:
+		// split folder across volumes
+		parents[f.ID] = true
+		defer delete(parents, f.ID)
+		for _, c := range f.Files {
+			p.assign(c, filepath.Join(path, sanitize.Name(c.Name)), parents)
+		}
+		return
+	}
+
+	p.assigned[path] = p.volume
+	p.remaining -= size
+}
+
+// Plan assigns the files in tree, which will be downloaded to outpath, to volumes. Plan should be called before the tree is downloaded
+func (p *VolumePlan) Plan(tree *File, outpath string) {
+	p.assign(tree, filepath.Join(outpath, sanitize.Name(tree.Name)), make(map[string]bool))
+}
+
+// volumeOf returns the volume containing path (in a tree downloaded to outpath), or 0 if path is in a folder split across volumes
+func (p *VolumePlan) volumeOf(outpath, path string) int {
+	full := filepath.Join(outpath, path)
+	for full != outpath && full != "." && full != string(filepath.Separator) {
+		if n, ok := p.assigned[full]; ok {
+			return n
+		}
+		full = filepath.Dir(full)
+	}
+	return 0
+}
+
+// Dest returns the path that should be used instead of outpath for path. If path has no volume, the first volume is used
+func (p *VolumePlan) Dest(outpath, path string) string {
+	n := p.volumeOf(outpath, path)
+	if n == 0 {
+		n = 1
+	}
+	rel, err := filepath.Rel(p.Root, outpath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = ""
+	}
+	return filepath.Join(p.Root, VolumeName(n), rel)
+}
+
+// Exists returns true if path (in a tree downloaded to outpath) is assigned to a single volume
+func (p *VolumePlan) Exists(outpath, path string) bool {
+	return p.volumeOf(outpath, path) != 0
+}
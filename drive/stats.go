@@ -0,0 +1,271 @@
+package drive
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Stats counts the results of downloads
+type Stats struct {
+	// Listed is the number of files found in downloaded trees
+	Listed int64
+	// Downloaded is the number of files downloaded
+	Downloaded int64
+	// Exported is the number of downloaded files that were Google files exported to another format
+	Exported int64
+	// Existing is the number of files skipped because the existing file matched
+	Existing int64
+	// Unsupported is the number of files skipped because they can't be downloaded, e.g. unresolved shortcuts or Google Maps
+	Unsupported int64
+	// Failed is the number of files that couldn't be downloaded
+	Failed int64
+	// Restricted is the number of failed files that couldn't be downloaded because of owner restrictions
+	Restricted int64
+	// Skipped is the number of files skipped because they were larger than the maximum size
+	Skipped int64
+	// SkippedBytes is the size of skipped files
+	SkippedBytes int64
+	// Dropped is the number of queued files that weren't downloaded because the download was stopped
+	Dropped int64
+
+	// ListedBytes is the size reported by Drive of all listed files. Exported Google files have no size
+	ListedBytes int64
+	// CapturedBytes is the size reported by Drive of all downloaded and existing files
+	CapturedBytes int64
+	// DownloadedBytes is the size reported by Drive of all downloaded files
+	DownloadedBytes int64
+
+	// ByMime breaks down download results and throughput by the files' Drive mime types
+	ByMime map[string]*Throughput
+	// ByWorker breaks down download results and throughput by downloader. Downloaders are numbered from 1 in each tree
+	ByWorker map[int]*Throughput
+
+	mu sync.Mutex
+}
+
+// Throughput is the results and time spent downloading a group of files
+type Throughput struct {
+	Downloaded int64
+	Existing   int64
+	Failed     int64
+	// Bytes is the size of the downloaded files on disk, including exported Google files
+	Bytes int64
+	// Time is the total time spent downloading, checking, or failing to download the files
+	Time time.Duration
+}
+
+// Rate returns the throughput in bytes per second
+func (t *Throughput) Rate() float64 {
+	if t.Time <= 0 {
+		return 0
+	}
+	return float64(t.Bytes) / t.Time.Seconds()
+}
+
+// ErrorRate returns the percentage of files that failed
+func (t *Throughput) ErrorRate() float64 {
+	total := t.Downloaded + t.Existing + t.Failed
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(t.Failed) / float64(total)
+}
+
+func (t *Throughput) add(downloaded, failed bool, bytes int64, d time.Duration) {
+	switch {
+	case failed:
+		t.Failed++
+	case downloaded:
+		t.Downloaded++
+		t.Bytes += bytes
+	default:
+		t.Existing++
+	}
+	t.Time += d
+}
+
+// timed records the result and time spent downloading a file with mimeType by worker
+func (s *Stats) timed(mimeType string, worker int, downloaded, failed bool, bytes int64, d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ByMime == nil {
+		s.ByMime = make(map[string]*Throughput)
+		s.ByWorker = make(map[int]*Throughput)
+	}
+	if s.ByMime[mimeType] == nil {
+		s.ByMime[mimeType] = new(Throughput)
+	}
+	if s.ByWorker[worker] == nil {
+		s.ByWorker[worker] = new(Throughput)
+	}
+	s.ByMime[mimeType].add(downloaded, failed, bytes, d)
+	s.ByWorker[worker].add(downloaded, failed, bytes, d)
+}
+
+func (s *Stats) listed(size int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Listed++
+	s.ListedBytes += size
+}
+
+func (s *Stats) unsupported() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Unsupported++
+}
+
+func (s *Stats) failed(restricted bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Failed++
+	if restricted {
+		s.Restricted++
+	}
+}
+
+func (s *Stats) skipped(size int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Skipped++
+	s.SkippedBytes += size
+}
+
+func (s *Stats) dropped() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Dropped++
+}
+
+func (s *Stats) captured(downloaded, exported bool, size int64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if downloaded {
+		s.Downloaded++
+		if exported {
+			s.Exported++
+		}
+		s.DownloadedBytes += size
+	} else {
+		s.Existing++
+	}
+	s.CapturedBytes += size
+}
+
+// Completeness returns the percentage of supported files that were captured (downloaded or already existing)
+// and the percentage of listed bytes that were captured. Skipped files aren't counted
+func (s *Stats) Completeness() (files, bytes float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, bytes = 100, 100
+	if supported := s.Listed - s.Unsupported - s.Skipped; supported > 0 {
+		files = 100 * float64(s.Downloaded+s.Existing) / float64(supported)
+	}
+	if listed := s.ListedBytes - s.SkippedBytes; listed > 0 {
+		bytes = 100 * float64(s.CapturedBytes) / float64(listed)
+	}
+	return files, bytes
+}
+
+// Breakdown returns a human readable table of download results and throughput by mime type and by downloader
+func (s *Stats) Breakdown() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := new(strings.Builder)
+	w := tabwriter.NewWriter(b, 0, 4, 2, ' ', 0)
+	row := func(name string, t *Throughput) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.2f%%\t%d\t%s\t%.0f\n", name, t.Downloaded, t.Existing, t.Failed, t.ErrorRate(), t.Bytes, t.Time.Round(time.Second), t.Rate())
+	}
+	header := "\tdownloaded\texisting\tfailed\terrors\tbytes\ttime\tbytes/s\n"
+
+	mimes := make([]string, 0, len(s.ByMime))
+	for m := range s.ByMime {
+		mimes = append(mimes, m)
+	}
+	sort.Strings(mimes)
+	fmt.Fprint(w, "mime type"+header)
+	for _, m := range mimes {
+		row(m, s.ByMime[m])
+	}
+
+	workers := make([]int, 0, len(s.ByWorker))
+	for n := range s.ByWorker {
+		workers = append(workers, n)
+	}
+	sort.Ints(workers)
+	fmt.Fprint(w, "\ndownloader"+header)
+	for _, n := range workers {
+		row(strconv.Itoa(n), s.ByWorker[n])
+	}
+
+	w.Flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// DryRunString returns a human readable summary of the Stats of a dry run
+func (s *Stats) DryRunString() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "listed: %d files (%d bytes)\n", s.Listed, s.ListedBytes)
+	fmt.Fprintf(b, "would download: %d files (%d bytes, excluding exported Google files)\n", s.Downloaded, s.DownloadedBytes)
+	fmt.Fprintf(b, "would skip: %d existing files (%d bytes), %d unsupported files", s.Existing, s.CapturedBytes-s.DownloadedBytes, s.Unsupported)
+	if s.Skipped > 0 {
+		fmt.Fprintf(b, ", %d files larger than the maximum size (%d bytes)", s.Skipped, s.SkippedBytes)
+	}
+	if s.Failed > 0 {
+		fmt.Fprintf(b, "\nwould fail: %d files", s.Failed)
+	}
+	return b.String()
+}
+
+// String returns a human readable summary of the Stats
+func (s *Stats) String() string {
+	files, bytes := s.Completeness()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "listed: %d files (%d bytes)\n", s.Listed, s.ListedBytes)
+	fmt.Fprintf(b, "captured: %d files (%d downloaded, including %d exported Google files, %d existing), %d bytes\n", s.Downloaded+s.Existing, s.Downloaded, s.Exported, s.Existing, s.CapturedBytes)
+	fmt.Fprintf(b, "unsupported: %d files\n", s.Unsupported)
+	fmt.Fprintf(b, "failed: %d files (%d restricted by owner)\n", s.Failed, s.Restricted)
+	if s.Skipped > 0 {
+		fmt.Fprintf(b, "skipped: %d files larger than the maximum size (%d bytes)\n", s.Skipped, s.SkippedBytes)
+	}
+	if s.Dropped > 0 {
+		fmt.Fprintf(b, "dropped: %d queued files not downloaded because the run was stopped\n", s.Dropped)
+	}
+	fmt.Fprintf(b, "completeness: %.2f%% of supported files, %.2f%% of bytes", files, bytes)
+	return b.String()
+}
@@ -0,0 +1,60 @@
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// GetFile returns the file with id. If the file is a shortcut, its target is returned
+func (s *Service) GetFile(ctx context.Context, id string) (*drive.File, error) {
+	f, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if f.MimeType == FileTypeShortcut && f.ShortcutDetails != nil {
+		return s.get(ctx, f.ShortcutDetails.TargetId)
+	}
+	return f, nil
+}
+
+// Stream writes the contents of f, exported as exportType if set, to w without writing to disk.
+// Files too large to export with the Drive API and restricted files can't be streamed
+func (s *Service) Stream(ctx context.Context, f *drive.File, exportType string, w io.Writer) error {
+	if _, ok := SkipTypes[f.MimeType]; ok || f.MimeType == FileTypeFolder || strings.HasPrefix(f.MimeType, FileTypeSDKPrefix) {
+		return ErrNoExportableFormat
+	}
+
+	var resp *http.Response
+	if err := retry(ctx, s.initialBackoff, s.tries, func() error {
+		var err error
+		if exportType != "" {
+			resp, err = s.FilesService.Export(f.Id, exportType).Context(ctx).Download()
+			if err != nil {
+				return fmt.Errorf("could not complete export request: %w", err)
+			}
+			return nil
+		}
+		resp, err = s.downloadRequest(ctx, f, "", 0)
+		return err
+	}); err != nil {
+		if isRestricted(err) {
+			return fmt.Errorf("%w: %v", ErrRestricted, err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, s.Throttle.Reader(resp.Body)); err != nil {
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		return fmt.Errorf("could not write file: %w", err)
+	}
+	return nil
+}
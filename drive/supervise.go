@@ -0,0 +1,149 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+)
+
+// maxDownloadAttempts is the number of times a file is downloaded before it's marked failed if downloading it panics
+const maxDownloadAttempts = 2
+
+// PanicError is a recovered panic that happened while downloading a file
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// workQueue queues downloads from the tree walker, and downloads that are retried after a panic
+type workQueue struct {
+	c chan *download
+
+	mu      sync.Mutex
+	retries []*download
+	stopped bool
+}
+
+func newWorkQueue() *workQueue {
+	return &workQueue{c: make(chan *download)}
+}
+
+// requeue queues d to be downloaded again
+func (q *workQueue) requeue(d *download) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.retries = append(q.retries, d)
+}
+
+// stop drops downloads that are still queued instead of starting them
+func (q *workQueue) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stopped = true
+}
+
+// isStopped returns true if stop has been called
+func (q *workQueue) isStopped() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stopped
+}
+
+// next returns the next download, preferring retried downloads. ok is false when the walker is finished and there are no
+// downloads left. Retried downloads are always taken by a downloader because the downloader that requeued one calls next after it's restarted
+func (q *workQueue) next() (d *download, ok bool) {
+	q.mu.Lock()
+	if n := len(q.retries); n > 0 {
+		d = q.retries[n-1]
+		q.retries = q.retries[:n-1]
+		q.mu.Unlock()
+		return d, true
+	}
+	q.mu.Unlock()
+
+	d, ok = <-q.c
+	return d, ok
+}
+
+// downloader downloads files from q until it's empty. If downloading a file panics, the panic is recovered, the file is
+// requeued (or marked failed after maxDownloadAttempts), and the downloader is restarted
+func (s *Service) downloader(ctx context.Context, worker int, outpath string, opts *DownloadOptions, dirs *dirCache, q *workQueue) {
+	for !s.work(ctx, worker, outpath, opts, dirs, q) {
+	}
+}
+
+// work downloads files from q, returning true when q is empty or false if a download panicked
+func (s *Service) work(ctx context.Context, worker int, outpath string, opts *DownloadOptions, dirs *dirCache, q *workQueue) (done bool) {
+	var current *download
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if current == nil {
+			// panicked outside of a download, so there's nothing to retry
+			panic(r)
+		}
+		opts.Control.release()
+		s.recovered(opts, q, current, &PanicError{Value: r, Stack: debug.Stack()})
+		done = false
+	}()
+
+	for {
+		d, ok := q.next()
+		if !ok {
+			return true
+		}
+		// drop queued files when stopped, canceled, or draining
+		if q.isStopped() || ctx.Err() != nil || !opts.Control.acquire(ctx) {
+			s.dropped(opts, d)
+			continue
+		}
+		current = d
+		d.attempts++
+		d.worker = worker
+		s.downloadOne(ctx, outpath, opts, dirs, d)
+		current = nil
+		opts.Control.release()
+	}
+}
+
+// dropped records that d was queued but not downloaded
+func (s *Service) dropped(opts *DownloadOptions, d *download) {
+	if d.folder {
+		return
+	}
+	opts.Stats.dropped()
+	opts.emit(EventDropped, d, 0, false, nil)
+	s.logFile(LogWarn, d, "dropped queued file", nil)
+}
+
+// recovered reports a panic that happened while downloading d, and requeues d if it hasn't been tried maxDownloadAttempts times
+func (s *Service) recovered(opts *DownloadOptions, q *workQueue, d *download, err *PanicError) {
+	s.logFile(LogError, d, fmt.Sprintf("downloader %v; restarting downloader\n%s", err, err.Stack), nil)
+	if d.attempts < maxDownloadAttempts {
+		s.logFile(LogWarn, d, "requeued file", nil)
+		opts.emit(EventQueued, d, 0, false, nil)
+		q.requeue(d)
+		return
+	}
+
+	if d.folder {
+		s.logFile(LogError, d, "could not create directory", err)
+		return
+	}
+	opts.Stats.failed(false)
+	opts.emit(EventFailed, d, 0, false, err)
+	if opts.Manifest != nil {
+		e := opts.Manifest.add(d.File.File, filepath.Join(d.Dest, d.Path), StatusFailed)
+		e.ExportType, e.Error = d.ExportType, Redact(err.Error())
+		opts.Manifest.record(e)
+	}
+	s.logFile(LogError, d, "could not download file", err)
+}
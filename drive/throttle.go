@@ -0,0 +1,93 @@
+package drive
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the maximum number of bytes read at a time by a throttled reader
+const throttleChunk = 32 * 1024
+
+// Window is a daily time window with its own bandwidth limit
+type Window struct {
+	// Start and End are offsets from local midnight. If End is before Start, the window spans midnight
+	Start time.Duration
+	End   time.Duration
+	// Rate is the limit in bytes per second. If Rate <= 0, bandwidth is unlimited during the window
+	Rate int64
+}
+
+// Contains returns true if t's local time of day is in the window
+func (w *Window) Contains(t time.Time) bool {
+	h, m, s := t.Clock()
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if w.End < w.Start {
+		return d >= w.Start || d < w.End
+	}
+	return d >= w.Start && d < w.End
+}
+
+// Throttle is a bandwidth limit shared by all downloads
+type Throttle struct {
+	// Rate is the limit in bytes per second outside of any window. If Rate <= 0, bandwidth is unlimited
+	Rate int64
+	// Windows override Rate during their time of day. The first matching window is used
+	Windows []*Window
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// RateAt returns the bandwidth limit at t
+func (t *Throttle) RateAt(now time.Time) int64 {
+	for _, w := range t.Windows {
+		if w.Contains(now) {
+			return w.Rate
+		}
+	}
+	return t.Rate
+}
+
+// wait blocks until n bytes may be transferred
+func (t *Throttle) wait(n int) {
+	now := time.Now()
+	t.mu.Lock()
+	rate := t.RateAt(now)
+	if rate <= 0 {
+		t.mu.Unlock()
+		return
+	}
+	if t.next.Before(now) {
+		t.next = now
+	}
+	delay := t.next.Sub(now)
+	t.next = t.next.Add(time.Duration(int64(n) * int64(time.Second) / rate))
+	t.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+type throttledReader struct {
+	r io.Reader
+	t *Throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.t.wait(n)
+	}
+	return n, err
+}
+
+// Reader returns a reader that reads from r at the throttled rate. If t is nil, r is returned
+func (t *Throttle) Reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &throttledReader{r: r, t: t}
+}
@@ -1,15 +1,16 @@
 package drive
 
 import (
+	"errors"
 	"path/filepath"
-	"regexp"
 	"sort"
 
+	"github.com/korylprince/drive-archive/drive/sanitize"
 	"google.golang.org/api/drive/v3"
 )
 
-// ValidPathChars is the set of valid path name characters
-var ValidPathChars = regexp.MustCompile("[^a-zA-Z0-9 !@#$%^&()\\-_=+\\[\\]{}';\\.,`~]")
+// ValidPathChars is the set of valid path name characters. File names should be made valid with sanitize.Name
+var ValidPathChars = sanitize.Invalid
 
 // File represents a Google Drive File or Folder. A Google Drive object can have multiple parents
 type File struct {
@@ -86,8 +87,8 @@ func NewTree(rootID string, list []*drive.File) (tree, orphaned *File) {
 	sortfunc := func(path string, file *File) error {
 		if file.Files != nil {
 			sort.SliceStable(file.Files, func(i, j int) bool {
-				ni := ValidPathChars.ReplaceAllString(file.Files[i].Name, "")
-				nj := ValidPathChars.ReplaceAllString(file.Files[j].Name, "")
+				ni := sanitize.Name(file.Files[i].Name)
+				nj := sanitize.Name(file.Files[j].Name)
 				if ni == nj {
 					return file.Files[i].ID < file.Files[j].ID
 				}
@@ -96,8 +97,8 @@ func NewTree(rootID string, list []*drive.File) (tree, orphaned *File) {
 		}
 		if file.Parents != nil {
 			sort.SliceStable(file.Parents, func(i, j int) bool {
-				ni := ValidPathChars.ReplaceAllString(file.Parents[i].Name, "")
-				nj := ValidPathChars.ReplaceAllString(file.Parents[j].Name, "")
+				ni := sanitize.Name(file.Parents[i].Name)
+				nj := sanitize.Name(file.Parents[j].Name)
 				if ni == nj {
 					return file.Parents[i].ID < file.Parents[j].ID
 				}
@@ -113,15 +114,25 @@ func NewTree(rootID string, list []*drive.File) (tree, orphaned *File) {
 	return root, orphans
 }
 
+// SkipFolder can be returned by a Walk function to skip the children of the current folder
+var SkipFolder = errors.New("skip this folder")
+
 // Walk walks through all of the files in the tree and calls f() on them. The current file and full path to the file is passed to f(). If f() returns an error, iteration and the error is returned.
+// If f() returns SkipFolder, the file's children aren't walked
 func (fi *File) Walk(f func(path string, file *File) error) error {
+	return fi.walk(func(path string, file, parent *File) error { return f(path, file) })
+}
+
+// walk is like Walk, but also passes the folder file was reached through to f. The parent of the root is nil
+func (fi *File) walk(f func(path string, file, parent *File) error) error {
 	type node struct {
 		f       *File
+		parent  *File
 		path    string
 		parents map[string]struct{}
 	}
 
-	q := []*node{{f: fi, path: ValidPathChars.ReplaceAllString(fi.Name, ""), parents: make(map[string]struct{})}}
+	q := []*node{{f: fi, path: sanitize.Name(fi.Name), parents: make(map[string]struct{})}}
 	for len(q) > 0 {
 		// pop file
 		n := q[0]
@@ -137,7 +148,9 @@ func (fi *File) Walk(f func(path string, file *File) error) error {
 			n.f = n.f.ShortcutTarget
 		}
 
-		if err := f(n.path, n.f); err != nil {
+		if err := f(n.path, n.f, n.parent); err == SkipFolder {
+			continue
+		} else if err != nil {
 			return err
 		}
 
@@ -146,7 +159,7 @@ func (fi *File) Walk(f func(path string, file *File) error) error {
 			for k, v := range n.parents {
 				p[k] = v
 			}
-			q = append(q, &node{f: c, path: filepath.Join(n.path, ValidPathChars.ReplaceAllString(c.Name, "")), parents: p})
+			q = append(q, &node{f: c, parent: n.f, path: filepath.Join(n.path, sanitize.Name(c.Name)), parents: p})
 		}
 
 	}
@@ -0,0 +1,60 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"golang.org/x/oauth2/google"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/option"
+)
+
+// ListUsers returns the primary emails of all users in the domain, using the service account credentials JSON file found at configPath
+// to impersonate adminUser, who must be an administrator that can read users.
+// The https://www.googleapis.com/auth/admin.directory.user.readonly scope must be added to Domain-wide Delegation
+func ListUsers(ctx context.Context, configPath, adminUser string, initialBackoff time.Duration, tries int) ([]string, error) {
+	buf, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config: %w", err)
+	}
+
+	config, err := google.JWTConfigFromJSON(buf, admin.AdminDirectoryUserReadonlyScope)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config: %w", err)
+	}
+	config.Subject = adminUser
+
+	svc, err := admin.NewService(ctx, option.WithHTTPClient(config.Client(ctx)))
+	if err != nil {
+		return nil, fmt.Errorf("could not create admin service: %w", err)
+	}
+
+	var users []string
+	cmd := svc.Users.List().
+		Customer("my_customer").
+		Fields("nextPageToken", "users/primaryEmail").
+		OrderBy("email").
+		MaxResults(500)
+
+	var resp *admin.Users
+	for {
+		if err = retry(ctx, initialBackoff, tries, func() error {
+			resp, err = cmd.Context(ctx).Do()
+			if err != nil {
+				return fmt.Errorf("could not list users: %w", err)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		for _, u := range resp.Users {
+			users = append(users, u.PrimaryEmail)
+		}
+		if resp.NextPageToken == "" {
+			return users, nil
+		}
+		cmd.PageToken(resp.NextPageToken)
+	}
+}
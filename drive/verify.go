@@ -0,0 +1,387 @@
+package drive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// verifyZ is the z-score used for the 95% confidence bound of a VerifyResult
+const verifyZ = 1.96
+
+// Verification failure kinds
+const (
+	// VerifyMissing files are in the manifest but not on disk
+	VerifyMissing = "missing"
+	// VerifyCorrupt files don't match their recorded size or checksums
+	VerifyCorrupt = "corrupt"
+	// VerifyStale files have changed in Drive since they were archived
+	VerifyStale = "stale"
+	// VerifyNotArchived files are in Drive but not in the manifest
+	VerifyNotArchived = "not archived"
+	// VerifyDeleted files were archived but have been deleted or moved to the trash in Drive
+	VerifyDeleted = "deleted"
+)
+
+// VerifyFailure is an archived file that failed verification
+type VerifyFailure struct {
+	Path   string
+	Kind   string
+	Reason string
+}
+
+// VerifyResult is the result of verifying a Manifest
+type VerifyResult struct {
+	// Total is the number of captured files in the manifest
+	Total int
+	// Checked is the number of files verified
+	Checked int
+	// Bytes is the number of bytes read while verifying
+	Bytes    int64
+	Failures []*VerifyFailure
+	// Seed is the seed used to sample files
+	Seed int64
+	// TimedOut is true if verification stopped early because the time limit was reached
+	TimedOut bool
+	// Extra are the local files, relative to the manifest's root, that aren't in the manifest. They're only found when all files are checked
+	Extra []string
+	// Links are symlinks that break or escape the archive when it's replicated or packaged. They're only found when all files are checked
+	Links []*VerifyFailure
+	// Remote is true if files were only checked against their current Drive metadata, without reading local files
+	Remote bool
+}
+
+// Confidence returns the estimated percentage of intact files in the archive, extrapolated from the checked files,
+// and the lower bound of the 95% confidence interval (Wilson score interval) of that percentage
+func (r *VerifyResult) Confidence() (estimate, lower float64) {
+	if r.Checked == 0 {
+		return 0, 0
+	}
+	if r.Checked == r.Total {
+		p := 100 * float64(r.Total-len(r.Failures)) / float64(r.Total)
+		return p, p
+	}
+
+	n := float64(r.Checked)
+	p := float64(r.Checked-len(r.Failures)) / n
+	z2 := verifyZ * verifyZ
+	center := (p + z2/(2*n)) / (1 + z2/n)
+	margin := verifyZ / (1 + z2/n) * math.Sqrt(p*(1-p)/n+z2/(4*n*n))
+
+	return 100 * p, 100 * math.Max(0, center-margin)
+}
+
+// String returns a human readable summary of the VerifyResult
+func (r *VerifyResult) String() string {
+	estimate, lower := r.Confidence()
+
+	b := new(strings.Builder)
+	if r.Remote {
+		fmt.Fprintf(b, "checked: %d of %d files against Drive metadata", r.Checked, r.Total)
+	} else {
+		fmt.Fprintf(b, "checked: %d of %d files (%d bytes), seed %d", r.Checked, r.Total, r.Bytes, r.Seed)
+	}
+	if r.TimedOut {
+		b.WriteString(", stopped at time limit")
+	}
+	fmt.Fprintf(b, "\nfailed: %d files\n", len(r.Failures))
+	for _, f := range r.Failures {
+		fmt.Fprintf(b, "\t%s: %s: %s\n", f.Path, f.Kind, f.Reason)
+	}
+	if len(r.Extra) > 0 {
+		fmt.Fprintf(b, "extra: %d files not in the manifest\n", len(r.Extra))
+		for _, p := range r.Extra {
+			fmt.Fprintf(b, "\t%s\n", p)
+		}
+	}
+	if len(r.Links) > 0 {
+		fmt.Fprintf(b, "unsafe links: %d symlinks\n", len(r.Links))
+		for _, l := range r.Links {
+			fmt.Fprintf(b, "\t%s: %s\n", l.Path, l.Reason)
+		}
+	}
+	if r.Remote {
+		// files in Drive that aren't archived aren't counted in Total
+		changed := 0
+		for _, f := range r.Failures {
+			if f.Kind != VerifyNotArchived {
+				changed++
+			}
+		}
+		unchanged := 100.0
+		if r.Total > 0 {
+			unchanged = 100 * float64(r.Total-changed) / float64(r.Total)
+		}
+		fmt.Fprintf(b, "unchanged: %.2f%% of archived files are unchanged in Drive", unchanged)
+	} else if r.Checked == r.Total {
+		fmt.Fprintf(b, "integrity: %.2f%% of files intact", estimate)
+	} else {
+		fmt.Fprintf(b, "integrity: estimated %.2f%% of files intact (95%% confidence at least %.2f%%)", estimate, lower)
+	}
+	return b.String()
+}
+
+// verifyEntry checks the local file of e against its recorded size and checksums, returning the kind and reason of the failure,
+// or empty strings if the file is intact
+func (m *Manifest) verifyEntry(e *ManifestEntry) (kind, reason string, size int64) {
+	path := m.localPath(e)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return VerifyMissing, "file does not exist", 0
+	}
+	if err != nil {
+		return VerifyCorrupt, fmt.Sprintf("could not stat file: %v", err), 0
+	}
+	if info.IsDir() {
+		// Sheets exported with the API are written as a directory of CSV files
+		return "", "", 0
+	}
+
+	// exported and converted files don't match Drive's size and checksum
+	if e.MD5Checksum != "" && e.PDFA != "converted" {
+		if info.Size() != e.Size {
+			return VerifyCorrupt, fmt.Sprintf("size is %d, expected %d", info.Size(), e.Size), info.Size()
+		}
+		if !md5Verify(path, e.MD5Checksum) {
+			return VerifyCorrupt, "md5 checksum mismatch", info.Size()
+		}
+	}
+
+	if e.SHA256 != "" {
+		sum, err := sha256File(path)
+		if err != nil {
+			return VerifyCorrupt, fmt.Sprintf("could not hash file: %v", err), info.Size()
+		}
+		if sum != e.SHA256 {
+			return VerifyCorrupt, "sha256 checksum mismatch", info.Size()
+		}
+	}
+
+	return "", "", info.Size()
+}
+
+// Verify checks the manifest's captured files against their recorded sizes and checksums. A random fraction (0-1] of the files,
+// chosen with seed, is checked so results are reproducible. If limit is positive, verification stops after limit has elapsed
+// and the files checked so far are used as the sample
+func (m *Manifest) Verify(fraction float64, seed int64, limit time.Duration) *VerifyResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := make([]*ManifestEntry, 0, len(m.Files))
+	for _, e := range m.Files {
+		if e.Captured() {
+			entries = append(entries, e)
+		}
+	}
+
+	r := &VerifyResult{Total: len(entries), Seed: seed}
+
+	// shuffle so any prefix of entries is a random sample
+	rand.New(rand.NewSource(seed)).Shuffle(len(entries), func(i, j int) { entries[i], entries[j] = entries[j], entries[i] })
+	n := int(math.Ceil(fraction * float64(len(entries))))
+	if n > len(entries) {
+		n = len(entries)
+	}
+
+	start := time.Now()
+	for _, e := range entries[:n] {
+		if limit > 0 && time.Since(start) > limit {
+			r.TimedOut = true
+			break
+		}
+		kind, reason, size := m.verifyEntry(e)
+		r.Checked++
+		r.Bytes += size
+		if kind != "" {
+			r.Failures = append(r.Failures, &VerifyFailure{Path: e.Path, Kind: kind, Reason: reason})
+		}
+	}
+
+	if r.Checked == r.Total && !r.TimedOut {
+		r.Extra = m.extra()
+		r.Links = CheckLinks(m.root)
+	}
+
+	return r
+}
+
+// generatedFiles are files written to an archive that aren't recorded in its manifest
+var generatedFiles = map[string]bool{
+	"manifest.json":      true,
+	"manifest.ndjson":    true,
+	PartialManifestName:  true,
+	JournalName:          true,
+	"mets.xml":           true,
+	"SHA256SUMS":         true,
+	"index.html":         true,
+	"archive_index.html": true,
+	"holds.csv":          true,
+	"collisions.csv":     true,
+	"errors.csv":         true,
+	"archive.car":        true,
+	KeepFile:             true,
+}
+
+// generatedSuffixes are the suffixes of sidecar files written next to archived files
+var generatedSuffixes = []string{".record.json", OCRSuffix, MediaSuffix, RevisionsSuffix}
+
+// extra returns the local files under the manifest's root that aren't in the manifest or written by the archive, e.g. sidecars.
+// m.mu must be held
+func (m *Manifest) extra() []string {
+	known := make(map[string]bool, len(m.Files))
+	for _, e := range m.Files {
+		known[filepath.Clean(m.localPath(e))] = true
+	}
+
+	var extra []string
+	filepath.Walk(m.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			// Sheets exported with the API, delta archives, the catalog, and previous revisions have their own files
+			if path != m.root && (known[path] || ((info.Name() == "delta" || info.Name() == CatalogDir) && filepath.Dir(path) == m.root) || known[strings.TrimSuffix(path, RevisionsDirSuffix)]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// symlinks are checked by CheckLinks
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		if known[path] || generatedFiles[info.Name()] || hasSuffix(info.Name(), generatedSuffixes) || hasSuffix(info.Name(), TempSuffixes) {
+			return nil
+		}
+		if rel, err := filepath.Rel(m.root, path); err == nil {
+			path = filepath.ToSlash(rel)
+		}
+		extra = append(extra, path)
+		return nil
+	})
+	return extra
+}
+
+// VerifyDrive compares the manifest to files, the current listing of the user's Drive, returning the archived files that have changed
+// in Drive since they were archived and the downloadable files that aren't in the manifest
+func (m *Manifest) VerifyDrive(files []*drive.File) []*VerifyFailure {
+	return m.verifyDrive(files, new(sha256Sums))
+}
+
+// verifyDrive is like VerifyDrive, but also compares files by Drive's SHA-256 checksums in sums
+func (m *Manifest) verifyDrive(files []*drive.File, sums *sha256Sums) []*VerifyFailure {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := make(map[string]*ManifestEntry, len(m.Files))
+	for _, e := range m.Files {
+		entries[e.ID] = e
+	}
+
+	var failures []*VerifyFailure
+	for _, f := range files {
+		if f.MimeType == FileTypeFolder || f.MimeType == FileTypeShortcut || f.Trashed {
+			continue
+		}
+		if _, ok := SkipTypes[f.MimeType]; ok || strings.HasPrefix(f.MimeType, FileTypeSDKPrefix) {
+			continue
+		}
+
+		e, ok := entries[f.Id]
+		if !ok {
+			failures = append(failures, &VerifyFailure{Path: f.Name, Kind: VerifyNotArchived, Reason: "file " + f.Id + " is not in the manifest"})
+			continue
+		}
+		if reason := staleReason(e, f, sums.get(f.Id)); reason != "" {
+			failures = append(failures, &VerifyFailure{Path: e.Path, Kind: VerifyStale, Reason: reason})
+		}
+	}
+
+	return failures
+}
+
+// staleReason returns how f, the current Drive metadata of e's file with Drive's SHA-256 checksum sum, has changed since e was
+// archived, or an empty string if it hasn't. Only files archived as stored in Drive have comparable SHA-256 checksums
+func staleReason(e *ManifestEntry, f *drive.File, sum string) string {
+	switch {
+	case e.MD5Checksum != "" && f.Md5Checksum != "" && e.MD5Checksum != f.Md5Checksum:
+		return "md5 checksum changed in Drive"
+	case e.SHA256 != "" && sum != "" && e.ExportType == "" && e.PDFA != "converted" && e.SHA256 != sum:
+		return "sha256 checksum changed in Drive"
+	case e.HeadRevisionID != "" && f.HeadRevisionId != "" && e.HeadRevisionID != f.HeadRevisionId:
+		return fmt.Sprintf("new revision %s in Drive, archived revision %s", f.HeadRevisionId, e.HeadRevisionID)
+	case e.ModifiedTime != f.ModifiedTime:
+		return fmt.Sprintf("modified in Drive at %s, archived version modified at %s", f.ModifiedTime, e.ModifiedTime)
+	}
+	return ""
+}
+
+// VerifyRemote checks the manifest's captured files against their current Drive metadata without reading local files, returning
+// the files that have changed or been deleted in Drive since they were archived, and the downloadable files that aren't in the
+// manifest. Files are compared by md5 and SHA-256 checksum, head revision, and modified time. The SHA-256 checksums are listed with
+// the files, so files Drive has no md5 checksum for are still compared by content. Archived files that aren't in the user's
+// listing, e.g. files in Shared Drives, are fetched individually
+func (s *Service) VerifyRemote(ctx context.Context, m *Manifest) (*VerifyResult, error) {
+	files, err := s.list(ctx, s.FilesService.List().
+		Corpora("user").
+		Fields(s.withSHA256(listFields, "files/")...).
+		Spaces("drive").
+		PageSize(1000))
+	if err != nil {
+		return nil, fmt.Errorf("could not list files: %w", err)
+	}
+
+	r := &VerifyResult{Remote: true, Failures: m.verifyDrive(files, &s.sha256s)}
+	listed := make(map[string]bool, len(files))
+	for _, f := range files {
+		if !f.Trashed {
+			listed[f.Id] = true
+		}
+	}
+
+	m.mu.Lock()
+	entries := append(make([]*ManifestEntry, 0, len(m.Files)), m.Files...)
+	m.mu.Unlock()
+
+	// PDF renditions share the ID of their original
+	checked := make(map[string]bool)
+	for _, e := range entries {
+		if !e.Captured() || checked[e.ID] {
+			continue
+		}
+		checked[e.ID] = true
+		r.Total++
+		r.Checked++
+		if listed[e.ID] {
+			continue
+		}
+
+		f, err := s.getFile(ctx, e.ID, s.withSHA256(getFields, ""))
+		var gErr *googleapi.Error
+		switch {
+		case errors.As(err, &gErr) && gErr.Code == 404:
+			r.Failures = append(r.Failures, &VerifyFailure{Path: e.Path, Kind: VerifyDeleted, Reason: "file " + e.ID + " no longer exists in Drive"})
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("%s: %w", e.Path, err)
+		case f.Trashed:
+			r.Failures = append(r.Failures, &VerifyFailure{Path: e.Path, Kind: VerifyDeleted, Reason: "moved to the trash in Drive"})
+		default:
+			if reason := staleReason(e, f, s.sha256s.get(f.Id)); reason != "" {
+				r.Failures = append(r.Failures, &VerifyFailure{Path: e.Path, Kind: VerifyStale, Reason: reason})
+			}
+		}
+	}
+
+	return r, nil
+}
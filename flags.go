@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/korylprince/drive-archive/drive"
+)
+
+// stringsFlag is a flag.Value that can be set multiple times
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+var sizeUnits = map[string]int64{
+	"":  1,
+	"B": 1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// parseSize parses a human readable size (e.g. 100GB, 1.5T, 512) into bytes
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+	i := strings.IndexAny(s, "KMGT")
+	unit := ""
+	if i != -1 {
+		unit = s[i:]
+		s = s[:i]
+	}
+	mult, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit: %s", unit)
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse number: %w", err)
+	}
+	if n < 0 {
+		return 0, errors.New("size cannot be negative")
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// parseRoute parses a route in the form conditions=dest, where conditions is a comma separated list of
+// mime type prefixes, >size, or <size. A file matches if its mime type starts with any of the prefixes and it's
+// strictly larger and smaller than the sizes. A condition of * matches all files. dest is a local path, or an s3:// or gs://
+// object store URL
+func parseRoute(s string) (*drive.Route, error) {
+	i := strings.Index(s, "=")
+	if i == -1 {
+		return nil, errors.New("route must be in the form conditions=dest")
+	}
+	route := &drive.Route{Dest: s[i+1:]}
+	if route.Dest == "" {
+		return nil, errors.New("route dest cannot be empty")
+	}
+	if strings.Contains(route.Dest, "://") {
+		if _, _, _, err := drive.ParseStoreURL(route.Dest); err != nil {
+			return nil, fmt.Errorf("invalid dest %s: %w", route.Dest, err)
+		}
+	}
+
+	for _, cond := range strings.Split(s[:i], ",") {
+		cond = strings.TrimSpace(cond)
+		switch {
+		case cond == "" || cond == "*":
+		case strings.HasPrefix(cond, ">"), strings.HasPrefix(cond, "<"):
+			n, err := parseSize(cond[1:])
+			if err != nil {
+				return nil, fmt.Errorf("could not parse size %s: %w", cond, err)
+			}
+			// zero means no limit
+			if n == 0 {
+				return nil, fmt.Errorf("invalid size %s: must be positive", cond)
+			}
+			if cond[0] == '>' {
+				route.MinSize = n
+			} else {
+				route.MaxSize = n
+			}
+		default:
+			route.MimePrefixes = append(route.MimePrefixes, cond)
+		}
+	}
+
+	return route, nil
+}
+
+// parseClock parses a time of day in the form HH:MM into an offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseRate parses a bandwidth limit in bytes per second (e.g. 10MB). A rate of 0 or "unlimited" is unlimited
+func parseRate(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "/s")
+	if strings.EqualFold(s, "unlimited") {
+		return 0, nil
+	}
+	return parseSize(s)
+}
+
+// parseWindow parses a bandwidth window in the form HH:MM-HH:MM=rate
+func parseWindow(s string) (*drive.Window, error) {
+	i := strings.Index(s, "=")
+	if i == -1 {
+		return nil, errors.New("window must be in the form HH:MM-HH:MM=rate")
+	}
+	times := strings.SplitN(s[:i], "-", 2)
+	if len(times) != 2 {
+		return nil, errors.New("window must be in the form HH:MM-HH:MM=rate")
+	}
+
+	w := new(drive.Window)
+	var err error
+	if w.Start, err = parseClock(times[0]); err != nil {
+		return nil, fmt.Errorf("could not parse start time: %w", err)
+	}
+	if w.End, err = parseClock(times[1]); err != nil {
+		return nil, fmt.Errorf("could not parse end time: %w", err)
+	}
+	if w.Rate, err = parseRate(s[i+1:]); err != nil {
+		return nil, fmt.Errorf("could not parse rate: %w", err)
+	}
+
+	return w, nil
+}
+
+// newRunID returns a random (version 4) UUID
+func newRunID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// parseFields splits a comma separated list of Drive fields, ignoring commas inside parentheses
+func parseFields(s string) ([]string, error) {
+	var (
+		fields []string
+		depth  int
+		start  int
+	)
+	for i, r := range s + "," {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, errors.New("unbalanced parentheses")
+			}
+		case ',':
+			if depth > 0 {
+				continue
+			}
+			if f := strings.TrimSpace(s[start:i]); f != "" {
+				fields = append(fields, f)
+			}
+			start = i + 1
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced parentheses")
+	}
+	return fields, nil
+}
+
+// relativeUnits are the units of relative times accepted by parseTime in addition to time.ParseDuration's units
+var relativeUnits = map[byte]time.Duration{
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+	'y': 365 * 24 * time.Hour,
+}
+
+// parseTime parses an RFC3339 time, a date (2006-01-02) in the local time zone, or a time relative to now, e.g. 90d, 2w, 1y, or 36h
+func parseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+
+	if s == "" {
+		return time.Time{}, errors.New("time cannot be empty")
+	}
+	if unit, ok := relativeUnits[s[len(s)-1]]; ok {
+		n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("invalid relative time %s", s)
+		}
+		return now.Add(-time.Duration(n * float64(unit))), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("invalid time %s: must be RFC3339, YYYY-MM-DD, or relative, e.g. 90d", s)
+	}
+	return now.Add(-d), nil
+}
+
+// splitList splits comma separated values, dropping empty values
+func splitList(values []string) []string {
+	var items []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// parseMimes splits comma separated mime type prefixes. google is an alias for all Google apps types
+func parseMimes(values []string) []string {
+	var mimes []string
+	for _, v := range values {
+		for _, m := range strings.Split(v, ",") {
+			m = strings.TrimSpace(m)
+			switch {
+			case m == "":
+			case strings.EqualFold(m, "google"):
+				mimes = append(mimes, drive.FileTypeGooglePrefix)
+			default:
+				mimes = append(mimes, m)
+			}
+		}
+	}
+	return mimes
+}
@@ -1,98 +1,2105 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/korylprince/drive-archive/drive"
+	"github.com/korylprince/drive-archive/drive/sanitize"
 )
 
-func run(auth, user, root, out string, downloadOrphans bool) error {
-	svc, err := drive.NewService(auth, user, time.Second, 8)
+// Version is the version of the tool. It's set at build time with -ldflags "-X main.Version=..."
+var Version string
+
+// version returns Version, or the module version if the tool was installed with go install
+func version() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+type config struct {
+	AuthFile         string
+	User             string
+	Root             string
+	Out              string
+	Orphans          bool
+	Shared           bool
+	SharedRO         bool
+	Router           drive.Router
+	Filter           *drive.Filter
+	LabelInclude     []string
+	LabelExclude     []string
+	Clamd            string
+	ClamdTimeout     time.Duration
+	Quarantine       string
+	Hold             *drive.Hold
+	RunID            string
+	Delta            string
+	Merkle           bool
+	Throttle         *drive.Throttle
+	RateLimit        *drive.RateLimiter
+	Control          *drive.Control
+	Layout           drive.Layout
+	PDFA             *drive.PDFAConverter
+	SplitSize        int64
+	Checksums        string
+	SkipEmptyFolders bool
+	EmptyFolders     drive.EmptyFolders
+	CopyRestricted   bool
+	CopyOversized    bool
+	Logger           drive.Logger
+	SkipIdentical    bool
+	MinCompleteness  float64
+	PseudonymKey     string
+	ReadOnly         bool
+	Index            bool
+	ResolveShortcuts bool
+	PinRevisions     bool
+	PreferSHA256     bool
+	LowMemory        bool
+	Revisions        drive.Revisions
+	OrphansMaxSize   int64
+	MaxSize          int64
+	Throughput       bool
+	PDFRenditions    bool
+	SheetsCSV        bool
+	SheetValues      bool
+	OrphansOwned     bool
+	OrphanBuckets    drive.OrphanBuckets
+	OrphanThreshold  int
+	ShardThreshold   int
+	Duplicates       drive.DuplicatePolicy
+	OCFL             string
+	OCFLID           string
+	METS             bool
+	B2               string
+	IPFS             string
+	IPFSCAR          bool
+	DryRun           bool
+	Prices           *priceSheet
+	SMB              bool
+	MaxPathLength    int
+	Report           string
+	ReportFolder     string
+	ReportUser       string
+	Incremental      string
+	Notifier         *notifier
+	State            *drive.RunState
+	Metrics          *drive.Metrics
+	Downloaders      int
+	GC               bool
+	OCR              bool
+	OCRLanguage      string
+	ExtraFields      []string
+	NDJSON           bool
+	MediaSidecars    bool
+	CaptureMtime     bool
+	Catalog          bool
+	// ResumeRun is true if -run-id was given, to resume that run
+	ResumeRun bool
+	// Pool, if set, is used to create the run's Service
+	Pool *drive.ServicePool
+}
+
+func run(ctx context.Context, cfg *config) (err error) {
+	var svc *drive.Service
+	if cfg.Pool != nil {
+		svc, err = cfg.Pool.Service(cfg.User, cfg.ReadOnly || cfg.DryRun)
+	} else {
+		newService := drive.NewService
+		if cfg.ReadOnly || cfg.DryRun {
+			newService = drive.NewReadOnlyService
+		}
+		svc, err = newService(cfg.AuthFile, cfg.User, time.Second, 8)
+	}
+	if err != nil {
+		return fmt.Errorf("could not create service: %w", err)
+	}
+	svc.RunID = cfg.RunID
+	svc.Logger = cfg.Logger
+	svc.Throttle = cfg.Throttle
+	svc.RateLimit = cfg.RateLimit
+	svc.CopyRestricted = cfg.CopyRestricted
+	svc.CopyOversized = cfg.CopyOversized
+	svc.SkipIdentical = cfg.SkipIdentical
+	svc.PseudonymKey = cfg.PseudonymKey
+	svc.PinRevisions = cfg.PinRevisions
+	svc.PreferSHA256 = cfg.PreferSHA256
+	if cfg.LowMemory {
+		svc.SheetChunkRows = lowMemorySheetRows
+	}
+	svc.ExtraFields = cfg.ExtraFields
+	if cfg.MediaSidecars {
+		svc.ExtraFields = append(append([]string{}, cfg.ExtraFields...), drive.MediaFields...)
+	}
+
+	if cfg.Clamd != "" {
+		scanner, err := drive.NewClamdScanner(cfg.Clamd)
+		if err != nil {
+			return fmt.Errorf("could not create scanner: %w", err)
+		}
+		scanner.Timeout = cfg.ClamdTimeout
+		svc.Scanner = scanner
+		svc.Quarantine = cfg.Quarantine
+	}
+
+	if err = svc.Preflight(ctx); err != nil {
+		return fmt.Errorf("preflight failed: %w", err)
+	}
+
+	if len(cfg.LabelInclude) > 0 || len(cfg.LabelExclude) > 0 {
+		filter, err := svc.LabelFilter(ctx, cfg.Filter, cfg.LabelInclude, cfg.LabelExclude)
+		if err != nil {
+			return fmt.Errorf("could not filter by label: %w", err)
+		}
+		fmt.Printf("found %d files with included labels and %d files with excluded labels\n", len(filter.LabelInclude), len(filter.LabelExclude))
+		// cfg is shared by concurrent runs in batch mode, so the filter is only set on a copy
+		c := *cfg
+		c.Filter = filter
+		cfg = &c
+	}
+
+	root := cfg.Root
+	if root == "" {
+		root, err = svc.Root(ctx)
+		if err != nil {
+			return fmt.Errorf("could not get root id: %w", err)
+		}
+	}
+
+	fmt.Println("starting run", cfg.RunID)
+	cfg.Notifier.post(cfg.User + " started")
+
+	// files being written are always cleaned up, and other temporary files with -gc. Resumable downloads are removed
+	// once files are listed, only if they can't be resumed
+	suffixes := []string{drive.AtomicSuffix}
+	if cfg.GC {
+		suffixes = append(suffixes, drive.PartialSuffix)
+	}
+	dirs := []string{cfg.Out}
+	for _, route := range cfg.Router {
+		dirs = append(dirs, route.Dest)
+	}
+	for _, dir := range dirs {
+		if cfg.DryRun {
+			break
+		}
+		files, size, err := drive.GC(dir, suffixes...)
+		if err != nil {
+			return fmt.Errorf("could not remove temporary files: %w", err)
+		}
+		if files > 0 {
+			fmt.Printf("removed %d stale temporary files (%d bytes) from %s\n", files, size, dir)
+		}
+	}
+
+	start := time.Now()
+	out := cfg.Out
+	opts := &drive.DownloadOptions{
+		Downloaders:      cfg.Downloaders,
+		Router:           cfg.Router,
+		Filter:           cfg.Filter,
+		Hold:             cfg.Hold,
+		Control:          cfg.Control,
+		Layout:           cfg.Layout,
+		PDFA:             cfg.PDFA,
+		SkipEmptyFolders: cfg.SkipEmptyFolders,
+		Revisions:        cfg.Revisions,
+		ShardThreshold:   cfg.ShardThreshold,
+		SMB:              cfg.SMB,
+		MaxPathLength:    cfg.MaxPathLength,
+		OCR:              cfg.OCR,
+		OCRLanguage:      cfg.OCRLanguage,
+		MediaSidecars:    cfg.MediaSidecars,
+		CaptureMtime:     cfg.CaptureMtime,
+		DryRun:           cfg.DryRun,
+		MaxSize:          cfg.MaxSize,
+		PDFRenditions:    cfg.PDFRenditions,
+		SheetsCSV:        cfg.SheetsCSV,
+		SheetValues:      cfg.SheetValues,
+	}
+
+	if cfg.Delta != "" {
+		prev, err := drive.ReadManifest(cfg.Delta)
+		if err != nil {
+			return fmt.Errorf("could not read previous manifest: %w", err)
+		}
+		opts.ModifiedSince = prev.Captured
+		out = filepath.Join(cfg.Out, "delta", start.Format("2006-01-02"))
+		if !cfg.DryRun {
+			if err = os.MkdirAll(out, 0755); err != nil {
+				return fmt.Errorf("could not create delta directory: %w", err)
+			}
+		}
+		fmt.Println("downloading files changed since", prev.Captured.Format(time.RFC3339), "to", out)
+	}
+
+	var progress []func(*drive.ProgressEvent)
+	if cfg.Notifier != nil && cfg.Notifier.Every > 0 {
+		progress = append(progress, cfg.Notifier.progress)
+	}
+	if cfg.State != nil {
+		progress = append(progress, cfg.State.Progress)
+	}
+	if cfg.Metrics != nil {
+		progress = append(progress, cfg.Metrics.Progress)
+	}
+	if len(progress) > 0 {
+		opts.Progress = func(e *drive.ProgressEvent) {
+			for _, f := range progress {
+				f(e)
+			}
+		}
+	}
+
+	opts.Manifest = drive.NewManifest(cfg.RunID, out, start)
+	opts.Manifest.Config = runConfig(cfg, start)
+	opts.Manifest.ExtraFields = cfg.ExtraFields
+	opts.Stats = new(drive.Stats)
+	opts.Collisions = new(drive.CollisionReport)
+
+	drained := false
+	if cfg.Report != "" && !cfg.DryRun {
+		// the report is written however the run ends, so monitoring can tell failed runs from runs that never finished
+		defer func() {
+			var fErr *failedFilesError
+			partial := errors.As(err, &fErr)
+			status := drive.RunCompleted
+			switch {
+			case drained:
+				status = drive.RunDrained
+			case err != nil && !partial:
+				status = drive.RunFailed
+			case partial:
+				status = drive.RunCompletedWithErrors
+			}
+			if rErr := drive.NewRunReport(cfg.User, status, opts.Manifest, opts.Stats, err).Write(cfg.Report); rErr != nil {
+				fmt.Println("could not write report:", rErr)
+			}
+		}()
+	}
+
+	if cfg.SplitSize > 0 {
+		opts.Volumes = drive.NewVolumePlan(out, cfg.SplitSize)
+	}
+
+	var catalog *drive.Catalog
+	if cfg.Catalog && !cfg.DryRun {
+		if catalog, err = drive.OpenCatalog(out); err != nil {
+			return err
+		}
+		defer catalog.Close()
+		opts.Manifest.SetCatalog(catalog)
+	}
+
+	journal := filepath.Join(out, drive.JournalName)
+	if !cfg.DryRun {
+		// entries are journaled as files finish, so a killed run leaves a record of what it captured
+		n, err := opts.Manifest.OpenJournal(journal)
+		if err != nil {
+			return err
+		}
+		defer opts.Manifest.CloseJournal()
+		if n > 0 {
+			fmt.Println("recovered", n, "files from the journal of a run that didn't finish")
+		}
+	}
+
+	err = downloadAll(ctx, svc, cfg, catalog, root, out, opts)
+	if cfg.DryRun && err == nil {
+		return dryRunSummary(cfg, out, opts.Stats)
+	}
+	drained = errors.Is(err, drive.ErrDrained)
+	if err != nil {
+		// record the files captured before the run stopped or was drained separately, so the manifest of the last complete
+		// run is kept, and -delta isn't based on a partial capture. If no files were walked, there's nothing to record.
+		// The journal and an unfinished catalog listing are kept so the next run can resume
+		if opts.Stats.Listed > 0 && !cfg.DryRun {
+			opts.Manifest.Config.Finished = time.Now()
+			if mErr := opts.Manifest.Write(filepath.Join(out, drive.PartialManifestName)); mErr != nil {
+				fmt.Println("could not write manifest:", mErr)
+			}
+			fmt.Println(opts.Stats)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("interrupted: %w", ctx.Err())
+		}
+		if drained {
+			return fmt.Errorf("%w: stopped before all files were downloaded", err)
+		}
+		return err
+	}
+
+	if n := len(opts.Collisions.Collisions()); n > 0 {
+		if err = opts.Collisions.WriteCSV(filepath.Join(out, "collisions.csv")); err != nil {
+			return fmt.Errorf("could not write collision report: %w", err)
+		}
+		fmt.Printf("%d files and folders were renamed to avoid collisions or shortened; see %s\n", n, filepath.Join(out, "collisions.csv"))
+	}
+
+	if n, err := opts.Manifest.RecordEmptyFolders(cfg.EmptyFolders); err != nil {
+		return fmt.Errorf("could not record empty folders: %w", err)
+	} else if n > 0 {
+		fmt.Println("recorded", n, "empty folders")
+	}
+
+	if cfg.Merkle {
+		tree, err := opts.Manifest.Merkle()
+		if err != nil {
+			return fmt.Errorf("could not compute merkle tree: %w", err)
+		}
+		fmt.Println("merkle root:", tree.Root)
+	}
+
+	if cfg.Checksums != "" {
+		if err = opts.Manifest.WriteChecksums(cfg.Checksums == "dir"); err != nil {
+			return fmt.Errorf("could not write checksums: %w", err)
+		}
+	}
+
+	if cfg.Index {
+		if err = opts.Manifest.WriteIndexes(); err != nil {
+			return fmt.Errorf("could not write indexes: %w", err)
+		}
+	}
+
+	if cfg.IPFS != "" {
+		if err = addIPFS(ctx, cfg, out, opts.Manifest); err != nil {
+			return fmt.Errorf("could not add to IPFS: %w", err)
+		}
+	}
+
+	opts.Manifest.Config.Finished = time.Now()
+	if err = opts.Manifest.Write(filepath.Join(out, "manifest.json")); err != nil {
+		return fmt.Errorf("could not write manifest: %w", err)
+	}
+	// the partial manifest of an earlier stopped run is replaced by the complete manifest
+	if err = os.Remove(filepath.Join(out, drive.PartialManifestName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove partial manifest: %w", err)
+	}
+	// the journal is only needed until the manifest is written
+	if err = opts.Manifest.CloseJournal(); err != nil {
+		fmt.Println("could not write journal:", err)
+	}
+	if err = os.Remove(journal); err != nil {
+		return fmt.Errorf("could not remove journal: %w", err)
+	}
+	if catalog != nil {
+		if err = catalog.Finish(); err != nil {
+			return err
+		}
+		if err = catalog.Close(); err != nil {
+			fmt.Println("could not write catalog:", err)
+		}
+	}
+	if cfg.NDJSON {
+		if err = opts.Manifest.WriteNDJSON(filepath.Join(out, "manifest.ndjson")); err != nil {
+			return fmt.Errorf("could not write NDJSON manifest: %w", err)
+		}
+	}
+
+	if opts.Volumes != nil {
+		for n := 1; n <= opts.Volumes.Volumes(); n++ {
+			vol := drive.VolumeName(n)
+			if err = opts.Manifest.Subset(vol).Write(filepath.Join(out, vol, "manifest.json")); err != nil {
+				return fmt.Errorf("could not write %s manifest: %w", vol, err)
+			}
+		}
+		fmt.Println("split archive into", opts.Volumes.Volumes(), "volumes")
+	}
+
+	if cfg.METS {
+		if err = opts.Manifest.WriteMETS(filepath.Join(out, "mets.xml")); err != nil {
+			return fmt.Errorf("could not write METS file: %w", err)
+		}
+	}
+
+	if cfg.OCFL != "" {
+		id := cfg.OCFLID
+		if id == "" {
+			id = cfg.User
+		}
+		version, err := opts.Manifest.WriteOCFL(cfg.OCFL, id, "run "+cfg.RunID)
+		if err != nil {
+			return fmt.Errorf("could not write OCFL object: %w", err)
+		}
+		fmt.Println("wrote OCFL object", id, "version", version)
+	}
+
+	if cfg.B2 != "" {
+		if err = uploadB2(cfg.B2, out, opts.Manifest); err != nil {
+			return fmt.Errorf("could not upload to B2: %w", err)
+		}
+	}
+
+	for _, route := range cfg.Router {
+		if route.Store == nil {
+			continue
+		}
+		n, err := opts.Manifest.UploadStore(ctx, route.Store, route.Dest)
+		if err != nil {
+			return fmt.Errorf("could not upload to %v: %w", route.Store, err)
+		}
+		fmt.Printf("uploaded %d files to %v\n", n, route.Store)
+	}
+
+	fmt.Println(opts.Stats)
+	fmt.Println("elapsed:", time.Since(start).Round(time.Second))
+	if cfg.Throughput {
+		fmt.Println(opts.Stats.Breakdown())
+	}
+	errorsPath := filepath.Join(out, "errors.csv")
+	failed, err := opts.Manifest.WriteErrors(errorsPath)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		fmt.Println("failed files are listed in", errorsPath)
+	}
+	cfg.Notifier.post(cfg.User + " finished\n" + opts.Stats.String())
+
+	if cfg.ReportFolder != "" {
+		if err = writeReport(ctx, svc, cfg, opts); err != nil {
+			return fmt.Errorf("could not write report: %w", err)
+		}
+	}
+
+	if files, _ := opts.Stats.Completeness(); files < cfg.MinCompleteness {
+		return fmt.Errorf("completeness %.2f%% is below minimum %.2f%%", files, cfg.MinCompleteness)
+	}
+
+	fmt.Println("done!")
+
+	if failed > 0 {
+		return &failedFilesError{Failed: failed, Report: errorsPath}
+	}
+
+	return nil
+}
+
+// failedFilesError is returned by run when a run finished, but some files couldn't be downloaded
+type failedFilesError struct {
+	Failed int
+	Report string
+}
+
+func (e *failedFilesError) Error() string {
+	return fmt.Sprintf("%d files failed (see %s)", e.Failed, e.Report)
+}
+
+// dryRunSummary prints what a dry run would download to out and checks that it fits in the free space of out's filesystem
+func dryRunSummary(cfg *config, out string, stats *drive.Stats) error {
+	summary := stats.DryRunString()
+	if cfg.Prices != nil {
+		summary += "\n" + cfg.Prices.estimate(stats)
+	}
+	fmt.Println(summary)
+	cfg.Notifier.post(cfg.User + " dry run finished\n" + summary)
+
+	free, err := freeSpace(out)
+	if err != nil {
+		fmt.Println("could not check free space:", err)
+		return nil
+	}
+	fmt.Println("free space:", free, "bytes")
+	if stats.DownloadedBytes > free {
+		return fmt.Errorf("files to download total %d bytes, which is more than the %d bytes free in %s", stats.DownloadedBytes, free, out)
+	}
+	return nil
+}
+
+// runConfig returns the effective configuration of the run
+func runConfig(cfg *config, start time.Time) *drive.RunConfig {
+	rc := &drive.RunConfig{Version: version(), Flags: make(map[string]string), Started: start}
+	flag.VisitAll(func(f *flag.Flag) {
+		rc.Flags[f.Name] = f.Value.String()
+	})
+	rc.Flags["run-id"] = cfg.RunID
+
+	rc.ExportTypes = drive.ExportTypes
+	if cfg.Layout == drive.LayoutRecords {
+		rc.ExportTypes = drive.RecordExportTypes
+	}
+
+	return rc
+}
+
+// writeReport creates a Google Sheet listing the archived files in cfg.ReportFolder
+func writeReport(ctx context.Context, svc *drive.Service, cfg *config, opts *drive.DownloadOptions) error {
+	if cfg.ReportUser != "" && cfg.ReportUser != cfg.User {
+		var err error
+		if svc, err = drive.NewService(cfg.AuthFile, cfg.ReportUser, time.Second, 8); err != nil {
+			return fmt.Errorf("could not create service: %w", err)
+		}
+	}
+
+	title := fmt.Sprintf("Drive Archive %s %s", cfg.User, opts.Manifest.Captured.Format("2006-01-02"))
+	link, err := svc.WriteSheetReport(ctx, opts.Manifest, opts.Stats, cfg.ReportFolder, title)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("wrote report to", link)
+	return nil
+}
+
+// uploadB2 uploads the archived files and archive metadata in out to dest, in the form bucket/prefix
+func uploadB2(dest, out string, m *drive.Manifest) error {
+	parts := strings.SplitN(dest, "/", 2)
+	prefix := ""
+	if len(parts) == 2 {
+		prefix = parts[1]
+	}
+
+	b, err := drive.NewB2(os.Getenv("B2_APPLICATION_KEY_ID"), os.Getenv("B2_APPLICATION_KEY"), parts[0], prefix)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("uploading to B2 bucket", parts[0])
+	if err = m.UploadB2(b); err != nil {
+		return err
+	}
+
+	for _, name := range []string{"manifest.json", "manifest.ndjson", "mets.xml", "SHA256SUMS"} {
+		if _, err = os.Stat(filepath.Join(out, name)); err != nil {
+			continue
+		}
+		if err = b.UploadFile(filepath.Join(out, name), name); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// routesDir is the directory in -out files routed to object stores are written to before they're uploaded
+const routesDir = ".routes"
+
+// newRouteStore creates the object store of route if its Dest is an object store URL, replacing Dest with the local directory
+// matching files are written to before they're uploaded. Files are kept there so later runs only download and upload changes.
+// S3 credentials are read from the standard AWS environment variables, and GCS buckets are written as the -authfile service account
+func newRouteStore(cfg *config, route *drive.Route) error {
+	if !strings.Contains(route.Dest, "://") {
+		return nil
+	}
+	scheme, bucket, prefix, err := drive.ParseStoreURL(route.Dest)
+	if err != nil {
+		return err
+	}
+
+	switch scheme {
+	case "s3":
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		s := drive.NewS3(os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), region, bucket, prefix)
+		s.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+		s.Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+		route.Store = s
+	case "gs":
+		if route.Store, err = drive.NewGCS(context.Background(), cfg.AuthFile, bucket, prefix); err != nil {
+			return err
+		}
+	}
+
+	route.Dest = filepath.Join(cfg.Out, routesDir, scheme, bucket, filepath.FromSlash(prefix))
+	return nil
+}
+
+// addIPFS adds the archived files to the IPFS node at cfg.IPFS, recording their CIDs in m.
+// With -ipfs-car, the archive's DAG is also exported to archive.car in out
+func addIPFS(ctx context.Context, cfg *config, out string, m *drive.Manifest) error {
+	i := drive.NewIPFS(cfg.IPFS, path.Join("drive-archive", userDir(cfg.User), cfg.RunID))
+
+	fmt.Println("adding to IPFS at", i.Dir)
+	root, err := m.AddIPFS(ctx, i)
+	if err != nil {
+		return err
+	}
+	fmt.Println("IPFS root:", root)
+
+	if cfg.IPFSCAR {
+		if err = i.ExportCAR(ctx, root, filepath.Join(out, "archive.car")); err != nil {
+			return err
+		}
+		fmt.Println("wrote", filepath.Join(out, "archive.car"))
+	}
+
+	return nil
+}
+
+// verify verifies the files in the manifest at path. If authFile is set, the manifest is also compared to user's current Drive listing
+func verify(path string, sample float64, seed int64, limit time.Duration, authFile, user string) error {
+	m, err := drive.ReadManifest(path)
+	if err != nil {
+		return err
+	}
+
+	r := m.Verify(sample, seed, limit)
+
+	if authFile != "" {
+		svc, err := drive.NewReadOnlyService(authFile, user, time.Second, 8)
+		if err != nil {
+			return fmt.Errorf("could not create service: %w", err)
+		}
+		files, err := svc.List(context.Background())
+		if err != nil {
+			return fmt.Errorf("could not list files: %w", err)
+		}
+		r.Failures = append(r.Failures, m.VerifyDrive(files)...)
+	}
+
+	fmt.Println(r)
+
+	if len(r.Failures) > 0 || len(r.Extra) > 0 || len(r.Links) > 0 {
+		return fmt.Errorf("%d files failed verification, %d extra files, %d unsafe links", len(r.Failures), len(r.Extra), len(r.Links))
+	}
+
+	return nil
+}
+
+// verifyRemote compares the manifest at path to the current Drive metadata of user without reading local files
+func verifyRemote(path, authFile, user string) error {
+	m, err := drive.ReadManifest(path)
+	if err != nil {
+		return err
+	}
+
+	svc, err := drive.NewReadOnlyService(authFile, user, time.Second, 8)
+	if err != nil {
+		return fmt.Errorf("could not create service: %w", err)
+	}
+	r, err := svc.VerifyRemote(context.Background(), m)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(r)
+
+	if len(r.Failures) > 0 {
+		return fmt.Errorf("%d files changed in Drive or not archived", len(r.Failures))
+	}
+
+	return nil
+}
+
+// fetch downloads the file with id to cfg.Out, or writes it to stdout if cfg.Out is -. Messages are written to stderr
+// so stdout can be piped to another command
+func fetch(ctx context.Context, cfg *config, id string) error {
+	newService := drive.NewService
+	if cfg.ReadOnly {
+		newService = drive.NewReadOnlyService
+	}
+	svc, err := newService(cfg.AuthFile, cfg.User, time.Second, 8)
+	if err != nil {
+		return fmt.Errorf("could not create service: %w", err)
+	}
+	svc.Throttle = cfg.Throttle
+	svc.RateLimit = cfg.RateLimit
+	svc.Logger = cfg.Logger
+
+	f, err := svc.GetFile(ctx, id)
+	if err != nil {
+		return fmt.Errorf("could not get file: %w", err)
+	}
+	exportType := drive.ExportTypes[f.MimeType]
+
+	if cfg.Out == "-" {
+		return svc.Stream(ctx, f, exportType, os.Stdout)
+	}
+
+	path := cfg.Out
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, sanitize.Name(f.Name)+drive.ExportExtensions[f.MimeType])
+	}
+	downloaded, err := svc.DownloadFileAs(ctx, f, exportType, path)
+	if err != nil {
+		return err
+	}
+	if downloaded {
+		fmt.Fprintln(os.Stderr, "downloaded", path)
+	} else {
+		fmt.Fprintln(os.Stderr, "already up to date:", path)
+	}
+	return nil
+}
+
+// restore uploads the files in the manifest at path chosen by opts to the Drive folder with folderID
+func restore(ctx context.Context, cfg *config, path, folderID string, opts *drive.RestoreOptions) error {
+	m, err := drive.ReadManifest(path)
+	if err != nil {
+		return err
+	}
+	if len(opts.Owners) > 0 {
+		owners := false
+		for _, f := range m.ExtraFields {
+			if f == "owners" || strings.HasPrefix(f, "owners/") || strings.HasPrefix(f, "owners(") {
+				owners = true
+			}
+		}
+		if !owners {
+			return errors.New("-restore-owner requires an archive created with -fields owners")
+		}
+	}
+
+	svc, err := drive.NewService(cfg.AuthFile, cfg.User, time.Second, 8)
 	if err != nil {
 		return fmt.Errorf("could not create service: %w", err)
 	}
+	svc.RunID = cfg.RunID
+	svc.Logger = cfg.Logger
+	svc.Throttle = cfg.Throttle
+	svc.RateLimit = cfg.RateLimit
+
+	stats, err := svc.Restore(ctx, m, folderID, opts)
+	if stats != nil {
+		fmt.Println(stats)
+	}
+	if err != nil {
+		return err
+	}
+	if stats.Failed > 0 {
+		return fmt.Errorf("%d files failed", stats.Failed)
+	}
+	return nil
+}
+
+// benchmark runs a benchmark with opts, downloading to out, or a temporary directory if out is empty
+func benchmark(ctx context.Context, out string, opts *drive.BenchmarkOptions) error {
+	if out == "" {
+		dir, err := os.MkdirTemp("", "drive-archive-benchmark")
+		if err != nil {
+			return fmt.Errorf("could not create temporary directory: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		out = dir
+	}
+
+	fmt.Printf("benchmarking %d files of %d bytes in %d folders\n", opts.Files, opts.Size, opts.Folders)
+	r, err := drive.Benchmark(ctx, out, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Println(r)
+	fmt.Println(r.Stats)
+	return nil
+}
+
+// resumeHint returns how to resume an interrupted run
+func resumeHint(cfg *config) string {
+	if cfg.Catalog {
+		return fmt.Sprintf("to resume, run again with the same flags and -run-id %s. The files listed by this run are used, and files already downloaded are skipped", cfg.RunID)
+	}
+	return "to resume, run again with the same flags. Files already downloaded are skipped"
+}
+
+// lookup prints the status and local path of the files in the catalog of the archive at out with the Drive ID query,
+// or, if there are none, whose paths or names match query
+func lookup(out, query string) error {
+	if _, err := os.Stat(filepath.Join(out, drive.CatalogDir)); err != nil {
+		return fmt.Errorf("could not find catalog: %w", err)
+	}
+	catalog, err := drive.OpenCatalog(out)
+	if err != nil {
+		return err
+	}
+	defer catalog.Close()
+
+	entries := catalog.Lookup(query)
+	if len(entries) == 0 {
+		entries = catalog.Search(query)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no files found matching %s", query)
+	}
+	for _, e := range entries {
+		fmt.Printf("%s\t%s\t%s\n", e.ID, e.Status, filepath.Join(out, filepath.FromSlash(e.Path)))
+	}
+	return nil
+}
+
+// migrate copies the user's files to a new folder in the Drive folder with destID
+func migrate(ctx context.Context, cfg *config, destID string) error {
+	svc, err := drive.NewService(cfg.AuthFile, cfg.User, time.Second, 8)
+	if err != nil {
+		return fmt.Errorf("could not create service: %w", err)
+	}
+	svc.RunID = cfg.RunID
+	svc.Logger = cfg.Logger
+	svc.RateLimit = cfg.RateLimit
+
+	if err = svc.Preflight(ctx); err != nil {
+		return fmt.Errorf("preflight failed: %w", err)
+	}
+
+	root := cfg.Root
+	if root == "" {
+		if root, err = svc.Root(ctx); err != nil {
+			return fmt.Errorf("could not get root id: %w", err)
+		}
+	}
+
+	files, err := svc.List(ctx)
+	if err != nil {
+		return fmt.Errorf("could not list files: %w", err)
+	}
+	fmt.Println("found", len(files), "total files")
+
+	tree, orphans := drive.NewTree(root, files)
+	trees := []*drive.File{tree}
+	if cfg.Orphans {
+		if cfg.OrphansOwned {
+			orphans = drive.OwnedOnly(orphans)
+		}
+		trees = append(trees, orphans)
+	}
+
+	opts := &drive.MigrateOptions{Filter: cfg.Filter, DryRun: cfg.DryRun}
+	total := new(drive.MigrateStats)
+	for _, t := range trees {
+		stats, err := svc.Migrate(ctx, t, destID, opts)
+		total.Folders += stats.Folders
+		total.Copied += stats.Copied
+		total.Duplicates += stats.Duplicates
+		total.Failed += stats.Failed
+		total.Bytes += stats.Bytes
+		if err != nil {
+			fmt.Println(total)
+			return err
+		}
+	}
+
+	fmt.Println(total)
+	if total.Failed > 0 {
+		return fmt.Errorf("%d files failed", total.Failed)
+	}
+	return nil
+}
+
+// listFiles lists the files in the user's Google Drive. With -incremental, the listing is read from the state file
+// and updated with the changes since it was saved, and the ids of the changed files are returned.
+// If there is no state file, all files are listed with a new page token. Otherwise, if catalog is set, the listing
+// of an unfinished previous run of root is reused, and new listings are saved to it
+func listFiles(ctx context.Context, svc *drive.Service, cfg *config, catalog *drive.Catalog, root string) (state *drive.SyncState, changed map[string]bool, err error) {
+	state = new(drive.SyncState)
+	if catalog != nil && cfg.Incremental == "" {
+		state.Files, err = catalog.Resume(cfg.RunID, cfg.User, root)
+		var uErr *drive.UnfinishedRunError
+		switch {
+		case errors.As(err, &uErr) && !cfg.ResumeRun:
+			fmt.Printf("run %s didn't finish; starting a new run. Use -run-id %s to resume it instead\n", uErr.RunID, uErr.RunID)
+		case err != nil:
+			return nil, nil, fmt.Errorf("could not resume run %s: %w", cfg.RunID, err)
+		case state.Files != nil:
+			fmt.Println("resuming run", cfg.RunID, "with its listing")
+			return state, nil, nil
+		}
+	}
+	if cfg.Incremental != "" {
+		state, err = drive.ReadSyncState(cfg.Incremental)
+		if err == nil {
+			changes, token, err := svc.Changes(ctx, state.PageToken)
+			if err != nil {
+				return nil, nil, err
+			}
+			changed = state.Apply(changes)
+			state.PageToken = token
+			fmt.Println("found", len(changes), "changes since the last incremental run")
+			return state, changed, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, nil, err
+		}
+
+		fmt.Println("no incremental state found: listing all files")
+		// get token before listing so changes made while listing aren't missed
+		state = new(drive.SyncState)
+		if state.PageToken, err = svc.StartPageToken(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if state.Files, err = svc.List(ctx); err != nil {
+		return nil, nil, fmt.Errorf("could not list files: %w", err)
+	}
+	if catalog != nil {
+		if err = catalog.SetListing(cfg.RunID, cfg.User, root, state.Files); err != nil {
+			return nil, nil, err
+		}
+	}
+	return state, nil, nil
+}
+
+// sharedDrivesDir is the directory Shared Drives are archived to
+const sharedDrivesDir = "Shared Drives"
+
+// gcCheckpoints removes the resumable downloads in out and -route paths, and the catalog entries, of files that aren't in
+// state's listing, e.g. because they were deleted from Drive. Shared Drives are listed separately, so theirs are kept
+func gcCheckpoints(cfg *config, catalog *drive.Catalog, out string, state *drive.SyncState) error {
+	dirs := []string{out}
+	for _, route := range cfg.Router {
+		dirs = append(dirs, route.Dest)
+	}
+	for _, dir := range dirs {
+		n, size, err := drive.GCCheckpoints(dir, state.Files, filepath.Join(dir, sharedDrivesDir))
+		if err != nil {
+			return fmt.Errorf("could not remove stale checkpoints: %w", err)
+		}
+		if n > 0 {
+			fmt.Printf("removed %d stale partial downloads (%d bytes) from %s\n", n, size, dir)
+		}
+	}
+
+	if catalog == nil {
+		return nil
+	}
+	listed := make(map[string]bool, len(state.Files))
+	for _, f := range state.Files {
+		listed[f.Id] = true
+	}
+	n, err := catalog.Compact(func(e *drive.ManifestEntry) bool {
+		return listed[e.ID] || strings.HasPrefix(e.Path, sharedDrivesDir+"/")
+	})
+	if err != nil {
+		return fmt.Errorf("could not compact catalog: %w", err)
+	}
+	if n > 0 {
+		fmt.Println("removed", n, "catalog entries of files no longer in Drive")
+	}
+	return nil
+}
+
+func downloadAll(ctx context.Context, svc *drive.Service, cfg *config, catalog *drive.Catalog, root, out string, opts *drive.DownloadOptions) error {
+	state, changed, err := listFiles(ctx, svc, cfg, catalog, root)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("found", len(state.Files), "total files")
+
+	if cfg.GC && !cfg.DryRun {
+		if err = gcCheckpoints(cfg, catalog, out, state); err != nil {
+			return err
+		}
+	}
+
+	rootTree, orphans := drive.NewTree(root, state.Files)
+
+	if catalog != nil && catalog.Resuming() {
+		// files captured by the resumed run aren't checked again
+		opts.Resume = catalog
+	}
+
+	if changed != nil {
+		opts.Only = drive.Descendants(changed, rootTree, orphans)
+	}
+
+	if cfg.ResolveShortcuts {
+		n, err := svc.ResolveShortcuts(ctx, rootTree, orphans)
+		if err != nil {
+			return fmt.Errorf("could not resolve shortcuts: %w", err)
+		}
+		fmt.Println("resolved", n, "shortcuts to files outside of the listing")
+	}
+
+	for _, tree := range []*drive.File{rootTree, orphans} {
+		if err = drive.ResolveDuplicateFolders(tree, cfg.Duplicates); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Orphans {
+		if cfg.OrphansOwned {
+			orphans = drive.OwnedOnly(orphans)
+		}
+		// records are laid out by ID, so they aren't grouped
+		if cfg.Layout != drive.LayoutRecords {
+			if b := drive.BucketOrphans(orphans, cfg.OrphanBuckets, cfg.OrphanThreshold); b != orphans {
+				fmt.Println("grouped", len(orphans.Files), "orphaned files and folders into", len(b.Files), "folders")
+				orphans = b
+			}
+		}
+		n, size := drive.TreeSize(orphans)
+		fmt.Println("found", n, "orphaned files totaling", size, "bytes (excluding Google files)")
+		if cfg.OrphansMaxSize > 0 && size > cfg.OrphansMaxSize {
+			return fmt.Errorf("orphaned files total %d bytes, which is more than -orphans-max-size (%d bytes)", size, cfg.OrphansMaxSize)
+		}
+	}
+
+	n, _ := drive.TreeSize(rootTree)
+	if cfg.Orphans {
+		o, _ := drive.TreeSize(orphans)
+		n += o
+	}
+	cfg.Notifier.addTotal(n)
+	cfg.State.AddTotal(n)
+
+	if opts.Volumes != nil {
+		opts.Volumes.Plan(rootTree, out)
+		if cfg.Orphans {
+			opts.Volumes.Plan(orphans, out)
+		}
+	}
+
+	if err = svc.DownloadTree(ctx, rootTree, out, opts); err != nil {
+		return fmt.Errorf("could not finish downloading \"My Drive\" files: %w", err)
+	}
+
+	if cfg.Orphans {
+		if err = svc.DownloadTree(ctx, orphans, out, opts); err != nil {
+			return fmt.Errorf("could not finish downloading Shared files: %w", err)
+		}
+	}
+
+	if cfg.Shared {
+		if err = downloadSharedDrives(ctx, svc, cfg, out, opts); err != nil {
+			return err
+		}
+	}
+
+	// keep the previous state if files failed, so they're retried on the next run
+	if cfg.DryRun {
+		return nil
+	}
+	if cfg.Incremental != "" && opts.Stats.Failed > 0 {
+		fmt.Println("not updating incremental state because some files failed to download")
+	} else if cfg.Incremental != "" {
+		if err = state.Write(cfg.Incremental); err != nil {
+			return fmt.Errorf("could not write incremental state: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func downloadSharedDrives(ctx context.Context, svc *drive.Service, cfg *config, out string, opts *drive.DownloadOptions) error {
+	drives, err := svc.SharedDrives(ctx, cfg.SharedRO)
+	if err != nil {
+		return fmt.Errorf("could not list shared drives: %w", err)
+	}
+
+	fmt.Println("found", len(drives), "shared drives")
+
+	out = filepath.Join(out, sharedDrivesDir)
+	for _, d := range drives {
+		files, err := svc.ListSharedDrive(ctx, d.Id)
+		if err != nil {
+			return fmt.Errorf("could not list files in shared drive %s: %w", d.Name, err)
+		}
+
+		fmt.Println("found", len(files), "total files in shared drive", d.Name)
+
+		tree := drive.NewSharedDriveTree(d, files)
+		n, _ := drive.TreeSize(tree)
+		cfg.Notifier.addTotal(n)
+		cfg.State.AddTotal(n)
+		if cfg.ResolveShortcuts {
+			n, err := svc.ResolveShortcuts(ctx, tree)
+			if err != nil {
+				return fmt.Errorf("could not resolve shortcuts in shared drive %s: %w", d.Name, err)
+			}
+			fmt.Println("resolved", n, "shortcuts to files outside of shared drive", d.Name)
+		}
+		if err = drive.ResolveDuplicateFolders(tree, cfg.Duplicates); err != nil {
+			return fmt.Errorf("shared drive %s: %w", d.Name, err)
+		}
+		if opts.Volumes != nil {
+			opts.Volumes.Plan(tree, out)
+		}
+
+		if err = svc.DownloadTree(ctx, tree, out, opts); err != nil {
+			return fmt.Errorf("could not finish downloading shared drive %s: %w", d.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	cfg := new(config)
+	flag.StringVar(&cfg.AuthFile, "authfile", "", "path to service account json file")
+	flag.StringVar(&cfg.User, "user", "", "email of user to download Google Drive files for. With -all-users, the email of an administrator used to list users")
+	flUsersFile := flag.String("users-file", "", "archive each user in the first column of this CSV file to a subdirectory of -out named by their email, instead of -user. Failed users are retried once at the end of the run, and the status of each user is written to batch_report.csv in -out. Exits with 2 if only some users were archived")
+	flAllUsers := flag.Bool("all-users", false, "archive every user in the domain to a subdirectory of -out named by their email. Users are listed with the Admin SDK by impersonating -user, and the https://www.googleapis.com/auth/admin.directory.user.readonly scope must be added to Domain-wide Delegation")
+	flParallelUsers := flag.Int("parallel-users", 1, "with -users-file or -all-users, the number of users archived at the same time")
+	flag.IntVar(&cfg.Downloaders, "downloaders", 0, "the number of files downloaded at the same time for each user. Leave 0 to use the number of CPUs")
+	flAdaptive := flag.Bool("adaptive-concurrency", false, "halve the number of downloads in progress every 10 seconds while Drive returns rate limit errors, and add one back every 10 seconds without them, up to -downloaders (times -parallel-users in batch mode). Overrides set-concurrency commands sent to the -control socket")
+	flMaxDownloads := flag.Int("max-downloads", 0, "limit the number of files downloaded at the same time across all users. Leave 0 for no limit")
+	flag.StringVar(&cfg.Root, "root", "", "the id of the folder to download. Leave empty to download entire Drive")
+	flag.BoolVar(&cfg.Orphans, "orphans", false, "download orphaned files. These are usually Shared Files")
+	flMaxSize := flag.String("max-size", "", "skip files larger than this size, e.g. 50GB, and record them in the manifest with the status skipped. Google files, which have no size, are never skipped")
+	flOrphansMaxSize := flag.String("orphans-max-size", "", "with -orphans, refuse to download if the orphaned files total more than this size, e.g. 500GB. Google files, which have no size, aren't counted")
+	flOrphanBuckets := flag.String("orphans-bucket", "letter", "with -orphans, when there are more than -orphans-bucket-threshold orphaned files, group them into folders by: letter (the first letter of their names), owner (their owner's email), date (the month they were shared with the user, e.g. 2022-06), or none")
+	flag.IntVar(&cfg.OrphanThreshold, "orphans-bucket-threshold", 10000, "with -orphans, the number of orphaned files above which they're grouped into folders by -orphans-bucket")
+	flag.BoolVar(&cfg.OrphansOwned, "orphans-owned-only", false, "with -orphans, only download orphaned files owned by the user, skipping files shared with the user")
+	flag.BoolVar(&cfg.Shared, "shared-drives", false, "download the shared drives the user is a member of and can edit, including each drive's Trash and Lost+Found (files with missing parents)")
+	flag.BoolVar(&cfg.SharedRO, "shared-drives-readonly", false, "with -shared-drives, also download shared drives where the user only has the reader or commenter role")
+	flag.StringVar(&cfg.Out, "out", "", "path to output files to. Will be created if it doesn't already exist")
+	var flIncludes, flExcludes stringsFlag
+	flag.Var(&flIncludes, "include", "only download files whose tree path (starting with the root folder's name, e.g. My Drive/Projects/a.pdf) matches this glob pattern. * and ? match within a path segment, ** matches any number of segments, and patterns without a slash match file names, e.g. *.pdf. Can be given multiple times")
+	flag.Var(&flExcludes, "exclude", "skip files and folders whose tree path matches this glob pattern, e.g. **/node_modules/**. Excluded folders aren't created. Uses the same syntax as -include and can be given multiple times")
+	var flMimeIncludes, flMimeExcludes stringsFlag
+	flag.Var(&flMimeIncludes, "mime-include", "only download files whose mime type starts with one of these comma separated prefixes, e.g. video/ or application/pdf. google matches all Google apps types (Docs, Sheets, etc.). Folders are only created for downloaded files. Can be given multiple times")
+	flag.Var(&flMimeExcludes, "mime-exclude", "skip files whose mime type starts with one of these comma separated prefixes, e.g. video/,audio/. google matches all Google apps types. Can be given multiple times")
+	flSkipNative := flag.Bool("skip-native", false, "only download files stored verbatim, skipping Google Docs, Sheets, Slides, and other Google apps files. The same as -mime-exclude google")
+	flNativeOnly := flag.Bool("native-only", false, "only export Google Docs, Sheets, Slides, and other Google apps files, skipping files stored verbatim. The same as -mime-include google")
+	var flLabels, flLabelExcludes stringsFlag
+	flag.Var(&flLabels, "label", "only download files with this Drive label: a label ID, labelID.fieldID=value (the choice ID for selection fields), or a query in the labels search syntax, e.g. \"labels/labelID.fieldID > 5\". IDs are shown in the Admin console's label manager. Folders are only created for downloaded files. Can be given multiple times; files matching any are downloaded")
+	flag.Var(&flLabelExcludes, "label-exclude", "skip files with this Drive label, in the same formats as -label. Can be given multiple times")
+	flModifiedAfter := flag.String("modified-after", "", "only download files last modified after this time: RFC3339, YYYY-MM-DD, or relative to now, e.g. 90d, 12w, or 1y. Folders are only created for downloaded files")
+	flModifiedBefore := flag.String("modified-before", "", "only download files last modified before this time, e.g. a retention cutoff. Uses the same formats as -modified-after")
+	var flRoutes stringsFlag
+	flag.Var(&flRoutes, "route", "route matching files to another output path, in the form conditions=path. Conditions are a comma separated list of mime type prefixes, >size, or <size, e.g. image/,video/,>1GB=/mnt/cold. Files match if their mime type starts with any of the prefixes and they're larger and smaller than the sizes. Can be given multiple times; the first matching route is used. path can also be an object store URL, s3://bucket/prefix or gs://bucket/prefix: matching files are written under .routes in -out, then uploaded, skipping unchanged objects. S3 credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and optionally AWS_SESSION_TOKEN, AWS_REGION (default us-east-1), and AWS_ENDPOINT_URL for S3 compatible services. GCS buckets are written as the -authfile service account")
+	flag.StringVar(&cfg.Clamd, "clamd", "", "scan downloaded files with clamd at this address (tcp://host:port or unix:///path/to/socket) before they're moved into the archive")
+	flag.DurationVar(&cfg.ClamdTimeout, "clamd-timeout", drive.DefaultClamdTimeout, "with -clamd, the maximum time allowed to scan a file, e.g. 10m. Files whose scans time out fail to download")
+	flag.StringVar(&cfg.Quarantine, "quarantine", "", "path to move infected files to. If empty, infected files are removed. Must be on the same filesystem as -out")
+	flHoldLabel := flag.String("hold-label", "", "the id of a Drive Label to apply to files after they're archived")
+	flHoldFolder := flag.String("hold-folder", "", "the id of a folder to move files into after they're archived")
+	flag.StringVar(&cfg.Incremental, "incremental", "", "path to a state file used for incremental runs. If the file doesn't exist, all files are listed and downloaded and the listing is saved. Otherwise only files reported as changed by the Drive Changes API since the last run are downloaded, without listing all files")
+	flag.StringVar(&cfg.Delta, "delta", "", "path to the manifest.json of a previous archive. Only files created or modified since that archive was captured are downloaded, to a dated directory under -out/delta")
+	flag.BoolVar(&cfg.NDJSON, "manifest-ndjson", false, "also write the manifest's files to manifest.ndjson in -out, one JSON object per line mapping each Drive file id to its local path, metadata, export type, and download status")
+	flag.BoolVar(&cfg.Throughput, "throughput", false, "after downloading, print download results, error rates, and throughput by mime type and by downloader, e.g. to find whether exports or downloads are the bottleneck when tuning -downloaders")
+	flag.BoolVar(&cfg.Merkle, "merkle", false, "after downloading, hash all archived files and record a merkle tree (a digest per directory and a single root digest) in the manifest")
+	flQPS := flag.Float64("qps", 0, "limit the API requests of all downloaders, and all users in batch mode, to this many per second on average, e.g. 10, so they don't all hit Drive's rate limits and back off. Set to 0 for unlimited")
+	flBWLimit := flag.String("bwlimit", "", "limit total download bandwidth to this rate per second, e.g. 10MB. Leave empty for unlimited")
+	var flBWWindows stringsFlag
+	flag.Var(&flBWWindows, "bwlimit-window", "use a different bandwidth limit during a daily (local) time window, in the form HH:MM-HH:MM=rate, e.g. 22:00-06:00=unlimited. Can be given multiple times; the first matching window is used")
+	flControl := flag.String("control", "", "path to a unix socket to listen on for control commands: pause, resume, drain, set-concurrency <n>, status, and status-json")
+	flRetryMaxElapsed := flag.Duration("retry-max-elapsed", 0, "stop retrying a request after this long, e.g. 10m, even if it has tries left. Delays requested by Drive with Retry-After are honored. 0 retries until the tries run out")
+	flWaitQuota := flag.Bool("wait-for-quota", false, "when the project's daily API quota is exceeded, wait for it to reset at midnight Pacific Time and continue. Without this, the run is drained and stops once downloads in progress are finished")
+	flMetricsAddr := flag.String("metrics-addr", "", "serve Prometheus metrics at /metrics on this address, e.g. :9090: the run ID, files finished by result, bytes downloaded, errors by reason, retries, queue depth, active downloads, and per-downloader throughput")
+	flag.BoolVar(&cfg.Catalog, "catalog", false, "keep a catalog of the listing and the state of each file in a .catalog directory in -out. A run that was interrupted can be resumed with its listing instead of listing all files again by passing its -run-id, and the files it captured that haven't changed aren't checked again. Files can be found with -lookup. Not used for resuming with -incremental")
+	flLookup := flag.String("lookup", "", "instead of downloading, print the status and local path of the files in the -catalog of -out with this Drive ID, or, if there are none, whose names contain this text or whose paths match this glob pattern, and exit")
+	flStatus := flag.String("status", "", "instead of downloading, print the JSON status of the run listening on this -control socket and exit")
+	flLayout := flag.String("layout", "tree", "how files are laid out in -out. tree mirrors the Drive folder structure. records writes all files to a flat directory, named by Drive ID, with Google files exported as PDF and a <id>.record.json descriptor for each file")
+	flag.BoolVar(&cfg.SheetsCSV, "sheets-csv", false, "export Google Sheets with the Sheets API as a directory named after the spreadsheet with one CSV file per tab, instead of as XLSX files, which can't be exported if they're larger than 10 MB. Can't be used with -layout records")
+	flag.BoolVar(&cfg.SheetValues, "sheets-values", false, "also export a values-only snapshot of Google Sheets next to their XLSX exports, as a directory named after the spreadsheet with one CSV file per tab, with formulas evaluated to the values the user saw. Can't be used with -sheets-csv, which only exports values, or -layout records")
+	flag.BoolVar(&cfg.PDFRenditions, "also-pdf", false, "also export Google Docs, Sheets, Slides, and Drawings as PDFs next to their editable exports, e.g. report.docx and report.pdf. Can't be used with -layout records, which already exports PDFs")
+	flPDFA := flag.Bool("pdfa", false, "convert exported PDFs to PDF/A. Uses Ghostscript (gs) unless -pdfa-cmd is set")
+	flPDFACmd := flag.String("pdfa-cmd", "", "command used to convert PDFs to PDF/A with -pdfa. {in} and {out} are replaced with the input and output paths")
+	flPDFAValidate := flag.String("pdfa-validate", "", "command used to validate converted PDF/A files, e.g. \"verapdf {in}\". {in} is replaced with the converted path. A non-zero exit status fails validation")
+	flSplitSize := flag.String("split-size", "", "split the archive into numbered volumes (vol001, vol002, ...) of at most this size, e.g. 100GB, each with its own manifest. Folders are kept in a single volume where possible")
+	flag.BoolVar(&cfg.PreferSHA256, "prefer-sha256", false, "check existing and downloaded non-Google files with Drive's SHA-256 checksum instead of MD5 when Drive has one, and record it in the manifest. The checksum is listed with the files, so no extra requests are made")
+	flag.StringVar(&cfg.Checksums, "sha256sums", "", "after downloading, write SHA256SUMS files compatible with sha256sum -c. dir writes a file to each directory and global writes a single file to -out")
+	flag.BoolVar(&cfg.Index, "index-html", false, "after downloading, write an index.html file to each directory linking archived files to their originals in Drive")
+	flag.BoolVar(&cfg.METS, "mets", false, "after downloading, write a mets.xml file to -out describing the archived files with PREMIS metadata: Drive IDs, capture time, fixity, and export and PDF/A conversion events. Use with -sha256sums or -merkle to include SHA-256 fixity")
+	flag.StringVar(&cfg.Report, "report", "", "write a JSON report of the run to this path, with its status (completed, completed_with_errors, drained, or failed), aggregate stats, the status of every file, and the 25 files most recently modified, viewed, or shared with the user. It's written even if the run fails. In batch mode, each user's report is written to their directory with this file name")
+	flag.StringVar(&cfg.ReportFolder, "report-folder", "", "after downloading, create a Google Sheet listing the archived files, the most recently active files, and a summary in the Drive folder with this id")
+	flag.StringVar(&cfg.ReportUser, "report-user", "", "with -report-folder, the email of the user that creates the report, who must be able to add files to the folder. Defaults to -user")
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "list files and print what would be downloaded or skipped, with file counts and total bytes, and check that -out has enough free space, without downloading or writing anything. Exported Google files have no size and aren't counted in total bytes")
+	flPriceSheet := flag.String("price-sheet", "", "with -dry-run, estimate the cost of the run and its storage from this JSON price sheet with the keys currency, egress_per_gib, storage_per_gib_month, requests_per_1000, and months")
+	flag.StringVar(&cfg.IPFS, "ipfs", "", "experimental: after downloading, add the archive to the IPFS node with this RPC API URL, e.g. http://127.0.0.1:5001, pin it, and record each file's CID in the manifest. Files are linked in the node's MFS under /drive-archive/<user>/<run id>")
+	flag.BoolVar(&cfg.IPFSCAR, "ipfs-car", false, "with -ipfs, export the archive from the IPFS node to archive.car in -out")
+	flag.StringVar(&cfg.B2, "b2", "", "after downloading, upload the archive to a Backblaze B2 bucket, in the form bucket or bucket/prefix. The application key is read from the B2_APPLICATION_KEY_ID and B2_APPLICATION_KEY environment variables")
+	flag.StringVar(&cfg.OCFL, "ocfl", "", "after downloading, add the archive as a new version of an OCFL object in the OCFL storage root at this path. Files that are unchanged since the previous version aren't stored again")
+	flag.StringVar(&cfg.OCFLID, "ocfl-id", "", "with -ocfl, the OCFL object id, which is also used as the object's directory name. Defaults to -user")
+	flag.Float64Var(&cfg.MinCompleteness, "min-completeness", 0, "exit with an error if less than this percentage (0-100) of supported files were captured")
+	flag.BoolVar(&cfg.CopyOversized, "copy-oversized", false, "export Google Docs and Slides that are too large to export, even with their export links, by copying them into the user's Drive, exporting the copy, and deleting it")
+	flag.BoolVar(&cfg.CopyRestricted, "copy-restricted", false, "download files whose owner has disabled downloading by copying them into the user's Drive, downloading the copy, and deleting it. Requires that copying is permitted")
+	flag.BoolVar(&cfg.PinRevisions, "pin-revisions", false, "download the revision of each non-Google file that was current when files were listed, so edits made during the run aren't archived. The revision is recorded in the manifest")
+	flag.BoolVar(&cfg.ResolveShortcuts, "resolve-shortcuts", false, "fetch the targets of shortcuts that aren't in the user's listing, e.g. files in shared drives the user isn't a member of, so they can be downloaded. Folder targets are listed recursively")
+	flDuplicates := flag.String("duplicate-folders", "merge", "how sibling folders with the same name are handled. merge downloads their contents to one directory. suffix adds _2, _3, etc. to duplicate folders, ordered by their Drive IDs. fail lists the duplicates and exits before downloading")
+	flag.BoolVar(&cfg.SMB, "smb", false, "-out is an SMB/CIFS share. Names reserved by Windows and trailing dots and spaces are changed, long names are shortened, and downloads are retried if the share is temporarily disconnected")
+	flag.IntVar(&cfg.MaxPathLength, "smb-max-path", 260, "with -smb, shorten file names so full paths are at most this many bytes. Set to 0 for no limit")
+	flag.IntVar(&cfg.ShardThreshold, "shard-threshold", 0, "move the contents of folders with more than this many items into subfolders named by the first two characters of each item's name, e.g. 100000. With -layout records, files are sharded if there are more than this many files. Sharded paths are recorded in the manifest")
+	flFields := flag.String("fields", "", "a comma separated list of extra Drive file fields to request and record in the manifest, e.g. lastModifyingUser,imageMediaMetadata,videoMediaMetadata. Subfields can be selected with / or (), e.g. lastModifyingUser(displayName,emailAddress). Can be set in a -config profile's defaults")
+	flag.BoolVar(&cfg.MediaSidecars, "media-metadata", false, "write the camera time, location, dimensions, and camera model or duration of photos and videos, as extracted by Drive, to a .media.json file next to each file")
+	flag.BoolVar(&cfg.CaptureMtime, "capture-mtime", false, "with -media-metadata, set the modified time of photos to the time they were taken instead of their Drive modified time")
+	flag.BoolVar(&cfg.OCR, "ocr", false, "extract the text of images and PDFs with Drive's OCR to a .ocr.txt file next to each file, so scanned documents are searchable. Each file is temporarily copied into the user's Drive as a Google Doc to be converted")
+	flag.StringVar(&cfg.OCRLanguage, "ocr-language", "", "with -ocr, an ISO 639-1 language code, e.g. en, used as a hint for OCR")
+	flag.BoolVar(&cfg.GC, "gc", false, "before downloading, remove temporary files left in -out and -route paths by interrupted runs. Once files are listed, partial downloads of files deleted or changed in Drive, and -catalog entries of files deleted from Drive, are removed too. Files modified in the last hour are kept in case another run is writing them. The tool doesn't write lock files, so there are none to remove")
+	flag.BoolVar(&cfg.SkipEmptyFolders, "skip-empty-folders", false, "only create directories that files are downloaded to. By default all folders are created, even if they're empty")
+	flRevisions := flag.String("revisions", "", "archive revision history: write each file's revisions, with their keep forever flag, size, modifying user, and modified time, to a "+drive.RevisionsSuffix+" file next to it, and download the previous revisions of non-Google files to a "+drive.RevisionsDirSuffix+" directory next to it: all, or keep-forever to only download revisions marked to be kept forever")
+	flEmptyFolders := flag.String("empty-folders", "", "preserve empty folders for object storage, which has no directories: keep (write a "+drive.KeepFile+" placeholder in each empty folder and list them in the manifest) or manifest (only list them in the manifest)")
+	flag.BoolVar(&cfg.SkipIdentical, "skip-identical-exports", false, "export changed Google Docs, Sheets, etc. to a temporary file and keep the existing file if the contents are identical")
+	flFetch := flag.String("fetch", "", "instead of archiving, download the file with this id (exported like an archived file) to -out and exit. If -out is a directory, the file is saved in it with its Drive name. Use -out - to write the file to stdout")
+	flRestore := flag.String("restore", "", "instead of archiving, upload the archived files in this manifest.json to the Drive folder or Shared Drive given by -restore-to as -user, recreating their folders, and exit. Files keep their modified times, and their descriptions and starred state if the archive was created with -fields description,starred. Files can be chosen with -include, -exclude, -mime-include, -mime-exclude, -modified-after, -modified-before, -restore-id, and -restore-owner, which match manifest paths (e.g. My Drive/Projects/a.pdf) and recorded metadata")
+	flRestoreTo := flag.String("restore-to", "", "with -restore, the id of the Drive folder or Shared Drive to upload files to")
+	flRestoreNative := flag.Bool("restore-native", false, "with -restore, convert exported Docs, Sheets, and Slides (e.g. .docx, .xlsx, and .pptx files) back to Google files with their original names. Sheets exported with -sheets-csv are converted to one spreadsheet per tab")
+	var flRestoreIDs, flRestoreOwners stringsFlag
+	flag.Var(&flRestoreIDs, "restore-id", "with -restore, only restore files with these comma separated Drive ids. Can be given multiple times")
+	flag.Var(&flRestoreOwners, "restore-owner", "with -restore, only restore files owned by these comma separated emails. The archive must have been created with -fields owners. Can be given multiple times")
+	flBenchmark := flag.Int("benchmark", 0, "instead of archiving, benchmark listing, building the tree, and downloading this many synthetic files from an in-process fake Drive server, and exit. Files are downloaded to -out, or a temporary directory that's removed afterwards, with -downloaders. No credentials are needed")
+	flBenchFolders := flag.Int("benchmark-folders", 100, "with -benchmark, the number of folders, nested 10 to a folder")
+	flBenchSize := flag.String("benchmark-size", "64KB", "with -benchmark, the size of each file")
+	flBenchMulti := flag.Int("benchmark-multi-parent", 0, "with -benchmark, put every nth file in a second folder, to measure deduplication. 0 puts each file in one folder")
+	flMigrateTo := flag.String("migrate-to", "", "instead of archiving, copy -user's My Drive (or the -root folder), and their orphaned files with -orphans, to a new folder in the Drive folder or Shared Drive with this id and exit. Files are copied by Drive, so nothing is downloaded. -user must be able to add files to the destination, e.g. as a member of the Shared Drive. Files can be chosen with the same flags as archiving, e.g. -include and -modified-after")
+	flVerify := flag.String("verify", "", "instead of downloading, verify the files in this manifest.json against their recorded sizes and checksums and exit. When every file is checked, files not in the manifest and symlinks that are absolute, broken, or point outside of the archive are also reported")
+	flVerifySample := flag.Float64("verify-sample", 1, "with -verify, check a random fraction (0-1) of files and estimate the archive's integrity from the sample")
+	flVerifySeed := flag.Int64("verify-seed", 0, "with -verify, the seed used to choose sampled files. Use the seed printed by a previous verification to check the same files. Leave 0 to use a random seed")
+	flVerifyDrive := flag.Bool("verify-drive", false, "with -verify, also compare the manifest to the current Drive metadata of -user (requires -authfile) to find files changed in Drive or not archived")
+	flVerifyRemote := flag.Bool("verify-remote-only", false, "with -verify, only compare the manifest to the current Drive metadata of -user (requires -authfile) without reading local files, to quickly find archived files that were changed or deleted in Drive and files that weren't archived. Files are compared by md5 and SHA-256 checksum, head revision, and modified time. SHA-256 checksums are listed with the files, so files Drive has no md5 checksum for are compared by content too")
+	flVerifyTime := flag.Duration("verify-time", 0, "with -verify, stop checking files after this duration, e.g. 2h, and estimate the archive's integrity from the files checked")
+	flLogLevel := flag.String("log-level", "info", "the minimum level of file log entries printed: debug, info, warn, or error. Created directories are logged at debug")
+	flLogFormat := flag.String("log-format", "text", "the format of file log entries: text, or json for one object per line with the file's ID, path, size, and download duration")
+	flag.StringVar(&cfg.PseudonymKey, "pseudonymize-key", "", "replace file and folder names in logs with hashes keyed with this secret. The same key always gives the same names, so logs can be correlated by someone with the key")
+	flag.BoolVar(&cfg.ReadOnly, "readonly", false, "only request the https://www.googleapis.com/auth/drive.readonly scope. Only that scope needs to be granted in Domain-wide Delegation. Can't be used with -hold-label, -hold-folder, -copy-restricted, or -copy-oversized")
+	var flWebhooks stringsFlag
+	flag.Var(&flWebhooks, "webhook", "post run start, progress, and completion or failure notifications to this Slack or Google Chat incoming webhook URL. Can be given multiple times")
+	flWebhookEvery := flag.Float64("webhook-progress", 0, "with -webhook, post a progress notification every time this percentage of files is finished, e.g. 10. Set to 0 to disable progress notifications")
+	flag.StringVar(&cfg.RunID, "run-id", "", "the id used to identify this run in logs and records. With -catalog, set to the id of a run that didn't finish to resume it with its listing. Leave empty to generate a new id")
+	flConfig := flag.String("config", "", "path to a json config file defining named profiles, in the form {\"profiles\": {\"name\": {\"authfile\": \"...\", \"domain\": \"example.com\", \"allowed_users\": [\"@example.com\"], \"defaults\": {\"flag\": \"value\"}}}}")
+	flProfile := flag.String("profile", "", "the name of the profile in -config to use. The profile's authfile and defaults are used for flags not given on the command line, and its domain is appended to -user if it has no domain. Users outside of the profile's allowed_users (a list of emails or @domain) or domain are refused")
+	flag.BoolVar(&cfg.LowMemory, "low-memory", false, "reduce memory use for small VMs and NAS devices: default to 2 -downloaders and 1 -parallel-users, export spreadsheets with the Sheets API in smaller chunks, and collect garbage more often. This lowers the memory used while downloading, but not by the listing: the full listing and file tree are kept in memory, so memory use still grows with the number of files in the Drive. Flags given on the command line or by -profile take precedence")
+	flHelp := flag.Bool("help", false, "display this help information")
+
+	flag.Parse()
+
+	if *flHelp {
+		flag.Usage()
+		os.Exit(0)
+	}
+
+	if (*flConfig == "") != (*flProfile == "") {
+		flag.Usage()
+		fmt.Println("\n-config and -profile must be used together")
+		os.Exit(-1)
+	}
+
+	var prof *profile
+	if *flProfile != "" {
+		p, err := readProfile(*flConfig, *flProfile)
+		if err == nil {
+			err = p.apply()
+		}
+		if err != nil {
+			flag.Usage()
+			fmt.Printf("\ninvalid -profile %s: %v\n", *flProfile, err)
+			os.Exit(-1)
+		}
+		if cfg.User != "" && !p.allowed(cfg.User) {
+			fmt.Printf("refusing to impersonate %s: not allowed by profile %s\n", cfg.User, *flProfile)
+			os.Exit(-1)
+		}
+		prof = p
+	}
+
+	if cfg.LowMemory {
+		// applied after -profile, so its defaults take precedence
+		if err := lowMemory.apply(); err != nil {
+			fmt.Println("could not apply -low-memory:", err)
+			os.Exit(-1)
+		}
+		debug.SetGCPercent(lowMemoryGCPercent)
+	}
+
+	if *flStatus != "" {
+		if err := printStatus(*flStatus); err != nil {
+			fmt.Println("could not get status:", err)
+			os.Exit(-1)
+		}
+		os.Exit(0)
+	}
+
+	if *flLookup != "" {
+		if cfg.Out == "" {
+			flag.Usage()
+			fmt.Println("\n-lookup requires -out")
+			os.Exit(-1)
+		}
+		if err := lookup(cfg.Out, *flLookup); err != nil {
+			fmt.Println("could not look up files:", err)
+			os.Exit(-1)
+		}
+		os.Exit(0)
+	}
+
+	if *flBenchmark != 0 {
+		size, err := parseSize(*flBenchSize)
+		if err != nil || *flBenchmark < 0 || *flBenchFolders < 0 || *flBenchMulti < 0 {
+			flag.Usage()
+			fmt.Println("\n-benchmark, -benchmark-folders, -benchmark-size, and -benchmark-multi-parent must not be negative")
+			os.Exit(-1)
+		}
+		opts := &drive.BenchmarkOptions{
+			Files:       *flBenchmark,
+			Folders:     *flBenchFolders,
+			Size:        size,
+			MultiParent: *flBenchMulti,
+			Downloaders: cfg.Downloaders,
+			// per-file log entries would be measured too
+			Logger: drive.NewWriterLogger(os.Stdout, drive.LogWarn, false),
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err = benchmark(ctx, cfg.Out, opts)
+		stop()
+		if err != nil {
+			fmt.Println("could not run benchmark:", err)
+			os.Exit(-1)
+		}
+		os.Exit(0)
+	}
+
+	if *flVerify != "" {
+		if *flVerifySample <= 0 || *flVerifySample > 1 {
+			flag.Usage()
+			fmt.Println("\n-verify-sample must be greater than 0 and at most 1")
+			os.Exit(-1)
+		}
+		if *flVerifyRemote {
+			if cfg.AuthFile == "" || cfg.User == "" {
+				flag.Usage()
+				fmt.Println("\n-verify-remote-only requires -authfile and -user")
+				os.Exit(-1)
+			}
+			if err := verifyRemote(*flVerify, cfg.AuthFile, cfg.User); err != nil {
+				fmt.Println("verification failed:", err)
+				os.Exit(-1)
+			}
+			os.Exit(0)
+		}
+		authFile := ""
+		if *flVerifyDrive {
+			if cfg.AuthFile == "" || cfg.User == "" {
+				flag.Usage()
+				fmt.Println("\n-verify-drive requires -authfile and -user")
+				os.Exit(-1)
+			}
+			authFile = cfg.AuthFile
+		}
+		seed := *flVerifySeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		if err := verify(*flVerify, *flVerifySample, seed, *flVerifyTime, authFile, cfg.User); err != nil {
+			fmt.Println("verification failed:", err)
+			os.Exit(-1)
+		}
+		os.Exit(0)
+	}
+
+	if *flVerifyDrive || *flVerifyRemote {
+		flag.Usage()
+		fmt.Println("\n-verify-drive and -verify-remote-only cannot be used without -verify")
+		os.Exit(-1)
+	}
+
+	if cfg.AuthFile == "" {
+		flag.Usage()
+		fmt.Println("\n-authfile must be set")
+		os.Exit(-1)
+	}
+
+	if *flUsersFile != "" && *flAllUsers {
+		flag.Usage()
+		fmt.Println("\n-users-file and -all-users cannot be used together")
+		os.Exit(-1)
+	}
+
+	batch := *flUsersFile != "" || *flAllUsers
+
+	if cfg.User == "" && *flUsersFile == "" {
+		flag.Usage()
+		fmt.Println("\n-user must be set")
+		os.Exit(-1)
+	}
+
+	if *flUsersFile != "" && cfg.User != "" {
+		flag.Usage()
+		fmt.Println("\n-user cannot be used with -users-file")
+		os.Exit(-1)
+	}
+
+	if batch && (cfg.Root != "" || cfg.Delta != "" || cfg.Incremental != "" || cfg.OCFLID != "" || len(flRoutes) > 0) {
+		flag.Usage()
+		fmt.Println("\n-root, -delta, -incremental, -ocfl-id, and -route cannot be used with -users-file or -all-users")
+		os.Exit(-1)
+	}
+
+	if *flParallelUsers != 1 && !batch {
+		flag.Usage()
+		fmt.Println("\n-parallel-users cannot be used without -users-file or -all-users")
+		os.Exit(-1)
+	}
+
+	if cfg.Out == "" && *flRestore == "" && *flMigrateTo == "" {
+		flag.Usage()
+		fmt.Println("\n-out must be set")
+		os.Exit(-1)
+	}
+
+	if *flFetch != "" && batch {
+		flag.Usage()
+		fmt.Println("\n-fetch cannot be used with -users-file or -all-users")
+		os.Exit(-1)
+	}
 
-	if root == "" {
-		root, err = svc.Root()
+	if *flRestore != "" && (batch || *flFetch != "" || cfg.ReadOnly) {
+		flag.Usage()
+		fmt.Println("\n-restore cannot be used with -users-file, -all-users, -fetch, or -readonly")
+		os.Exit(-1)
+	}
+
+	if *flMigrateTo != "" && (batch || *flFetch != "" || *flRestore != "" || cfg.ReadOnly) {
+		flag.Usage()
+		fmt.Println("\n-migrate-to cannot be used with -users-file, -all-users, -fetch, -restore, or -readonly")
+		os.Exit(-1)
+	}
+
+	if (*flRestore == "") != (*flRestoreTo == "") {
+		flag.Usage()
+		fmt.Println("\n-restore and -restore-to must be used together")
+		os.Exit(-1)
+	}
+
+	if *flRestore == "" && (len(flRestoreIDs) > 0 || len(flRestoreOwners) > 0 || *flRestoreNative) {
+		flag.Usage()
+		fmt.Println("\n-restore-id, -restore-owner, and -restore-native cannot be used without -restore")
+		os.Exit(-1)
+	}
+
+	if cfg.Out == "-" && *flFetch == "" {
+		flag.Usage()
+		fmt.Println("\n-out - can only be used with -fetch")
+		os.Exit(-1)
+	}
+
+	if cfg.Root != "" && cfg.Orphans {
+		flag.Usage()
+		fmt.Println("\n-orphans cannot be used when -root is set")
+		os.Exit(-1)
+	}
+
+	if (*flOrphansMaxSize != "" || cfg.OrphansOwned) && !cfg.Orphans {
+		flag.Usage()
+		fmt.Println("\n-orphans-max-size and -orphans-owned-only cannot be used without -orphans")
+		os.Exit(-1)
+	}
+
+	if *flMaxSize != "" {
+		size, err := parseSize(*flMaxSize)
+		if err != nil || size == 0 {
+			flag.Usage()
+			fmt.Printf("\ninvalid -max-size %s: must be a positive size\n", *flMaxSize)
+			os.Exit(-1)
+		}
+		cfg.MaxSize = size
+	}
+
+	if *flOrphansMaxSize != "" {
+		size, err := parseSize(*flOrphansMaxSize)
 		if err != nil {
-			return fmt.Errorf("could not get root id: %w", err)
+			flag.Usage()
+			fmt.Printf("\ninvalid -orphans-max-size %s: %v\n", *flOrphansMaxSize, err)
+			os.Exit(-1)
+		}
+		cfg.OrphansMaxSize = size
+	}
+
+	if cfg.Root != "" && cfg.Shared {
+		flag.Usage()
+		fmt.Println("\n-shared-drives cannot be used when -root is set")
+		os.Exit(-1)
+	}
+
+	if cfg.SharedRO && !cfg.Shared {
+		flag.Usage()
+		fmt.Println("\n-shared-drives-readonly cannot be used without -shared-drives")
+		os.Exit(-1)
+	}
+
+	if *flPriceSheet != "" {
+		if !cfg.DryRun {
+			flag.Usage()
+			fmt.Println("\n-price-sheet cannot be used without -dry-run")
+			os.Exit(-1)
+		}
+		p, err := readPriceSheet(*flPriceSheet)
+		if err != nil {
+			fmt.Println("could not read -price-sheet:", err)
+			os.Exit(-1)
 		}
+		cfg.Prices = p
+	}
+
+	if cfg.IPFSCAR && cfg.IPFS == "" {
+		flag.Usage()
+		fmt.Println("\n-ipfs-car cannot be used without -ipfs")
+		os.Exit(-1)
+	}
+
+	if cfg.B2 != "" && (os.Getenv("B2_APPLICATION_KEY_ID") == "" || os.Getenv("B2_APPLICATION_KEY") == "") {
+		flag.Usage()
+		fmt.Println("\n-b2 requires the B2_APPLICATION_KEY_ID and B2_APPLICATION_KEY environment variables")
+		os.Exit(-1)
+	}
+
+	if cfg.Incremental != "" && (cfg.Delta != "" || cfg.Shared || cfg.Root != "") {
+		flag.Usage()
+		fmt.Println("\n-incremental cannot be used with -delta, -shared-drives, or -root")
+		os.Exit(-1)
+	}
+
+	if cfg.Quarantine != "" && cfg.Clamd == "" {
+		flag.Usage()
+		fmt.Println("\n-quarantine cannot be used without -clamd")
+		os.Exit(-1)
+	}
+	if cfg.ClamdTimeout <= 0 {
+		flag.Usage()
+		fmt.Println("\n-clamd-timeout must be positive")
+		os.Exit(-1)
+	}
+
+	if cfg.ReadOnly && (*flHoldLabel != "" || *flHoldFolder != "" || cfg.CopyRestricted || cfg.CopyOversized || cfg.OCR || (cfg.ReportFolder != "" && cfg.ReportUser == "")) {
+		flag.Usage()
+		fmt.Println("\n-hold-label, -hold-folder, -copy-restricted, -copy-oversized, -ocr, and -report-folder without -report-user modify files and cannot be used with -readonly")
+		os.Exit(-1)
 	}
 
-	files, err := svc.List()
+	fields, err := parseFields(*flFields)
 	if err != nil {
-		return fmt.Errorf("could not list files: %w", err)
+		flag.Usage()
+		fmt.Printf("\ninvalid -fields %s: %v\n", *flFields, err)
+		os.Exit(-1)
 	}
+	cfg.ExtraFields = fields
 
-	fmt.Println("found", len(files), "total files")
+	if cfg.CaptureMtime && !cfg.MediaSidecars {
+		flag.Usage()
+		fmt.Println("\n-capture-mtime cannot be used without -media-metadata")
+		os.Exit(-1)
+	}
 
-	rootTree, orphans := drive.NewTree(root, files)
+	if cfg.OCRLanguage != "" && !cfg.OCR {
+		flag.Usage()
+		fmt.Println("\n-ocr-language cannot be used without -ocr")
+		os.Exit(-1)
+	}
 
-	if err = svc.DownloadTree(rootTree, out, 0); err != nil {
-		return fmt.Errorf("could not finish downloading \"My Drive\" files: %w", err)
+	if cfg.ReportUser != "" && cfg.ReportFolder == "" {
+		flag.Usage()
+		fmt.Println("\n-report-user cannot be used without -report-folder")
+		os.Exit(-1)
 	}
 
-	if downloadOrphans {
-		if err = svc.DownloadTree(orphans, out, 0); err != nil {
-			return fmt.Errorf("could not finish downloading Shared files: %w", err)
+	for _, r := range flRoutes {
+		route, err := parseRoute(r)
+		if err != nil {
+			flag.Usage()
+			fmt.Printf("\ninvalid -route %s: %v\n", r, err)
+			os.Exit(-1)
+		}
+		if strings.HasPrefix(route.Dest, "s3://") && (os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "") {
+			flag.Usage()
+			fmt.Println("\n-route to s3:// requires the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+			os.Exit(-1)
 		}
+		cfg.Router = append(cfg.Router, route)
 	}
 
-	fmt.Println("done!")
+	if *flSkipNative && *flNativeOnly {
+		flag.Usage()
+		fmt.Println("\n-skip-native and -native-only cannot be used together")
+		os.Exit(-1)
+	}
+	if *flSkipNative {
+		flMimeExcludes = append(flMimeExcludes, "google")
+	}
+	if *flNativeOnly {
+		flMimeIncludes = append(flMimeIncludes, "google")
+	}
 
-	return nil
-}
+	if len(flIncludes) > 0 || len(flExcludes) > 0 || len(flMimeIncludes) > 0 || len(flMimeExcludes) > 0 || *flModifiedAfter != "" || *flModifiedBefore != "" {
+		cfg.Filter = &drive.Filter{MimeInclude: parseMimes(flMimeIncludes), MimeExclude: parseMimes(flMimeExcludes)}
+	}
+	for _, l := range flLabels {
+		q, err := drive.LabelQuery(l)
+		if err != nil {
+			flag.Usage()
+			fmt.Printf("\ninvalid -label: %v\n", err)
+			os.Exit(-1)
+		}
+		cfg.LabelInclude = append(cfg.LabelInclude, q)
+	}
+	for _, l := range flLabelExcludes {
+		q, err := drive.LabelQuery(l)
+		if err != nil {
+			flag.Usage()
+			fmt.Printf("\ninvalid -label-exclude: %v\n", err)
+			os.Exit(-1)
+		}
+		cfg.LabelExclude = append(cfg.LabelExclude, q)
+	}
 
-func main() {
-	flAuthJSON := flag.String("authfile", "", "path to service account json file")
-	flUser := flag.String("user", "", "email of user to download Google Drive files for")
-	flRoot := flag.String("root", "", "the id of the folder to download. Leave empty to download entire Drive")
-	flOrphans := flag.Bool("orphans", false, "download orphaned files. These are usually Shared Files")
-	flOut := flag.String("out", "", "path to output files to. Will be created if it doesn't already exist")
-	flHelp := flag.Bool("help", false, "display this help information")
+	now := time.Now()
+	if *flModifiedAfter != "" {
+		t, err := parseTime(*flModifiedAfter, now)
+		if err != nil {
+			flag.Usage()
+			fmt.Printf("\ninvalid -modified-after: %v\n", err)
+			os.Exit(-1)
+		}
+		cfg.Filter.ModifiedAfter = t
+	}
+	if *flModifiedBefore != "" {
+		t, err := parseTime(*flModifiedBefore, now)
+		if err != nil {
+			flag.Usage()
+			fmt.Printf("\ninvalid -modified-before: %v\n", err)
+			os.Exit(-1)
+		}
+		cfg.Filter.ModifiedBefore = t
+	}
+	if cfg.Filter != nil && !cfg.Filter.ModifiedAfter.IsZero() && !cfg.Filter.ModifiedBefore.IsZero() && !cfg.Filter.ModifiedAfter.Before(cfg.Filter.ModifiedBefore) {
+		flag.Usage()
+		fmt.Println("\n-modified-after must be before -modified-before")
+		os.Exit(-1)
+	}
+	for _, p := range flIncludes {
+		g, err := drive.CompileGlob(p)
+		if err != nil {
+			flag.Usage()
+			fmt.Printf("\ninvalid -include: %v\n", err)
+			os.Exit(-1)
+		}
+		cfg.Filter.Include = append(cfg.Filter.Include, g)
+	}
+	for _, p := range flExcludes {
+		g, err := drive.CompileGlob(p)
+		if err != nil {
+			flag.Usage()
+			fmt.Printf("\ninvalid -exclude: %v\n", err)
+			os.Exit(-1)
+		}
+		cfg.Filter.Exclude = append(cfg.Filter.Exclude, g)
+	}
 
-	flag.Parse()
+	switch *flLayout {
+	case "tree":
+		cfg.Layout = drive.LayoutTree
+	case "records":
+		cfg.Layout = drive.LayoutRecords
+	default:
+		flag.Usage()
+		fmt.Println("\n-layout must be tree or records")
+		os.Exit(-1)
+	}
 
-	if *flHelp {
+	if cfg.PDFRenditions && cfg.Layout == drive.LayoutRecords {
 		flag.Usage()
-		os.Exit(0)
+		fmt.Println("\n-also-pdf cannot be used with -layout records")
+		os.Exit(-1)
 	}
 
-	if *flAuthJSON == "" {
+	if cfg.SheetsCSV && cfg.Layout == drive.LayoutRecords {
 		flag.Usage()
-		fmt.Println("\n-authfile must be set")
+		fmt.Println("\n-sheets-csv cannot be used with -layout records")
 		os.Exit(-1)
 	}
 
-	if *flUser == "" {
+	if cfg.SheetValues && cfg.Layout == drive.LayoutRecords {
 		flag.Usage()
-		fmt.Println("\n-user must be set")
+		fmt.Println("\n-sheets-values cannot be used with -layout records")
 		os.Exit(-1)
 	}
 
-	if *flOut == "" {
+	if cfg.SheetValues && cfg.SheetsCSV {
 		flag.Usage()
-		fmt.Println("\n-out must be set")
+		fmt.Println("\n-sheets-values cannot be used with -sheets-csv")
 		os.Exit(-1)
 	}
 
-	if *flRoot != "" && *flOrphans {
+	switch *flEmptyFolders {
+	case "":
+		cfg.EmptyFolders = drive.EmptyFoldersNone
+	case "manifest":
+		cfg.EmptyFolders = drive.EmptyFoldersManifest
+	case "keep":
+		cfg.EmptyFolders = drive.EmptyFoldersKeep
+	default:
 		flag.Usage()
-		fmt.Println("\n-orphans cannot be used when -root is set")
+		fmt.Println("\n-empty-folders must be keep or manifest")
+		os.Exit(-1)
+	}
+
+	switch *flRevisions {
+	case "":
+		cfg.Revisions = drive.RevisionsNone
+	case "all":
+		cfg.Revisions = drive.RevisionsAll
+	case "keep-forever":
+		cfg.Revisions = drive.RevisionsKeepForever
+	default:
+		flag.Usage()
+		fmt.Println("\n-revisions must be all or keep-forever")
+		os.Exit(-1)
+	}
+
+	switch *flOrphanBuckets {
+	case "none":
+		cfg.OrphanBuckets = drive.OrphanBucketsNone
+	case "letter":
+		cfg.OrphanBuckets = drive.OrphanBucketsLetter
+	case "owner":
+		cfg.OrphanBuckets = drive.OrphanBucketsOwner
+	case "date":
+		cfg.OrphanBuckets = drive.OrphanBucketsDate
+	default:
+		flag.Usage()
+		fmt.Println("\n-orphans-bucket must be letter, owner, date, or none")
+		os.Exit(-1)
+	}
+
+	if cfg.OrphanThreshold < 1 {
+		flag.Usage()
+		fmt.Println("\n-orphans-bucket-threshold must be at least 1")
+		os.Exit(-1)
+	}
+
+	switch *flDuplicates {
+	case "merge":
+		cfg.Duplicates = drive.DuplicateMerge
+	case "suffix":
+		cfg.Duplicates = drive.DuplicateSuffix
+	case "fail":
+		cfg.Duplicates = drive.DuplicateFail
+	default:
+		flag.Usage()
+		fmt.Println("\n-duplicate-folders must be merge, suffix, or fail")
+		os.Exit(-1)
+	}
+
+	if (*flPDFACmd != "" || *flPDFAValidate != "") && !*flPDFA {
+		flag.Usage()
+		fmt.Println("\n-pdfa-cmd and -pdfa-validate cannot be used without -pdfa")
+		os.Exit(-1)
+	}
+
+	if *flPDFA {
+		cfg.PDFA = &drive.PDFAConverter{Command: drive.DefaultPDFACommand, Validate: strings.Fields(*flPDFAValidate)}
+		if *flPDFACmd != "" {
+			cfg.PDFA.Command = strings.Fields(*flPDFACmd)
+		}
+	}
+
+	if cfg.Checksums != "" && cfg.Checksums != "dir" && cfg.Checksums != "global" {
+		flag.Usage()
+		fmt.Println("\n-sha256sums must be dir or global")
+		os.Exit(-1)
+	}
+
+	if *flSplitSize != "" {
+		size, err := parseSize(*flSplitSize)
+		if err != nil || size == 0 {
+			flag.Usage()
+			fmt.Printf("\ninvalid -split-size %s: must be a positive size\n", *flSplitSize)
+			os.Exit(-1)
+		}
+		cfg.SplitSize = size
+	}
+
+	if *flBWLimit != "" || len(flBWWindows) > 0 {
+		cfg.Throttle = new(drive.Throttle)
+		if *flBWLimit != "" {
+			rate, err := parseRate(*flBWLimit)
+			if err != nil {
+				flag.Usage()
+				fmt.Printf("\ninvalid -bwlimit %s: %v\n", *flBWLimit, err)
+				os.Exit(-1)
+			}
+			cfg.Throttle.Rate = rate
+		}
+		for _, win := range flBWWindows {
+			w, err := parseWindow(win)
+			if err != nil {
+				flag.Usage()
+				fmt.Printf("\ninvalid -bwlimit-window %s: %v\n", win, err)
+				os.Exit(-1)
+			}
+			cfg.Throttle.Windows = append(cfg.Throttle.Windows, w)
+		}
+	}
+
+	if *flQPS < 0 {
+		flag.Usage()
+		fmt.Println("\n-qps must be positive, or 0 for unlimited")
+		os.Exit(-1)
+	}
+	if *flQPS > 0 {
+		cfg.RateLimit = drive.NewRateLimiter(*flQPS, 0)
+	}
+
+	cfg.ResumeRun = cfg.RunID != ""
+	if cfg.RunID == "" {
+		id, err := newRunID()
+		if err != nil {
+			fmt.Println("could not generate run id:", err)
+			os.Exit(-1)
+		}
+		cfg.RunID = id
+	}
+
+	level, err := drive.ParseLogLevel(*flLogLevel)
+	if err != nil {
+		flag.Usage()
+		fmt.Printf("\ninvalid -log-level: %v\n", err)
+		os.Exit(-1)
+	}
+	if *flLogFormat != "text" && *flLogFormat != "json" {
+		flag.Usage()
+		fmt.Println("\n-log-format must be text or json")
 		os.Exit(-1)
 	}
+	logOut := io.Writer(os.Stdout)
+	if *flFetch != "" {
+		// stdout may be the fetched file
+		logOut = os.Stderr
+	}
+	cfg.Logger = drive.NewWriterLogger(logOut, level, *flLogFormat == "json")
+
+	if *flFetch != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := fetch(ctx, cfg, *flFetch)
+		stop()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "could not fetch file:", drive.Redact(err.Error()))
+			os.Exit(-1)
+		}
+		os.Exit(0)
+	}
+
+	if *flRestore != "" {
+		opts := &drive.RestoreOptions{Filter: cfg.Filter, Native: *flRestoreNative, DryRun: cfg.DryRun}
+		if len(flRestoreIDs) > 0 {
+			opts.IDs = make(map[string]bool)
+			for _, id := range splitList(flRestoreIDs) {
+				opts.IDs[id] = true
+			}
+		}
+		if len(flRestoreOwners) > 0 {
+			opts.Owners = make(map[string]bool)
+			for _, email := range splitList(flRestoreOwners) {
+				opts.Owners[strings.ToLower(email)] = true
+			}
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := restore(ctx, cfg, *flRestore, *flRestoreTo, opts)
+		stop()
+		if err != nil {
+			fmt.Println("could not restore files:", drive.Redact(err.Error()))
+			os.Exit(-1)
+		}
+		os.Exit(0)
+	}
+
+	if *flMigrateTo != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := migrate(ctx, cfg, *flMigrateTo)
+		stop()
+		if err != nil {
+			fmt.Println("could not migrate files:", drive.Redact(err.Error()))
+			os.Exit(-1)
+		}
+		os.Exit(0)
+	}
 
-	if err := os.MkdirAll(*flOut, 0755); err != nil {
+	if err := os.MkdirAll(cfg.Out, 0755); err != nil {
 		fmt.Println("could not create output directory:", err)
 		os.Exit(-1)
 	}
 
-	err := run(*flAuthJSON, *flUser, *flRoot, *flOut, *flOrphans)
+	for _, route := range cfg.Router {
+		if err := newRouteStore(cfg, route); err != nil {
+			fmt.Println("could not create route object store:", err)
+			os.Exit(-1)
+		}
+		if err := os.MkdirAll(route.Dest, 0755); err != nil {
+			fmt.Println("could not create route output directory:", err)
+			os.Exit(-1)
+		}
+	}
+
+	if cfg.Quarantine != "" {
+		if err := os.MkdirAll(cfg.Quarantine, 0700); err != nil {
+			fmt.Println("could not create quarantine directory:", err)
+			os.Exit(-1)
+		}
+	}
+
+	if *flHoldLabel != "" || *flHoldFolder != "" {
+		f, err := os.OpenFile(filepath.Join(cfg.Out, "holds.csv"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Println("could not open hold log:", err)
+			os.Exit(-1)
+		}
+		defer f.Close()
+		cfg.Hold = &drive.Hold{LabelID: *flHoldLabel, FolderID: *flHoldFolder, Log: f}
+	}
+
+	if *flControl != "" {
+		cfg.Control = drive.NewControl()
+		cfg.State = drive.NewRunState(cfg.RunID)
+		cfg.State.Control = cfg.Control
+		l, err := serveControl(*flControl, cfg.Control, cfg.State)
+		if err != nil {
+			fmt.Println("could not start control socket:", err)
+			os.Exit(-1)
+		}
+		defer l.Close()
+	}
+
+	if *flAdaptive {
+		if cfg.Control == nil {
+			cfg.Control = drive.NewControl()
+		}
+		downloaders := cfg.Downloaders
+		if downloaders < 1 {
+			downloaders = runtime.NumCPU()
+		}
+		a := &drive.AdaptiveConcurrency{
+			Control:  cfg.Control,
+			Min:      1,
+			Max:      downloaders * *flParallelUsers,
+			Interval: 10 * time.Second,
+			OnChange: func(n int, errs int64) {
+				if errs > 0 {
+					fmt.Printf("adaptive concurrency: %d rate limit errors; reduced concurrency to %d\n", errs, n)
+				} else {
+					fmt.Println("adaptive concurrency: increased concurrency to", n)
+				}
+			},
+		}
+		go a.Run(context.Background())
+	}
+
+	if *flMetricsAddr != "" {
+		cfg.Metrics = drive.NewMetrics(cfg.RunID)
+		l, err := serveMetrics(*flMetricsAddr, cfg.Metrics)
+		if err != nil {
+			fmt.Println("could not start metrics server:", err)
+			os.Exit(-1)
+		}
+		defer l.Close()
+		fmt.Println("serving metrics on", l.Addr())
+	}
+
+	if *flWebhookEvery != 0 && len(flWebhooks) == 0 {
+		flag.Usage()
+		fmt.Println("\n-webhook-progress cannot be used without -webhook")
+		os.Exit(-1)
+	}
+	if *flWebhookEvery < 0 || *flWebhookEvery > 100 {
+		flag.Usage()
+		fmt.Println("\n-webhook-progress must be between 0 and 100")
+		os.Exit(-1)
+	}
+
+	if *flRetryMaxElapsed < 0 {
+		flag.Usage()
+		fmt.Println("\n-retry-max-elapsed must not be negative")
+		os.Exit(-1)
+	}
+	drive.MaxRetryElapsed = *flRetryMaxElapsed
+	drive.WaitForQuotaReset = *flWaitQuota
+	if cfg.Control == nil {
+		// drains the run when it's interrupted, or the daily quota is exceeded
+		cfg.Control = drive.NewControl()
+	}
+
+	if *flMaxDownloads > 0 {
+		cfg.Control.SetConcurrency(*flMaxDownloads)
+	}
+
+	var users []string
+	switch {
+	case *flUsersFile != "":
+		list, err := readUsers(*flUsersFile)
+		if err != nil {
+			fmt.Println("could not read users:", err)
+			os.Exit(-1)
+		}
+		users = list
+	case *flAllUsers:
+		list, err := drive.ListUsers(context.Background(), cfg.AuthFile, cfg.User, time.Second, 8)
+		if err != nil {
+			fmt.Println("could not list users:", err)
+			os.Exit(-1)
+		}
+		users = list
+	}
+
+	if prof != nil {
+		for _, user := range users {
+			if !prof.allowed(user) {
+				fmt.Printf("refusing to impersonate %s: not allowed by profile %s\n", user, *flProfile)
+				os.Exit(-1)
+			}
+		}
+	}
+
+	if len(flWebhooks) > 0 {
+		cfg.Notifier = newNotifier(flWebhooks, fmt.Sprintf("drive-archive run %s: ", cfg.RunID), *flWebhookEvery)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// the first signal lets downloads in progress finish, so their files and the manifest are written
+		<-sigs
+		fmt.Println("interrupted: finishing downloads in progress. Interrupt again to stop them")
+		cfg.Control.Drain()
+		<-sigs
+		// partial files are removed when their downloads are canceled, and a third signal exits immediately
+		signal.Stop(sigs)
+		fmt.Println("interrupted: stopping downloads in progress. Interrupt again to exit immediately")
+		cancel()
+	}()
+
+	runAll := func() error { return run(ctx, cfg) }
+	if batch {
+		// users share the parsed key and connections
+		pool, err := drive.NewServicePool(cfg.AuthFile, time.Second, 8)
+		if err != nil {
+			fmt.Println("could not create service pool:", err)
+			os.Exit(-1)
+		}
+		cfg.Pool = pool
+		runAll = func() error { return runBatch(ctx, cfg, users, *flParallelUsers) }
+	}
+
+	err = runAll()
+	if cfg.Control.Draining() || ctx.Err() != nil {
+		fmt.Println(resumeHint(cfg))
+	}
 	if err != nil {
-		fmt.Println("could not download files:", err)
+		msg := drive.Redact(err.Error())
+		cfg.Notifier.post("failed: " + msg)
+		cfg.Notifier.wait()
+		fmt.Println("could not download files:", msg)
+		// exit with 2 if some users in a batch were archived, and 3 if the run finished but some files failed
+		var (
+			bErr *batchError
+			fErr *failedFilesError
+		)
+		switch {
+		case errors.As(err, &bErr) && bErr.partial():
+			os.Exit(2)
+		case errors.As(err, &fErr):
+			os.Exit(3)
+		}
 		os.Exit(-1)
 	}
+	cfg.Notifier.wait()
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/korylprince/drive-archive/drive"
+)
+
+// notifier posts run notifications to Slack or Google Chat incoming webhooks, which both accept {"text": "..."}
+type notifier struct {
+	URLs   []string
+	Prefix string
+	// Every is the percentage of files between progress notifications. If zero, no progress notifications are sent
+	Every float64
+
+	client *http.Client
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	total int
+	done  int
+	next  float64
+}
+
+func newNotifier(urls []string, prefix string, every float64) *notifier {
+	return &notifier{URLs: urls, Prefix: prefix, Every: every, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// post sends text to each webhook in the background
+func (n *notifier) post(text string) {
+	if n == nil {
+		return
+	}
+	buf, err := json.Marshal(map[string]string{"text": n.Prefix + text})
+	if err != nil {
+		return
+	}
+	for _, u := range n.URLs {
+		n.wg.Add(1)
+		go func(u string) {
+			defer n.wg.Done()
+			resp, err := n.client.Post(u, "application/json", bytes.NewReader(buf))
+			if err != nil {
+				fmt.Println("could not post notification:", drive.Redact(err.Error()))
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				fmt.Println("could not post notification:", resp.Status)
+			}
+		}(u)
+	}
+}
+
+// wait waits for notifications to be sent
+func (n *notifier) wait() {
+	if n == nil {
+		return
+	}
+	n.wg.Wait()
+}
+
+// addTotal adds to the number of files progress is calculated from
+func (n *notifier) addTotal(total int) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.total += total
+}
+
+// progress is a DownloadOptions.Progress callback that posts a notification every n.Every percent of files
+func (n *notifier) progress(e *drive.ProgressEvent) {
+	if e.Type != drive.EventFinished && e.Type != drive.EventFailed && e.Type != drive.EventSkipped {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.done++
+	if n.total == 0 {
+		return
+	}
+	pct := 100 * float64(n.done) / float64(n.total)
+	if n.next == 0 {
+		n.next = n.Every
+	}
+	if pct < n.next {
+		return
+	}
+	for n.next <= pct {
+		n.next += n.Every
+	}
+	n.post(fmt.Sprintf("%.0f%% complete (%d of %d files)", pct, n.done, n.total))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// profile is a named set of credentials and default flag values
+type profile struct {
+	// AuthFile is the path to the service account json file for the profile's domain
+	AuthFile string `json:"authfile"`
+	// Domain is appended to -user if it doesn't contain a domain
+	Domain string `json:"domain"`
+	// AllowedUsers restricts the users the profile can impersonate. Entries are email addresses or @domain to allow all users
+	// in a domain. If empty, only the profile's domain is allowed if Domain is set
+	AllowedUsers []string `json:"allowed_users"`
+	// Defaults maps flag names to values used when the flag isn't given on the command line
+	Defaults map[string]string `json:"defaults"`
+}
+
+// lowMemory is the built-in profile applied with -low-memory, for small VMs and NAS devices. It only limits the memory used by
+// concurrent downloads; the listing and tree are still held in memory
+var lowMemory = &profile{Defaults: map[string]string{
+	"downloaders":    "2",
+	"parallel-users": "1",
+}}
+
+// lowMemoryGCPercent is the garbage collection target percentage used with -low-memory
+const lowMemoryGCPercent = 50
+
+// lowMemorySheetRows is the number of rows requested at a time when exporting spreadsheets with the Sheets API with -low-memory
+const lowMemorySheetRows = 500
+
+// profileConfig is the format of the -config file
+type profileConfig struct {
+	Profiles map[string]*profile `json:"profiles"`
+}
+
+// readProfile reads the profile named name from the config file at path
+func readProfile(path, name string) (*profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open config: %w", err)
+	}
+	defer f.Close()
+
+	c := new(profileConfig)
+	if err = json.NewDecoder(f).Decode(c); err != nil {
+		return nil, fmt.Errorf("could not decode config: %w", err)
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %s not found", name)
+	}
+
+	return p, nil
+}
+
+// apply sets the flags that weren't given on the command line to the profile's values. It must be called after flag.Parse
+func (p *profile) apply() error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for name, value := range p.Defaults {
+		if name == "config" || name == "profile" {
+			return fmt.Errorf("%s can't be set in a profile", name)
+		}
+		if set[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", name, err)
+		}
+	}
+
+	if p.AuthFile != "" && !set["authfile"] {
+		if err := flag.Set("authfile", p.AuthFile); err != nil {
+			return err
+		}
+	}
+
+	if user := flag.Lookup("user").Value.String(); p.Domain != "" && user != "" && !strings.Contains(user, "@") {
+		if err := flag.Set("user", user+"@"+p.Domain); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// allowed returns true if user is allowed to be impersonated by the profile
+func (p *profile) allowed(user string) bool {
+	allowed := p.AllowedUsers
+	if len(allowed) == 0 {
+		if p.Domain == "" {
+			return true
+		}
+		allowed = []string{"@" + p.Domain}
+	}
+
+	user = strings.ToLower(user)
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if user == a || (strings.HasPrefix(a, "@") && strings.HasSuffix(user, a)) {
+			return true
+		}
+	}
+	return false
+}